@@ -0,0 +1,40 @@
+package cdbmap
+
+// Advice tells the operating system how a database is about to be read,
+// so that it can tune readahead and the page cache to the workload.
+type Advice int
+
+const (
+	// AdviceNormal restores the default behavior.
+	AdviceNormal Advice = iota
+
+	// AdviceRandom suits random lookups: readahead is disabled, so each
+	// lookup reads only the pages it needs.
+	AdviceRandom
+
+	// AdviceSequential suits dumps and scans: readahead is increased and
+	// pages already read may be dropped early.
+	AdviceSequential
+
+	// AdviceWillNeed starts reading the whole database into the page
+	// cache in the background, ahead of a burst of lookups.
+	AdviceWillNeed
+
+	// AdviceDontNeed drops the database from the page cache, for example
+	// after a one-off scan of a file that should not push out others.
+	AdviceDontNeed
+)
+
+// Advise passes a on to the operating system for the file read by a
+// Reader from Open, with posix_fadvise, or the mapping of a Reader from
+// OpenMmap, with madvise.  It does nothing for other Readers, and on
+// platforms without the calls, since the advice is only a hint.
+func (c *Reader) Advise(a Advice) error {
+	switch {
+	case c.mapped != nil:
+		return madvise(c.mapped, a)
+	case c.file != nil:
+		return fadvise(c.file, a)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64)
+
+package cdbmap
+
+import (
+	"os"
+	"syscall"
+)
+
+// The POSIX_FADV and MADV values for each Advice, which match on these
+// architectures.
+var adviceFlags = [...]int{
+	AdviceNormal:     syscall.MADV_NORMAL,
+	AdviceRandom:     syscall.MADV_RANDOM,
+	AdviceSequential: syscall.MADV_SEQUENTIAL,
+	AdviceWillNeed:   syscall.MADV_WILLNEED,
+	AdviceDontNeed:   syscall.MADV_DONTNEED,
+}
+
+func fadvise(f *os.File, a Advice) error {
+	if a < 0 || int(a) >= len(adviceFlags) {
+		return syscall.EINVAL
+	}
+	// An offset and length of 0 cover the whole file.
+	_, _, errno := syscall.Syscall6(syscall.SYS_FADVISE64, f.Fd(), 0, 0, uintptr(adviceFlags[a]), 0, 0)
+	if errno != 0 {
+		return &os.PathError{Op: "fadvise", Path: f.Name(), Err: errno}
+	}
+	return nil
+}
+
+func madvise(b []byte, a Advice) error {
+	if a < 0 || int(a) >= len(adviceFlags) {
+		return syscall.EINVAL
+	}
+	return syscall.Madvise(b, adviceFlags[a])
+}
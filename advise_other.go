@@ -0,0 +1,13 @@
+//go:build !(linux && (amd64 || arm64 || loong64 || mips64 || mips64le || ppc64 || ppc64le || riscv64))
+
+package cdbmap
+
+import "os"
+
+func fadvise(f *os.File, a Advice) error {
+	return nil
+}
+
+func madvise(b []byte, a Advice) error {
+	return nil
+}
@@ -0,0 +1,92 @@
+package cdbmap
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// AppendFile adds the keys/values in extra to the named database, after
+// its existing records.  The existing data section is copied to the new
+// file byte for byte, without being decoded, and the hashes of its records
+// are taken from the old hash tables, so only the new records are hashed;
+// the hash tables are then rebuilt for all records.  The result replaces
+// filename atomically, as ToFile does.  New keys are added in sorted order.
+//
+// Databases with checksums keep them.  Databases written with
+// WriterOptions.Expiry, PackValues or a custom Hash cannot be told apart
+// from standard ones and must not be appended to.
+func AppendFile(filename string, extra map[string][]string) error {
+	src, err := openFile(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	f, t, err := readHeader(src)
+	if err != nil {
+		return err
+	}
+	if f.indexFirst(&t) {
+		return fmt.Errorf("%s: cannot append to an index-first database", filename)
+	}
+	eod := t[0].pos
+	if eod < f.headerSize() {
+		return corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", eod)
+	}
+
+	// Gather the existing slots, each table in record order so that the
+	// values of a key stay in the order they were written.
+	htables := make(map[uint32][]slot)
+	var nrecs uint64
+	err = walkSlots(src, f, &t, func(_ int, _, h, pos uint64) error {
+		if pos == 0 {
+			return nil
+		}
+		if pos < f.headerSize() || pos >= eod {
+			return corruptf(ErrCorruptHeader, "record pointer %d outside data section", pos)
+		}
+		htables[uint32(h)%256] = append(htables[uint32(h)%256], slot{uint32(h), pos})
+		nrecs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, slots := range htables {
+		sort.Slice(slots, func(i, j int) bool { return slots[i].pos < slots[j].pos })
+	}
+
+	keys := make([]string, 0, len(extra))
+	for k := range extra {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	opts := WriterOptions{Format: f, Checksum: readChecksumTrailer(src, f, &t) != nil}
+	return writeFile(filename, func(tmp *os.File) error {
+		cw, err := NewWriterWithOptions(tmp, opts)
+		if err != nil {
+			return err
+		}
+		defer cw.Abort()
+
+		data := io.NewSectionReader(src, int64(f.headerSize()), int64(eod-f.headerSize()))
+		if _, err = io.Copy(cw.wb, data); err != nil {
+			return err
+		}
+		cw.htables, cw.pos, cw.nrecs = htables, eod, nrecs
+
+		for _, k := range keys {
+			key := []byte(k)
+			for _, v := range extra[k] {
+				if err = cw.Put(key, []byte(v)); err != nil {
+					return err
+				}
+			}
+		}
+
+		return cw.Close()
+	})
+}
@@ -0,0 +1,120 @@
+package cdbmap
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"io"
+	"os"
+)
+
+var (
+	// ErrMemberNotFound is returned by OpenInArchive when the archive has no
+	// member of the given name.
+	ErrMemberNotFound = errors.New("archive member not found")
+
+	// ErrMemberCompressed is returned by OpenInArchive for a zip member
+	// that is not stored uncompressed, and so cannot be read in place.
+	ErrMemberCompressed = errors.New("archive member is compressed")
+)
+
+// NewSection returns a Reader for a cdb occupying the n bytes of r starting
+// at off, such as a database embedded in a larger container file.  All
+// positions in the database are taken relative to off.
+func NewSection(r io.ReaderAt, off, n int64) (*Reader, error) {
+	return New(io.NewSectionReader(r, off, n))
+}
+
+// OpenSection opens the named file and returns a Reader for the cdb
+// occupying its n bytes starting at off.  The Reader should be closed with
+// Close when no longer needed.
+func OpenSection(filename string, off, n int64) (*Reader, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := NewSection(f, off, n)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+
+	return c, nil
+}
+
+// OpenInArchive opens the cdb stored as member name of the named zip or tar
+// archive, and queries it in place without extracting it.  Zip members must
+// be stored uncompressed (zip -0); compressed tar archives are not
+// supported.  The Reader should be closed with Close when no longer needed.
+func OpenInArchive(filename, name string) (*Reader, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	off, n, err := findMember(f, name)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c, err := NewSection(f, off, n)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+
+	return c, nil
+}
+
+// findMember returns the offset and size of the data of member name in the
+// zip or tar archive f.
+func findMember(f *os.File, name string) (off, n int64, err error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return
+	}
+
+	zr, err := zip.NewReader(f, fi.Size())
+	if err == nil {
+		for _, zf := range zr.File {
+			if zf.Name != name {
+				continue
+			}
+			if zf.Method != zip.Store {
+				return 0, 0, ErrMemberCompressed
+			}
+			off, err = zf.DataOffset()
+			return off, int64(zf.UncompressedSize64), err
+		}
+		return 0, 0, ErrMemberNotFound
+	}
+	if err != zip.ErrFormat {
+		return
+	}
+
+	// Not a zip file, so read it as a tar archive.  tar.Reader consumes
+	// each header exactly, so after Next the file offset is where the
+	// member's data starts.
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return
+	}
+	tr := tar.NewReader(f)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return 0, 0, ErrMemberNotFound
+		}
+		if err != nil {
+			return 0, 0, err
+		}
+		if hdr.Name != name || hdr.Typeflag != tar.TypeReg {
+			continue
+		}
+		off, err = f.Seek(0, io.SeekCurrent)
+		return off, hdr.Size, err
+	}
+}
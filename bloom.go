@@ -0,0 +1,113 @@
+package cdbmap
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// A bloom filter sidecar, conventionally named after the database with
+// ".bf" appended, lets a Reader answer most lookups for missing keys
+// without probing the hash tables.  It is built from the keys' cdb hashes,
+// so a lookup needs no extra hashing, and is laid out as bloomMagic, the
+// number of bits and of hash functions (little-endian uint64 and uint32),
+// and the bits.
+const (
+	bloomMagic      = "cdbmapB1"
+	bloomHeaderSize = len(bloomMagic) + 8 + 4
+
+	// DefaultBloomFalsePositiveRate is the false positive rate of a bloom
+	// filter when WriterOptions.BloomFalsePositiveRate is not set.
+	DefaultBloomFalsePositiveRate = 0.01
+)
+
+type bloomFilter struct {
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint32 // number of hash functions
+}
+
+// newBloomFilter returns an empty filter sized for n keys at false
+// positive rate p.
+func newBloomFilter(n uint64, p float64) *bloomFilter {
+	if n == 0 {
+		n = 1
+	}
+	m := uint64(math.Ceil(-float64(n) * math.Log(p) / (math.Ln2 * math.Ln2)))
+	m = (m + 63) / 64 * 64
+	k := uint32(math.Round(float64(m) / float64(n) * math.Ln2))
+	if k < 1 {
+		k = 1
+	}
+	return &bloomFilter{bits: make([]uint64, m/64), m: m, k: k}
+}
+
+// bloomHashes derives the two hashes combined for each of a filter's hash
+// functions from a key's cdb hash.
+func bloomHashes(h uint32) (uint64, uint64) {
+	z := uint64(h) + 0x9e3779b97f4a7c15
+	z = (z ^ z>>30) * 0xbf58476d1ce4e5b9
+	z = (z ^ z>>27) * 0x94d049bb133111eb
+	z ^= z >> 31
+	return uint64(h), z | 1
+}
+
+func (b *bloomFilter) add(h uint32) {
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		b.bits[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// mayContain reports whether a key with cdb hash h may be in the database.
+func (b *bloomFilter) mayContain(h uint32) bool {
+	h1, h2 := bloomHashes(h)
+	for i := uint64(0); i < uint64(b.k); i++ {
+		bit := (h1 + i*h2) % b.m
+		if b.bits[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (b *bloomFilter) writeTo(w io.Writer) error {
+	wb := bufio.NewWriter(w)
+	buf := make([]byte, bloomHeaderSize)
+	copy(buf, bloomMagic)
+	binary.LittleEndian.PutUint64(buf[len(bloomMagic):], b.m)
+	binary.LittleEndian.PutUint32(buf[len(bloomMagic)+8:], b.k)
+	wb.Write(buf)
+	for _, word := range b.bits {
+		binary.LittleEndian.PutUint64(buf, word)
+		wb.Write(buf[:8])
+	}
+	return wb.Flush()
+}
+
+// readBloomFilter reads a filter written by writeTo from r.
+func readBloomFilter(r io.Reader) (*bloomFilter, error) {
+	rb := bufio.NewReader(r)
+	buf := make([]byte, bloomHeaderSize)
+	if _, err := io.ReadFull(rb, buf); err != nil || string(buf[:len(bloomMagic)]) != bloomMagic {
+		return nil, BadFormatError
+	}
+	m := binary.LittleEndian.Uint64(buf[len(bloomMagic):])
+	k := binary.LittleEndian.Uint32(buf[len(bloomMagic)+8:])
+	if m == 0 || m%64 != 0 || k == 0 {
+		return nil, corruptf(ErrCorruptHeader, "bad bloom filter size")
+	}
+
+	data, err := readFull(rb, nil, m/8)
+	if err != nil {
+		return nil, corrupt(ErrCorruptHeader, err)
+	}
+	b := &bloomFilter{bits: make([]uint64, m/64), m: m, k: k}
+	for i := range b.bits {
+		b.bits[i] = binary.LittleEndian.Uint64(data[8*i:])
+	}
+
+	return b, nil
+}
@@ -0,0 +1,87 @@
+package cdbmap
+
+import (
+	"fmt"
+	"io"
+)
+
+// DuplicatePolicy decides what a Builder does with a key that is put more
+// than once.
+type DuplicatePolicy int
+
+const (
+	// DuplicatesAppend stores every value, as Writer does.
+	DuplicatesAppend DuplicatePolicy = iota
+
+	// DuplicatesReplace keeps only the last value put for a key.
+	DuplicatesReplace
+
+	// DuplicatesError makes Put fail with ErrDuplicateKey when a key is
+	// put again.
+	DuplicatesError
+)
+
+// Builder writes a cdb like Writer, enforcing a DuplicatePolicy as records
+// are put.  With DuplicatesAppend and DuplicatesError records are streamed
+// to the Writer as they arrive, though DuplicatesError remembers every key
+// put; DuplicatesReplace holds all records in memory until Close, since a
+// later value may replace any earlier one.
+type Builder struct {
+	cw      *Writer
+	policy  DuplicatePolicy
+	seen    map[string]int // index into pending for DuplicatesReplace
+	pending []Record
+}
+
+// NewBuilder returns a Builder that writes a standard cdb to w, handling
+// duplicate keys by policy.
+func NewBuilder(w io.WriteSeeker, policy DuplicatePolicy) (*Builder, error) {
+	cw, err := NewWriter(w)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Builder{cw: cw, policy: policy}
+	if policy != DuplicatesAppend {
+		b.seen = make(map[string]int)
+	}
+
+	return b, nil
+}
+
+// Put adds a record, applying the Builder's DuplicatePolicy if key has
+// been put before.
+func (b *Builder) Put(key, value []byte) error {
+	switch b.policy {
+	case DuplicatesReplace:
+		v := append([]byte(nil), value...)
+		if i, ok := b.seen[string(key)]; ok {
+			b.pending[i].Value = v
+			return nil
+		}
+		b.seen[string(key)] = len(b.pending)
+		b.pending = append(b.pending, Record{append([]byte(nil), key...), v})
+		return nil
+
+	case DuplicatesError:
+		if _, ok := b.seen[string(key)]; ok {
+			return fmt.Errorf("%w: %q", ErrDuplicateKey, key)
+		}
+		b.seen[string(key)] = 0
+	}
+
+	return b.cw.Put(key, value)
+}
+
+// Close writes any records still held and then the hash tables and
+// header.  It does not close the underlying io.WriteSeeker.
+func (b *Builder) Close() error {
+	for _, rec := range b.pending {
+		if err := b.cw.Put(rec.Key, rec.Value); err != nil {
+			return err
+		}
+	}
+	b.pending = nil
+
+	return b.cw.Close()
+}
@@ -0,0 +1,167 @@
+package cdbmap
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// CachedReader keeps the values of recently used keys in memory in front of
+// a WatchingReader, evicting the least recently used keys once they take
+// more than a byte budget.  Keys that are not found are cached too.  The
+// whole cache is dropped whenever the WatchingReader reopens its file, so a
+// lookup never returns values from a replaced database.
+//
+// The slices returned by GetAll and GetFirst are shared with the cache and
+// must not be modified.  A CachedReader is safe for concurrent use.
+type CachedReader struct {
+	w        *WatchingReader
+	maxBytes int
+	owned    bool
+
+	mu      sync.Mutex
+	gen     uint64
+	size    int
+	lru     *list.List // of *cacheEntry, most recently used first
+	entries map[string]*list.Element
+}
+
+type cacheEntry struct {
+	key    string
+	values [][]byte // nil if the key was not found
+	size   int
+}
+
+// NewCached returns a CachedReader that caches up to maxBytes of keys and
+// values read from w.  Closing the CachedReader does not close w.
+func NewCached(w *WatchingReader, maxBytes int) *CachedReader {
+	return &CachedReader{
+		w:        w,
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		entries:  make(map[string]*list.Element),
+	}
+}
+
+// OpenCached watches the named cdb file as Watch does and returns a
+// CachedReader for it holding up to maxBytes of keys and values.  The
+// CachedReader should be closed with Close when no longer needed.
+func OpenCached(filename string, interval time.Duration, maxBytes int) (*CachedReader, error) {
+	w, err := Watch(filename, interval)
+	if err != nil {
+		return nil, err
+	}
+
+	c := NewCached(w, maxBytes)
+	c.owned = true
+
+	return c, nil
+}
+
+// Close closes the WatchingReader if it was opened by OpenCached.
+func (c *CachedReader) Close() error {
+	if !c.owned {
+		return nil
+	}
+	return c.w.Close()
+}
+
+// Get returns all values stored under key, as Reader.Get does.
+func (c *CachedReader) Get(key string) ([]string, error) {
+	values, err := c.GetAll([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+
+	return s, nil
+}
+
+// GetFirst returns the first value stored under key, as Reader.GetFirst
+// does.
+func (c *CachedReader) GetFirst(key []byte) ([]byte, error) {
+	values, err := c.GetAll(key)
+	if err != nil {
+		return nil, err
+	}
+	return values[0], nil
+}
+
+// Exists reports whether key is present, as Reader.Exists does.
+func (c *CachedReader) Exists(key []byte) (bool, error) {
+	_, err := c.GetAll(key)
+	if err == ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// GetAll returns all values stored under key, as Reader.GetAll does.
+func (c *CachedReader) GetAll(key []byte) ([][]byte, error) {
+	// Holding the read lock keeps the database from being swapped until
+	// the values read from it are cached.
+	c.w.mu.RLock()
+	defer c.w.mu.RUnlock()
+
+	c.mu.Lock()
+	if c.gen != c.w.gen {
+		c.reset(c.w.gen)
+	}
+	if el, ok := c.entries[string(key)]; ok {
+		c.lru.MoveToFront(el)
+		values := el.Value.(*cacheEntry).values
+		c.mu.Unlock()
+		if values == nil {
+			return nil, ErrNotFound
+		}
+		return values, nil
+	}
+	c.mu.Unlock()
+
+	values, err := c.w.c.GetAll(key)
+	if err != nil && err != ErrNotFound {
+		return nil, err
+	}
+	c.add(string(key), values)
+
+	return values, err
+}
+
+// add caches values under key, evicting old entries to stay within the
+// byte budget.
+func (c *CachedReader) add(key string, values [][]byte) {
+	e := &cacheEntry{key: key, values: values, size: len(key)}
+	for _, v := range values {
+		e.size += len(v)
+	}
+	if e.size > c.maxBytes {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Another lookup may have cached the key in the meantime.
+	if _, ok := c.entries[key]; ok {
+		return
+	}
+	c.entries[key] = c.lru.PushFront(e)
+	c.size += e.size
+	for c.size > c.maxBytes {
+		old := c.lru.Remove(c.lru.Back()).(*cacheEntry)
+		delete(c.entries, old.key)
+		c.size -= old.size
+	}
+}
+
+// reset empties the cache for database generation gen.
+func (c *CachedReader) reset(gen uint64) {
+	c.gen = gen
+	c.size = 0
+	c.lru.Init()
+	c.entries = make(map[string]*list.Element)
+}
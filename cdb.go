@@ -5,174 +5,393 @@
 package cdbmap
 
 import (
-	"bufio"
-	"encoding/binary"
+	"bytes"
+	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
+	"path/filepath"
 )
 
 const (
 	HeaderSize = uint32(256 * 8)
+
+	// EmptyFileSize is the size of a standard cdb with no records, which
+	// is all header: every hash table is empty and starts right after it.
+	EmptyFileSize = int(HeaderSize)
 )
 
-// Return the map of all the keys/values
+// Read returns the map of all the keys/values.  The values of each key are
+// in the order they were written.  A database with no records reads as an
+// empty, non-nil map.  A truncated or corrupt database is
+// reported as ErrCorruptHeader or ErrCorruptRecord.
 func Read(r io.ReaderAt) (map[string][]string, error) {
+	return ReadContext(context.Background(), r)
+}
+
+// ReadWithOptions is like Read, but reads the database through a Reader
+// configured by opts, so that values are checked and decoded as the
+// options say, and with VerifyHashes every record is checked against the
+// hash tables.
+func ReadWithOptions(r io.ReaderAt, opts ReaderOptions) (map[string][]string, error) {
+	c, err := NewWithOptions(r, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	m := make(map[string][]string)
-	readNums := makeNumsReader(r)
-	read := makeReader(r)
-
-	last, _ := readNums(0)
-
-	var klen, dlen uint32
-	for pos := HeaderSize; pos < last; pos = pos + 8 + klen + dlen {
-		klen, dlen = readNums(pos)
-		kval := make([]byte, klen)
-		dval := make([]byte, dlen)
-		if err := read(kval, pos + 8); err != nil {
-			return nil, err
-		}
-		if err := read(dval, pos + 8 + klen); err != nil {
-			return nil, err
-		}
+	err = c.Iterate(func(key, value []byte) error {
+		k := string(key)
+		m[k] = append(m[k], string(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
 
-		m[string(kval)] = append(m[string(kval)], string(dval))
+// ReadContext is like Read, but checks ctx between records and stops with
+// ctx.Err() once ctx is done.
+func ReadContext(ctx context.Context, r io.ReaderAt) (map[string][]string, error) {
+	m := make(map[string][]string)
+	err := Iterate(r, func(key, value []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		k := string(key)
+		m[k] = append(m[k], string(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	return m, nil
 }
 
-// Write takes the map in m and writes it to an io.WriteSeeker
-func Write(m map[string][]string, w io.WriteSeeker) (err error) {
-	if _, err = w.Seek(int64(HeaderSize), 0); err != nil {
-		return
+// internMaxLen is the longest value ReadIntoInterned interns.  Short values
+// are the ones likely to repeat, such as flags and enumerations.
+const internMaxLen = 64
+
+// ReadInto is like Read, but stores the keys/values in m, which is cleared
+// first, so that a map can be reused from one reload to the next.
+func ReadInto(r io.ReaderAt, m map[string][]string) error {
+	return readInto(r, m, false)
+}
+
+// ReadIntoInterned is like ReadInto, but equal values of up to 64 bytes
+// share a single string, which saves memory when many keys have the same
+// few values.
+func ReadIntoInterned(r io.ReaderAt, m map[string][]string) error {
+	return readInto(r, m, true)
+}
+
+func readInto(r io.ReaderAt, m map[string][]string, intern bool) error {
+	clear(m)
+
+	var strs map[string]string
+	if intern {
+		strs = make(map[string]string)
 	}
+	return Iterate(r, func(key, value []byte) error {
+		var v string
+		if intern && len(value) <= internMaxLen {
+			var ok bool
+			if v, ok = strs[string(value)]; !ok {
+				v = string(value)
+				strs[v] = v
+			}
+		} else {
+			v = string(value)
+		}
 
-	wb := bufio.NewWriter(w)
-	hash := cdbHash()
-	hw := io.MultiWriter(hash, wb)
-	pos := HeaderSize
-	buf := make([]byte, 8)
-	htables := make(map[uint32][]slot)
+		k := string(key)
+		m[k] = append(m[k], v)
+		return nil
+	})
+}
 
-	for kstring, values := range m {
-		key := []byte(kstring)
-		klen := uint32(len(key))
-		for _, dstring := range values {
-			data := []byte(dstring)
-			dlen := uint32(len(data))
-			writeNums(wb, klen, dlen, buf)
-
-			hash.Reset()
-			hw.Write(key)
-			wb.Write(data)
-
-			h := hash.Sum32()
-			tableNum := h % 256
-			htables[tableNum] = append(htables[tableNum], slot{h, pos})
-			pos += 8 + klen + dlen
+// ReadPrefix is like Read, but returns only the keys beginning with prefix.
+// Every record is still scanned, but only matching ones are kept.
+func ReadPrefix(r io.ReaderAt, prefix string) (map[string][]string, error) {
+	p := []byte(prefix)
+	m := make(map[string][]string)
+	err := Iterate(r, func(key, value []byte) error {
+		if bytes.HasPrefix(key, p) {
+			k := string(key)
+			m[k] = append(m[k], string(value))
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	maxSlots := 0
-	for _, slots := range htables {
-		if len(slots) > maxSlots {
-			maxSlots = len(slots)
+	return m, nil
+}
+
+// ReadNamespace is like ReadPrefix, but returns only the keys in namespace
+// ns, those beginning with ns followed by a colon, with that prefix
+// stripped.  Namespaces let several logical tables, written with
+// WriteNamespace, share one database.
+func ReadNamespace(r io.ReaderAt, ns string) (map[string][]string, error) {
+	p := []byte(ns + ":")
+	m := make(map[string][]string)
+	err := Iterate(r, func(key, value []byte) error {
+		if bytes.HasPrefix(key, p) {
+			k := string(key[len(p):])
+			m[k] = append(m[k], string(value))
 		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
 
-	slotTable := make([]slot, maxSlots * 2)
+	return m, nil
+}
 
-	header := make([]byte, HeaderSize)
-	for i := uint32(0); i < 256; i++ {
-		slots := htables[i]
-		if slots == nil {
-			putNum(header[i * 8:], pos)
-			continue
+// WriteNamespace puts the keys/values in m to w in namespace ns, with each
+// key prefixed by ns and a colon, to be read back by ReadNamespace.  Call
+// it once for each namespace before closing w.
+func WriteNamespace(w *Writer, ns string, m map[string][]string) error {
+	key := []byte(ns + ":")
+	n := len(key)
+	for k, values := range m {
+		key = append(key[:n], k...)
+		for _, v := range values {
+			if err := w.Put(key, []byte(v)); err != nil {
+				return err
+			}
 		}
+	}
 
-		nslots := uint32(len(slots) * 2)
-		hashSlotTable := slotTable[:nslots]
+	return nil
+}
 
-		for j:= 0; j < len(hashSlotTable); j++ {
-			hashSlotTable[j].h = 0
-			hashSlotTable[j].pos = 0
-		}
+// Write takes the map in m and writes it to an io.WriteSeeker.  Keys are
+// written in map iteration order, which varies from run to run; use
+// WriteRecords for reproducible output.  An empty or nil map is written as
+// a database of EmptyFileSize bytes.
+func Write(m map[string][]string, w io.WriteSeeker) error {
+	return WriteContext(context.Background(), m, w)
+}
+
+// WriteContext is like Write, but checks ctx between records and stops with
+// ctx.Err() once ctx is done.  The database is left incomplete in that case.
+func WriteContext(ctx context.Context, m map[string][]string, w io.WriteSeeker) (err error) {
+	cw, err := NewWriter(w)
+	if err != nil {
+		return
+	}
+	defer cw.Abort()
 
-		for _, slot := range slots {
-			slotPos := (slot.h / 256) % nslots
-			if hashSlotTable[slotPos].pos != 0 {
-				slotPos++
-				if slotPos == uint32(len(hashSlotTable)) {
-					slotPos = 0
-				}
+	// Reuse the conversion buffers and hash each key once.
+	var key, data []byte
+	for kstring, values := range m {
+		key = append(key[:0], kstring...)
+		h := cw.hashKey(key)
+		for _, dstring := range values {
+			if err = ctx.Err(); err != nil {
+				return
+			}
+			data = append(data[:0], dstring...)
+			if err = cw.put(key, h, 0, data); err != nil {
+				return
 			}
-			hashSlotTable[slotPos] = slot
 		}
+	}
 
-		if err = writeSlots(wb, hashSlotTable, buf); err != nil {
-			return
-		}
+	return cw.Close()
+}
 
+// WriteToBytes returns the map in m as a cdb held in memory, for callers
+// with no file to write to, such as tests and programs that send the
+// database over the network.
+func WriteToBytes(m map[string][]string) ([]byte, error) {
+	buf := bytes.NewBuffer(nil)
+	if err := WriteStream(m, buf); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
 
-		putNum(header[i*8:], pos)
-		putNum(header[i*8+4:], nslots)
-		pos += 8 * nslots
+// ReadBytes returns the map of all the keys/values of the cdb in b, as Read
+// does.
+func ReadBytes(b []byte) (map[string][]string, error) {
+	return Read(bytes.NewReader(b))
+}
+
+// BuildReader returns a Reader over the map in m, written to a cdb held in
+// memory, for tests and small programs that want lookups without a file.
+func BuildReader(m map[string][]string) (*Reader, error) {
+	b, err := WriteToBytes(m)
+	if err != nil {
+		return nil, err
 	}
 
-	if err = wb.Flush(); err != nil {
+	return New(bytes.NewReader(b))
+}
+
+// Record is a single key/value pair.
+type Record struct {
+	Key, Value []byte
+}
+
+// WriteRecords writes records to an io.WriteSeeker in the order given, so
+// the same records always produce byte-identical output.  Records with the
+// same key become multiple values for that key.
+func WriteRecords(records []Record, w io.WriteSeeker) (err error) {
+	cw, err := NewWriter(w)
+	if err != nil {
 		return
 	}
+	defer cw.Abort()
 
-	if _, err = w.Seek(0, 0); err != nil {
+	for _, rec := range records {
+		if err = cw.Put(rec.Key, rec.Value); err != nil {
+			return
+		}
+	}
+
+	return cw.Close()
+}
+
+// WriteFromChannel writes the records received from records to an
+// io.WriteSeeker, in the order received, until the channel is closed.  The
+// Writer holds only hash table slots, so producers in other goroutines can
+// stream any number of records without building a map first.  If writing
+// fails, the rest of the channel is drained so that producers do not block,
+// and the first error is returned.
+func WriteFromChannel(w io.WriteSeeker, records <-chan Record) (err error) {
+	defer func() {
+		if err != nil {
+			for range records {
+			}
+		}
+	}()
+
+	cw, err := NewWriter(w)
+	if err != nil {
 		return
 	}
+	defer cw.Abort()
 
-	if _, err = w.Write(header); err != nil { return }
+	for rec := range records {
+		if err = cw.Put(rec.Key, rec.Value); err != nil {
+			return
+		}
+	}
 
-	return
+	return cw.Close()
 }
 
 // FromFile is a convenience function that reads a CDB-formatted
 // file from the specified filename, and returns the CDB contents
 // in map[string][]string form (or an error if the map can't
-// be written for some reason).
+// be written for some reason).  The header is checked with DetectFormat
+// first, so a file that is not a cdb at all is reported as such.
 func FromFile(filename string) (map[string][]string, error) {
-	f, err := os.Open(filename)
+	f, err := openFile(filename)
 	if err != nil {
 		return nil, err
 	}
+	defer f.Close()
+
+	fi, err := DetectFormat(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if !fi.Valid {
+		return nil, corruptf(ErrCorruptHeader, "%s: %s", filename, fi)
+	}
+
 	return Read(f)
 }
 
 // ToFile is a convenience function that writes a map to the provided
-// filename in CDB format.
-func ToFile(m map[string][]string, f string) (err error) {
-	tmp, err := ioutil.TempFile("", f)
-	if err != nil { return }
+// filename in CDB format.  The database is written to a temporary file in
+// the same directory, synced to disk, and then renamed over filename, so
+// readers never see a partially written database.  Readers on Windows
+// should open the database with Open, OpenMmap or FromFile, which allow it
+// to be renamed over while open; use Lock and RLock to coordinate further.
+func ToFile(m map[string][]string, filename string) error {
+	return writeFile(filename, func(f *os.File) error {
+		return Write(m, f)
+	})
+}
 
-	w, err := os.OpenFile(tmp.Name(), os.O_RDWR | os.O_CREATE, 0644)
-	if err != nil { return }
+// Update reads the named database into a map, calls apply to modify it,
+// and atomically writes the result back to filename as ToFile does.  If
+// apply returns an error the file is left untouched and the error is
+// returned.
+func Update(filename string, apply func(m map[string][]string) error) error {
+	m, err := FromFile(filename)
+	if err != nil {
+		return err
+	}
 
-	r := Write(m, w)
-	if err = os.Rename(tmp.Name(), f); err != nil { return }
+	if err = apply(m); err != nil {
+		return err
+	}
 
-	return r
+	return ToFile(m, filename)
 }
 
-func makeNumsReader(r io.ReaderAt) (func (uint32) (uint32, uint32)) {
-	buf := make([]byte, 64)
-	return func(pos uint32) (uint32, uint32) {
-		if _, err := r.ReadAt(buf[:8], int64(pos)); err != nil {
-			panic(err)
+// writeFile atomically replaces filename with the database written by fn.
+// On Linux the database is written to an unnamed O_TMPFILE file, so a
+// crash while writing leaves nothing behind.  Once complete it is linked
+// into the directory under a temporary name and renamed over filename; a
+// crash between the two can still leave that complete temporary file,
+// named base.tmpNNN like the named temporary file used elsewhere.  The
+// temporary file is removed if anything fails.
+func writeFile(filename string, fn func(f *os.File) error) (err error) {
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+
+	var name string // the temporary file's name, once it has one
+	tmp, err := openTmpfile(dir)
+	if err != nil {
+		if tmp, err = ioutil.TempFile(dir, base+".tmp"); err != nil {
+			return
 		}
-		return binary.LittleEndian.Uint32(buf), binary.LittleEndian.Uint32(buf[4:])
+		name = tmp.Name()
 	}
-}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			if name != "" {
+				os.Remove(name)
+			}
+		}
+	}()
 
-func makeReader(r io.ReaderAt) (func ([]byte, uint32) error) {
-	return func(buf []byte, pos uint32) error {
-		_, err := r.ReadAt(buf, int64(pos))
-		return err
+	if err = tmp.Chmod(0644); err != nil {
+		return
+	}
+	if err = fn(tmp); err != nil {
+		return
+	}
+	if err = tmp.Sync(); err != nil {
+		return
+	}
+	if name == "" {
+		if name, err = linkTmpfile(tmp, dir, base); err != nil {
+			return
+		}
+	}
+	if err = tmp.Close(); err != nil {
+		return
 	}
+	if err = replaceFile(name, filename); err != nil {
+		return
+	}
+
+	return syncDir(dir)
 }
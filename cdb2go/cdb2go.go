@@ -0,0 +1,62 @@
+// cdb2go writes a cdb as Go source declaring a map[string][]string, for
+// use with go:generate:
+//
+//	//go:generate cdb2go -pkg countries -var Names -o names.go names.cdb
+package main
+
+import (
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+var (
+	pkg     = flag.String("pkg", "main", "package `name` of the generated file")
+	varName = flag.String("var", "Data", "`name` of the generated variable")
+	out     = flag.String("o", "", "write to `file` instead of standard output")
+)
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "cdb2go: fatal: %s\n", err)
+	os.Exit(111)
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		fmt.Fprint(os.Stderr, "cdb2go: usage: cdb2go [-pkg name] [-var name] [-o file.go] file.cdb\n")
+		os.Exit(111)
+	}
+
+	m, err := cdbmap.FromFile(flag.Arg(0))
+	if err != nil {
+		fatal(err)
+	}
+
+	if *out == "" {
+		if err = cdbmap.GenerateGo(os.Stdout, m, *pkg, *varName); err != nil {
+			fatal(err)
+		}
+		return
+	}
+
+	// Replace the output only once it is complete.
+	tmp, err := ioutil.TempFile(filepath.Dir(*out), filepath.Base(*out)+".tmp")
+	if err != nil {
+		fatal(err)
+	}
+	err = cdbmap.GenerateGo(tmp, m, *pkg, *varName)
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), *out)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		fatal(err)
+	}
+}
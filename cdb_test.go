@@ -1,13 +1,28 @@
 package cdbmap
 
 import (
+	"archive/tar"
+	"archive/zip"
 	"bufio"
 	"bytes"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/binary"
+	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"io/ioutil"
 	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
+	"testing/fstest"
+	"time"
+	"unsafe"
 )
 
 type rec struct {
@@ -42,46 +57,59 @@ func TestCdb(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error opening %s: %s", tmp.Name(), err)
 	}
+	defer c.Close()
 
-	_, err = c.Data([]byte("does not exist"))
-	if err != io.EOF {
-		t.Fatalf("non-existent key should return io.EOF")
+	_, err = c.Get("does not exist")
+	if err != ErrNotFound {
+		t.Fatalf("non-existent key should return ErrNotFound")
 	}
 
-	for _, rec := range records {
-		key := []byte(rec.key)
-		values := rec.values
+	if ok, err := c.Exists([]byte("does not exist")); ok || err != nil {
+		t.Fatalf("Exists: expected false, got %v (%v)", ok, err)
+	}
+	if ok, err := c.Exists([]byte("one")); !ok || err != nil {
+		t.Fatalf("Exists: expected true, got %v (%v)", ok, err)
+	}
 
-		v, err := c.Data(key)
+	for _, rec := range records {
+		v, err := c.Get(rec.key)
 		if err != nil {
 			t.Fatalf("Record read failed: %s", err)
 		}
 
-		if !bytes.Equal(v, []byte(values[0])) {
-			t.Fatal("Incorrect value returned")
+		if !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("value mismatch: expected %v, got %v", rec.values, v)
 		}
 
-		c.FindStart()
-		for _, value := range values {
-			sr, err := c.FindNext(key)
-			if err != nil {
-				t.Fatalf("Record read failed: %s", err)
-			}
+		first, err := c.GetFirst([]byte(rec.key))
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !bytes.Equal(first, []byte(rec.values[0])) {
+			t.Fatal("Incorrect value returned")
+		}
 
-			data := make([]byte, sr.Size())
-			_, err = sr.Read(data)
-			if err != nil {
-				t.Fatalf("Record read failed: %s", err)
-			}
+		all, err := c.GetAll([]byte(rec.key))
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if len(all) != len(rec.values) {
+			t.Fatalf("expected %d values, got %d", len(rec.values), len(all))
+		}
 
-			if !bytes.Equal(data, []byte(value)) {
-				t.Fatal("value mismatch")
+		for i, value := range rec.values {
+			v, err := c.GetAt([]byte(rec.key), i)
+			if err != nil || string(v) != value {
+				t.Fatalf("GetAt(%s, %d): expected %q, got %q (%v)", rec.key, i, value, v, err)
 			}
 		}
-		// Read all values, so should get EOF
-		_, err = c.FindNext(key)
-		if err != io.EOF {
-			t.Fatalf("Expected EOF, got %s", err)
+		if _, err = c.GetAt([]byte(rec.key), len(rec.values)); err != ErrNotFound {
+			t.Fatalf("GetAt past the last value should return ErrNotFound, got %v", err)
+		}
+
+		n, err := c.Count([]byte(rec.key))
+		if err != nil || n != len(rec.values) {
+			t.Fatalf("Count: expected %d, got %d (%v)", len(rec.values), n, err)
 		}
 	}
 
@@ -99,6 +127,27 @@ func TestCdb(t *testing.T) {
 	if !bytes.Equal(buf.Bytes(), data) {
 		t.Fatalf("Dump round-trip failed")
 	}
+
+	// Test DumpWithOptions
+	for _, tc := range []struct {
+		opts     DumpOptions
+		expected string
+	}{
+		{DumpOptions{Prefix: "t", Sort: true}, "+5,1:three->3\n+5,2:three->33\n+5,3:three->333\n+3,1:two->2\n+3,2:two->22\n\n"},
+		{DumpOptions{Match: regexp.MustCompile("o$"), Offset: 1, Limit: 1}, "+3,2:two->22\n\n"},
+		{DumpOptions{KeysOnly: true, Sort: true, Limit: 2}, "one\nthree\n"},
+	} {
+		if _, err = tmp.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		buf.Reset()
+		if err = DumpWithOptions(buf, tmp, tc.opts); err != nil {
+			t.Fatalf("DumpWithOptions(%+v) failed: %s", tc.opts, err)
+		}
+		if buf.String() != tc.expected {
+			t.Fatalf("DumpWithOptions(%+v): expected %q, got %q", tc.opts, tc.expected, buf.String())
+		}
+	}
 }
 
 func TestEmptyFile(t *testing.T) {
@@ -120,7 +169,7 @@ func TestEmptyFile(t *testing.T) {
 		t.Fatal(err)
 	}
 	rb := bufio.NewReader(tmp)
-	readNum := makeNumReader(rb)
+	readNum := makeNumReader(rb, Format32)
 	for i := 0; i < 256; i++ {
 		_ = readNum() // table pointer
 		tableLen := readNum()
@@ -134,21 +183,1528 @@ func TestEmptyFile(t *testing.T) {
 	if err != nil {
 		t.Fatalf("Error opening %s: %s", tmp.Name(), err)
 	}
+	defer c.Close()
 
-	_, err = c.Data([]byte("does not exist"))
-	if err != io.EOF {
-		t.Fatalf("non-existent key should return io.EOF")
+	_, err = c.Get("does not exist")
+	if err != ErrNotFound {
+		t.Fatalf("non-existent key should return ErrNotFound")
 	}
 }
 
-func init() {
-	b := bytes.NewBuffer(nil)
+func TestEmptyDatabase(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Write(nil, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(full) != EmptyFileSize {
+		t.Fatalf("expected %d bytes, got %d", EmptyFileSize, len(full))
+	}
+	stream := bytes.NewBuffer(nil)
+	if err = WriteStream(nil, stream); err != nil || !bytes.Equal(stream.Bytes(), full) {
+		t.Fatalf("WriteStream differs from Write (%v)", err)
+	}
+
+	for name, read := range map[string]func() (map[string][]string, error){
+		"Read":       func() (map[string][]string, error) { return Read(bytes.NewReader(full)) },
+		"ReadStream": func() (map[string][]string, error) { return ReadStream(bytes.NewReader(full)) },
+	} {
+		m, err := read()
+		if err != nil || m == nil || len(m) != 0 {
+			t.Fatalf("%s: expected an empty map, got %v (%v)", name, m, err)
+		}
+	}
+
+	if err = Verify(bytes.NewReader(full)); err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	if s, err := Stats(bytes.NewReader(full)); err != nil || s.Records != 0 {
+		t.Fatalf("Stats: expected no records, got %+v (%v)", s, err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err = Dump(buf, bytes.NewReader(full)); err != nil || buf.String() != "\n" {
+		t.Fatalf("Dump: expected a lone newline, got %q (%v)", buf, err)
+	}
+
+	c, err := New(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if n, err := c.Len(); err != nil || n != 0 {
+		t.Fatalf("Len: expected 0, got %d (%v)", n, err)
+	}
+	if n, err := c.DataBytes(); err != nil || n != 0 {
+		t.Fatalf("DataBytes: expected 0, got %d (%v)", n, err)
+	}
+	for key, err := range c.Keys() {
+		t.Fatalf("Keys: expected nothing, got %q (%v)", key, err)
+	}
+	if _, err = c.GetFirst(nil); err != ErrNotFound {
+		t.Fatalf("empty key should return ErrNotFound, got %v", err)
+	}
+}
+
+func TestIterate(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	var got []string
+	err = Iterate(tmp, func(key, value []byte) error {
+		got = append(got, string(key)+"="+string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate failed: %s", err)
+	}
+
+	var expected []string
 	for _, rec := range records {
-		key := rec.key
 		for _, value := range rec.values {
-			b.WriteString(fmt.Sprintf("+%d,%d:%s->%s\n", len(key), len(value), key, value))
+			expected = append(expected, rec.key+"="+value)
 		}
 	}
-	b.WriteByte('\n')
-	data = b.Bytes()
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestOpenMmap(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	c, err := OpenMmap(tmp.Name())
+	if err != nil {
+		t.Fatalf("Error mapping %s: %s", tmp.Name(), err)
+	}
+	defer c.Close()
+
+	for _, rec := range records {
+		v, err := c.Get(rec.key)
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("value mismatch: expected %v, got %v", rec.values, v)
+		}
+	}
+}
+
+func TestToFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	m := make(map[string][]string)
+	for _, rec := range records {
+		m[rec.key] = rec.values
+	}
+
+	name := filepath.Join(dir, "test.cdb")
+	if err = ToFile(m, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	got, err := FromFile(name)
+	if err != nil {
+		t.Fatalf("FromFile failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("expected %v, got %v", m, got)
+	}
+
+	err = Update(name, func(m map[string][]string) error {
+		m["four"] = []string{"4"}
+		delete(m, "one")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Update failed: %s", err)
+	}
+	m["four"] = []string{"4"}
+	delete(m, "one")
+	if got, err = FromFile(name); err != nil || !reflect.DeepEqual(got, m) {
+		t.Fatalf("after Update expected %v, got %v (%v)", m, got, err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("expected only the database in %s, found %d files", dir, len(files))
+	}
+}
+
+func TestReadParallel(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for i := 0; i < 10000; i++ {
+		if err = w.Put([]byte(fmt.Sprintf("key%d", i%3000)), []byte(fmt.Sprint(i))); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	expected, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	for _, workers := range []int{0, 1, 7} {
+		got, err := ReadParallel(tmp, workers)
+		if err != nil {
+			t.Fatalf("ReadParallel(%d) failed: %s", workers, err)
+		}
+		if !reflect.DeepEqual(got, expected) {
+			t.Fatalf("ReadParallel(%d) differs from Read", workers)
+		}
+	}
+}
+
+func TestReadInto(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	expected := map[string][]string{"a": {"true"}, "b": {"true", "false"}}
+	if err = Write(expected, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	m := map[string][]string{"stale": {"x"}}
+	if err = ReadInto(tmp, m); err != nil {
+		t.Fatalf("ReadInto failed: %s", err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("ReadInto: expected %v, got %v", expected, m)
+	}
+
+	if err = ReadIntoInterned(tmp, m); err != nil {
+		t.Fatalf("ReadIntoInterned failed: %s", err)
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("ReadIntoInterned: expected %v, got %v", expected, m)
+	}
+	if unsafe.StringData(m["a"][0]) != unsafe.StringData(m["b"][0]) {
+		t.Fatalf("equal values were not interned")
+	}
+}
+
+func TestValues(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	v := Values{}
+	v.Set("one", "1")
+	v.Add("two", "2")
+	v.Add("two", "22")
+	v.Set("gone", "x")
+	v.Del("gone")
+	if err = Write(v, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	m, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	got := Values(m)
+	if got.Get("two") != "2" || got.Get("gone") != "" || got.Has("gone") || !got.Has("one") {
+		t.Fatalf("unexpected values %v", got)
+	}
+	if !reflect.DeepEqual(got, v) {
+		t.Fatalf("expected %v, got %v", v, got)
+	}
+}
+
+func TestReadRefs(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	refs, err := ReadRefs(tmp)
+	if err != nil {
+		t.Fatalf("ReadRefs failed: %s", err)
+	}
+	if len(refs) != len(records) {
+		t.Fatalf("expected %d keys, got %d", len(records), len(refs))
+	}
+	for _, rec := range records {
+		if len(refs[rec.key]) != len(rec.values) {
+			t.Fatalf("expected %d refs for %q, got %d", len(rec.values), rec.key, len(refs[rec.key]))
+		}
+		for i, ref := range refs[rec.key] {
+			v, err := ref.Load()
+			if err != nil || string(v) != rec.values[i] {
+				t.Fatalf("Load: expected %q, got %q (%v)", rec.values[i], v, err)
+			}
+		}
+	}
+}
+
+func TestReadPrefix(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	m, err := ReadPrefix(tmp, "t")
+	if err != nil {
+		t.Fatalf("ReadPrefix failed: %s", err)
+	}
+	expected := map[string][]string{"two": records[1].values, "three": records[2].values}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+}
+
+func TestKeysValues(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	var keys, values, expectedKeys, expectedValues []string
+	for _, rec := range records {
+		expectedKeys = append(expectedKeys, rec.key)
+		expectedValues = append(expectedValues, rec.values...)
+	}
+	for key, err := range c.Keys() {
+		if err != nil {
+			t.Fatalf("Keys failed: %s", err)
+		}
+		keys = append(keys, string(key))
+	}
+	for value, err := range c.Values() {
+		if err != nil {
+			t.Fatalf("Values failed: %s", err)
+		}
+		values = append(values, string(value))
+	}
+
+	if !reflect.DeepEqual(keys, expectedKeys) {
+		t.Fatalf("Keys: expected %v, got %v", expectedKeys, keys)
+	}
+	if !reflect.DeepEqual(values, expectedValues) {
+		t.Fatalf("Values: expected %v, got %v", expectedValues, values)
+	}
+}
+
+func TestStats(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	s, err := Stats(tmp)
+	if err != nil {
+		t.Fatalf("Stats failed: %s", err)
+	}
+
+	var nrecs, klen, dlen, tableRecs uint64
+	for _, rec := range records {
+		for _, value := range rec.values {
+			nrecs++
+			klen += uint64(len(rec.key))
+			dlen += uint64(len(value))
+		}
+	}
+	for _, t := range s.Tables {
+		tableRecs += t.Records
+	}
+
+	if s.Records != nrecs || tableRecs != nrecs || s.Slots != 2*nrecs {
+		t.Fatalf("expected %d records in %d slots, got %+v", nrecs, 2*nrecs, s)
+	}
+	if s.KeyBytes != klen || s.DataBytes != dlen {
+		t.Fatalf("expected %d key and %d data bytes, got %d and %d", klen, dlen, s.KeyBytes, s.DataBytes)
+	}
+
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if n, err := c.Len(); err != nil || uint64(n) != nrecs {
+		t.Fatalf("Len: expected %d, got %d (%v)", nrecs, n, err)
+	}
+	if n, err := c.DataBytes(); err != nil || n != klen+dlen {
+		t.Fatalf("DataBytes: expected %d, got %d (%v)", klen+dlen, n, err)
+	}
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	st, err := c.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	if st.Records != nrecs || st.Tables != s.Tables || st.Size != uint64(fi.Size()) || s.Size != st.Size {
+		t.Fatalf("Stat: expected %d records in %d bytes, got %+v", nrecs, fi.Size(), st)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	if err = VerifyFile(tmp.Name()); err != nil {
+		t.Fatalf("Verify failed on a good database: %s", err)
+	}
+
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Corrupt the first key.
+	bad := append([]byte(nil), full...)
+	bad[HeaderSize+8] ^= 0xff
+	if err = Verify(bytes.NewReader(bad)); !errors.Is(err, ErrCorruptHeader) {
+		t.Fatalf("Verify accepted a corrupted key: %v", err)
+	}
+
+	// Truncate the hash tables.
+	if err = Verify(bytes.NewReader(full[:len(full)-1])); err == nil {
+		t.Fatal("Verify accepted a truncated database")
+	}
+
+	// Slot counts running past the end of the file must not be allocated
+	// for, whether or not the table's end overflows.
+	if err = Verify(bytes.NewReader(wrappedHeader(t))); err == nil {
+		t.Fatal("Verify accepted a table that wraps")
+	}
+	huge := format64Bytes(t)
+	binary.LittleEndian.PutUint64(huge[16*255+8:], 1<<58)
+	if err = Verify(bytes.NewReader(huge)); !errors.Is(err, ErrCorruptHeader) {
+		t.Fatalf("Verify of a table past the end of the file: expected ErrCorruptHeader, got %v", err)
+	}
+}
+
+// wrappedHeader returns a Format64 database in which an empty hash table
+// has been given 1<<60 slots.  Their size wraps to 0, so the tables still
+// appear to follow each other.
+func wrappedHeader(t testing.TB) []byte {
+	b := format64Bytes(t)
+	for i := 0; i < 256; i++ {
+		if binary.LittleEndian.Uint64(b[16*i+8:]) == 0 {
+			binary.LittleEndian.PutUint64(b[16*i+8:], 1<<60)
+			return b
+		}
+	}
+	t.Fatal("no empty hash table")
+	return nil
+}
+
+// format64Bytes returns a small Format64 database.
+func format64Bytes(t testing.TB) []byte {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Format: Format64})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	if err = w.Put([]byte("one"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	b, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestContextCanceled(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	m := map[string][]string{"one": {"1"}}
+	if err = WriteContext(ctx, m, tmp); err != context.Canceled {
+		t.Fatalf("WriteContext: expected context.Canceled, got %v", err)
+	}
+
+	if err = Write(m, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if _, err = ReadContext(ctx, tmp); err != context.Canceled {
+		t.Fatalf("ReadContext: expected context.Canceled, got %v", err)
+	}
+}
+
+func TestOpenFS(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir, name := filepath.Split(tmp.Name())
+	filesystems := []fs.FS{
+		os.DirFS(dir),
+		fstest.MapFS{name: &fstest.MapFile{Data: full}},
+	}
+	for _, fsys := range filesystems {
+		c, err := OpenFS(fsys, name)
+		if err != nil {
+			t.Fatalf("OpenFS failed: %s", err)
+		}
+
+		v, err := c.Get("three")
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, records[2].values) {
+			t.Fatalf("value mismatch: expected %v, got %v", records[2].values, v)
+		}
+		c.Close()
+	}
+}
+
+func TestOpenInArchive(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A tar archive with another member first, and an uncompressed zip.
+	tb := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(tb)
+	for _, m := range []struct {
+		name string
+		data []byte
+	}{{"README", []byte("hello")}, {"data/test.cdb", full}} {
+		tw.WriteHeader(&tar.Header{Name: m.name, Mode: 0644, Size: int64(len(m.data)), Typeflag: tar.TypeReg})
+		tw.Write(m.data)
+	}
+	if err = tw.Close(); err != nil {
+		t.Fatalf("tar failed: %s", err)
+	}
+
+	zb := bytes.NewBuffer(nil)
+	zw := zip.NewWriter(zb)
+	w, err := zw.CreateHeader(&zip.FileHeader{Name: "data/test.cdb", Method: zip.Store})
+	if err != nil {
+		t.Fatalf("zip failed: %s", err)
+	}
+	w.Write(full)
+	if err = zw.Close(); err != nil {
+		t.Fatalf("zip failed: %s", err)
+	}
+
+	for _, archive := range [][]byte{tb.Bytes(), zb.Bytes()} {
+		if err = ioutil.WriteFile(tmp.Name(), archive, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err = OpenInArchive(tmp.Name(), "missing.cdb"); err != ErrMemberNotFound {
+			t.Fatalf("expected ErrMemberNotFound, got %v", err)
+		}
+		c, err := OpenInArchive(tmp.Name(), "data/test.cdb")
+		if err != nil {
+			t.Fatalf("OpenInArchive failed: %s", err)
+		}
+		v, err := c.Get("three")
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, records[2].values) {
+			t.Fatalf("value mismatch: expected %v, got %v", records[2].values, v)
+		}
+		c.Close()
+	}
+}
+
+func TestJSON(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err = DumpJSON(buf, tmp); err != nil {
+		t.Fatalf("DumpJSON failed: %s", err)
+	}
+	expected := "{\n\"one\":[\"1\"],\n\"two\":[\"2\",\"22\"],\n\"three\":[\"3\",\"33\",\"333\"]\n}\n"
+	if buf.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, buf.String())
+	}
+
+	tmp2, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp2.Name())
+
+	if err = MakeJSON(tmp2, buf); err != nil {
+		t.Fatalf("MakeJSON failed: %s", err)
+	}
+	if _, err = tmp2.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	out := bytes.NewBuffer(nil)
+	if err = Dump(out, tmp2); err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data) {
+		t.Fatalf("JSON round-trip failed")
+	}
+}
+
+func TestDiff(t *testing.T) {
+	a := map[string][]string{"one": {"1"}, "two": {"2", "22"}, "three": {"3"}}
+	b := map[string][]string{"one": {"1"}, "two": {"22", "2"}, "four": {"4"}}
+
+	var dbs []io.ReaderAt
+	for _, m := range []map[string][]string{a, b} {
+		buf := bytes.NewBuffer(nil)
+		if err := WriteStream(m, buf); err != nil {
+			t.Fatalf("WriteStream failed: %s", err)
+		}
+		dbs = append(dbs, bytes.NewReader(buf.Bytes()))
+	}
+
+	changes, err := Diff(dbs[0], dbs[1])
+	if err != nil {
+		t.Fatalf("Diff failed: %s", err)
+	}
+	expected := &Changes{Added: []string{"four"}, Removed: []string{"three"}, Changed: []string{"two"}}
+	if !reflect.DeepEqual(changes, expected) {
+		t.Fatalf("expected %+v, got %+v", expected, changes)
+	}
+
+	if changes, err = Diff(dbs[0], dbs[0]); err != nil || !changes.Empty() {
+		t.Fatalf("expected no changes, got %+v (%v)", changes, err)
+	}
+}
+
+func TestLowLevel(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	if err := WriteStream(map[string][]string{"one": {"1"}}, buf); err != nil {
+		t.Fatalf("WriteStream failed: %s", err)
+	}
+	r := bytes.NewReader(buf.Bytes())
+
+	h, err := ParseHeader(buf.Bytes())
+	if err != nil {
+		t.Fatalf("ParseHeader failed: %s", err)
+	}
+	if h2, err := ReadHeader(r); err != nil || !reflect.DeepEqual(h, h2) {
+		t.Fatalf("ReadHeader: expected %+v, got %+v (%v)", h, h2, err)
+	}
+
+	key, data, next, err := ReadRecord(r, h.Format, h.Size())
+	if err != nil || string(key) != "one" || string(data) != "1" || next != h.DataEnd() {
+		t.Fatalf("ReadRecord: got %q, %q, %d (%v)", key, data, next, err)
+	}
+
+	hash := Hash([]byte("one"))
+	slots, err := ReadTable(r, h, int(hash%256))
+	if err != nil {
+		t.Fatalf("ReadTable failed: %s", err)
+	}
+	expected := []SlotRef{{hash, h.Size()}, {0, 0}}
+	if hash/256%2 == 1 {
+		expected[0], expected[1] = expected[1], expected[0]
+	}
+	if !reflect.DeepEqual(slots, expected) {
+		t.Fatalf("ReadTable: expected %v, got %v", expected, slots)
+	}
+}
+
+func TestRecover(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Zero the header and cut the last record short.
+	bad := append([]byte(nil), full[:HeaderSize+8+3+1+8+3+1+8+4]...)
+	for i := 0; i < int(HeaderSize); i++ {
+		bad[i] = 0
+	}
+
+	out, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(out.Name())
+
+	n, err := Recover(bytes.NewReader(bad), out)
+	if err != nil {
+		t.Fatalf("Recover failed: %s", err)
+	}
+	if n != 2 {
+		t.Fatalf("expected 2 records recovered, got %d", n)
+	}
+	m, err := Read(out)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	expected := map[string][]string{records[0].key: records[0].values, records[1].key: records[1].values[:1]}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+}
+
+func TestReadTruncated(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, n := range []int{0, 2, int(HeaderSize) - 1, int(HeaderSize) + 3, int(HeaderSize) + 10} {
+		_, err := Read(bytes.NewReader(full[:n]))
+		if !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("truncated at %d: expected io.ErrUnexpectedEOF, got %v", n, err)
+		}
+		want := ErrCorruptRecord
+		if n < int(HeaderSize) {
+			want = ErrCorruptHeader
+		}
+		if !errors.Is(err, want) {
+			t.Fatalf("truncated at %d: expected %v, got %v", n, want, err)
+		}
+	}
+}
+
+func init() {
+	b := bytes.NewBuffer(nil)
+	for _, rec := range records {
+		key := rec.key
+		for _, value := range rec.values {
+			b.WriteString(fmt.Sprintf("+%d,%d:%s->%s\n", len(key), len(value), key, value))
+		}
+	}
+	b.WriteByte('\n')
+	data = b.Bytes()
+}
+
+func TestLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "test.cdb")
+	l, err := Lock(filename)
+	if err != nil {
+		t.Fatalf("Lock failed: %s", err)
+	}
+
+	locked := make(chan *FileLock)
+	go func() {
+		rl, err := RLock(filename)
+		if err != nil {
+			t.Errorf("RLock failed: %s", err)
+		}
+		locked <- rl
+	}()
+
+	select {
+	case <-locked:
+		t.Fatalf("expected RLock to wait for Unlock")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	if err = ToFile(map[string][]string{"one": {"1"}}, filename); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	if err = l.Unlock(); err != nil {
+		t.Fatalf("Unlock failed: %s", err)
+	}
+
+	rl := <-locked
+	if rl == nil {
+		return
+	}
+	if m, err := FromFile(filename); err != nil || m["one"] == nil {
+		t.Errorf("expected the new database, got %v (%v)", m, err)
+	}
+	if err = rl.Unlock(); err != nil {
+		t.Errorf("Unlock failed: %s", err)
+	}
+}
+
+func TestMakeBadFormat(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	for _, input := range []string{"+3,1:one->1\n", "+3,1:one=>1\n\n", "+x,1:one->1\n\n", "-3,1:one->1\n\n"} {
+		if err = Make(tmp, bytes.NewBufferString(input)); !errors.Is(err, BadFormatError) {
+			t.Errorf("expected BadFormatError for %q, got %v", input, err)
+		}
+	}
+}
+
+func TestWriteToBytes(t *testing.T) {
+	m := make(map[string][]string)
+	for _, rec := range records {
+		m[rec.key] = rec.values
+	}
+
+	b, err := WriteToBytes(m)
+	if err != nil {
+		t.Fatalf("WriteToBytes failed: %s", err)
+	}
+
+	got, err := ReadBytes(b)
+	if err != nil {
+		t.Fatalf("ReadBytes failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %v, got %v", m, got)
+	}
+
+	if b, err = WriteToBytes(nil); err != nil || len(b) != EmptyFileSize {
+		t.Errorf("expected an empty database of %d bytes, got %d (%v)", EmptyFileSize, len(b), err)
+	}
+}
+
+func TestBuildReader(t *testing.T) {
+	m := make(map[string][]string)
+	for _, rec := range records {
+		m[rec.key] = rec.values
+	}
+
+	c, err := BuildReader(m)
+	if err != nil {
+		t.Fatalf("BuildReader failed: %s", err)
+	}
+	for _, rec := range records {
+		if got, err := c.Get(rec.key); err != nil || !reflect.DeepEqual(got, rec.values) {
+			t.Errorf("Get(%q): expected %q, got %q (%v)", rec.key, rec.values, got, err)
+		}
+	}
+	if _, err = c.Get("missing"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+	if n, err := c.Len(); err != nil || n != 6 {
+		t.Errorf("expected 6 records, got %d (%v)", n, err)
+	}
+}
+
+func TestVerifyHashes(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = Make(tmp, bytes.NewBuffer(data)); err != nil {
+		t.Fatalf("Make failed: %s", err)
+	}
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = ReadWithOptions(bytes.NewReader(full), ReaderOptions{VerifyHashes: true}); err != nil {
+		t.Fatalf("ReadWithOptions failed on a good database: %s", err)
+	}
+
+	// Corrupt the first key.
+	key, _, _, err := ReadRecord(bytes.NewReader(full), Format32, uint64(HeaderSize))
+	if err != nil {
+		t.Fatalf("ReadRecord failed: %s", err)
+	}
+	bad := append([]byte(nil), full...)
+	bad[HeaderSize+8] ^= 0xff
+
+	if _, err = ReadWithOptions(bytes.NewReader(bad), ReaderOptions{}); err != nil {
+		t.Fatalf("expected the corruption to go unnoticed without VerifyHashes, got %v", err)
+	}
+	if _, err = ReadWithOptions(bytes.NewReader(bad), ReaderOptions{VerifyHashes: true}); !errors.Is(err, ErrCorruptHeader) {
+		t.Errorf("ReadWithOptions: expected ErrCorruptHeader, got %v", err)
+	}
+
+	c, err := NewWithOptions(bytes.NewReader(bad), ReaderOptions{VerifyHashes: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if _, err = c.GetFirst(key); !errors.Is(err, ErrCorruptHeader) {
+		t.Errorf("GetFirst: expected ErrCorruptHeader, got %v", err)
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	users := map[string][]string{"1": {"alice"}, "2": {"bob"}}
+	groups := map[string][]string{"1": {"admin", "staff"}}
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	if err = WriteNamespace(w, "users", users); err != nil {
+		t.Fatalf("WriteNamespace failed: %s", err)
+	}
+	if err = WriteNamespace(w, "groups", groups); err != nil {
+		t.Fatalf("WriteNamespace failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	for ns, want := range map[string]map[string][]string{"users": users, "groups": groups, "none": {}} {
+		m, err := ReadNamespace(tmp, ns)
+		if err != nil {
+			t.Fatalf("ReadNamespace failed: %s", err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("namespace %s: expected %v, got %v", ns, want, m)
+		}
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	files := make(map[string][]byte)
+	for name, format := range map[string]Format{"32": Format32, "64": Format64} {
+		tmp, err := os.Create(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		w, err := NewWriterWithOptions(tmp, WriterOptions{Format: format})
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		if err = w.Put([]byte("one"), []byte("1")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+		tmp.Close()
+		if files[name], err = ioutil.ReadFile(tmp.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Swap the header to big-endian.
+	be := append([]byte(nil), files["32"]...)
+	for i := 0; i < int(HeaderSize); i += 4 {
+		be[i], be[i+1], be[i+2], be[i+3] = be[i+3], be[i+2], be[i+1], be[i]
+	}
+	files["be"] = be
+	files["garbage"] = bytes.Repeat([]byte("garbage!"), 300)
+	files["wrapped"] = wrappedHeader(t)
+
+	for name, want := range map[string]FormatInfo{
+		"32":      {Format: Format32, Valid: true},
+		"64":      {Format: Format64, Valid: true},
+		"be":      {Format: Format32, BigEndian: true},
+		"garbage": {Format: Format32},
+		"wrapped": {Format: Format32},
+	} {
+		fi, err := DetectFormat(bytes.NewReader(files[name]))
+		if err != nil {
+			t.Fatalf("DetectFormat(%s) failed: %s", name, err)
+		}
+		fi.Problem = ""
+		if fi != want {
+			t.Errorf("DetectFormat(%s): expected %+v, got %+v", name, want, fi)
+		}
+	}
+
+	if _, err = DetectFormat(bytes.NewReader(files["32"][:100])); !errors.Is(err, ErrCorruptHeader) {
+		t.Errorf("expected ErrCorruptHeader for a short file, got %v", err)
+	}
+
+	for _, name := range []string{"garbage", "wrapped"} {
+		filename := filepath.Join(dir, name)
+		if err = ioutil.WriteFile(filename, files[name], 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = FromFile(filename); !errors.Is(err, ErrCorruptHeader) {
+			t.Errorf("FromFile: expected ErrCorruptHeader for %s, got %v", name, err)
+		}
+	}
+}
+
+func TestToFileTmpfile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	f, err := openTmpfile(dir)
+	if err != nil {
+		t.Skipf("no O_TMPFILE support: %s", err)
+	}
+	f.Close()
+
+	filename := filepath.Join(dir, "test.cdb")
+	m := map[string][]string{"one": {"1"}}
+	if err = ToFile(m, filename); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	// A failed write leaves nothing behind.
+	failed := errors.New("failed")
+	err = writeFile(filepath.Join(dir, "failed.cdb"), func(f *os.File) error {
+		return failed
+	})
+	if err != failed {
+		t.Fatalf("expected writeFile to fail, got %v", err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("expected only the database to remain, got %d files (%v)", len(entries), err)
+	}
+	if got, err := FromFile(filename); err != nil || !reflect.DeepEqual(got, m) {
+		t.Errorf("expected %v, got %v (%v)", m, got, err)
+	}
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v (%v)", fi.Mode(), err)
+	}
+}
+
+// TestWriteCollidingSlots writes many keys into one hash table, so that
+// several share a starting slot and must probe past more than one
+// occupied slot to find a free one.
+func TestWriteCollidingSlots(t *testing.T) {
+	m := make(map[string][]string)
+	home := make(map[uint32]int)
+	for i := 0; len(m) < 64; i++ {
+		key := fmt.Sprintf("key%d", i)
+		if h := checksum([]byte(key)); h%256 == 0 {
+			m[key] = []string{fmt.Sprint(i)}
+			home[h/256%128]++
+		}
+	}
+	crowded := 0
+	for _, n := range home {
+		if n >= 3 {
+			crowded++
+		}
+	}
+	if crowded == 0 {
+		t.Fatal("no three keys share a starting slot")
+	}
+
+	b, err := WriteToBytes(m)
+	if err != nil {
+		t.Fatalf("WriteToBytes failed: %s", err)
+	}
+	c, err := New(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if n, err := c.Len(); err != nil || n != len(m) {
+		t.Errorf("expected %d records, got %d (%v)", len(m), n, err)
+	}
+	for key, values := range m {
+		if v, err := c.GetFirst([]byte(key)); err != nil || string(v) != values[0] {
+			t.Errorf("GetFirst(%q): expected %q, got %q (%v)", key, values[0], v, err)
+		}
+	}
+}
+
+func TestHashCollisions(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	// Every key hashes alike, so b and c collide with a; a's second value
+	// does not.
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Hash: func([]byte) uint32 { return 7 }})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, key := range []string{"a", "b", "a", "c"} {
+		if err = w.Put([]byte(key), []byte("v")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	if s, err := Stats(tmp); err != nil || s.HashCollisions != 2 {
+		t.Errorf("expected 2 hash collisions, got %+v (%v)", s, err)
+	}
+
+	m := map[string][]string{"dup": {"1", "2"}}
+	for i := 0; i < 64; i++ {
+		m[fmt.Sprintf("key%d", i)] = []string{"v"}
+	}
+	b, err := WriteToBytes(m)
+	if err != nil {
+		t.Fatalf("WriteToBytes failed: %s", err)
+	}
+	if s, err := Stats(bytes.NewReader(b)); err != nil || s.HashCollisions != 0 {
+		t.Errorf("expected no hash collisions, got %+v (%v)", s, err)
+	}
+	if err = Verify(bytes.NewReader(b)); err != nil {
+		t.Fatalf("Verify failed on a good database: %s", err)
+	}
+
+	h, err := ReadHeader(bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("ReadHeader failed: %s", err)
+	}
+
+	// Empty the slot before one a lookup reaches by probing, so the probe
+	// stops short of it.
+	broken := false
+	for i := 0; i < 256 && !broken; i++ {
+		slots, err := ReadTable(bytes.NewReader(b), h, i)
+		if err != nil {
+			t.Fatalf("ReadTable failed: %s", err)
+		}
+		n := uint32(len(slots))
+		for j, s := range slots {
+			if s.Pos != 0 && (s.Hash/256)%n != uint32(j) {
+				bad := append([]byte(nil), b...)
+				prev := (uint32(j) + n - 1) % n
+				copy(bad[h.Tables[i].Pos+8*uint64(prev):], make([]byte, 8))
+				if err = Verify(bytes.NewReader(bad)); !errors.Is(err, ErrCorruptHeader) || !strings.Contains(err.Error(), "empty slot") {
+					t.Errorf("expected an unreachable record, got %v", err)
+				}
+				broken = true
+				break
+			}
+		}
+	}
+	if !broken {
+		t.Fatal("no record is displaced from its first slot")
+	}
+
+	// Swap the slots of dup's values, so a lookup finds them out of order.
+	dh := checksum([]byte("dup"))
+	slots, err := ReadTable(bytes.NewReader(b), h, int(dh%256))
+	if err != nil {
+		t.Fatalf("ReadTable failed: %s", err)
+	}
+	var dup []int
+	for j, s := range slots {
+		if s.Hash == dh {
+			dup = append(dup, j)
+		}
+	}
+	if len(dup) != 2 {
+		t.Fatalf("expected 2 slots for dup, got %d", len(dup))
+	}
+	bad := append([]byte(nil), b...)
+	p0, p1 := h.Tables[dh%256].Pos+8*uint64(dup[0])+4, h.Tables[dh%256].Pos+8*uint64(dup[1])+4
+	for k := uint64(0); k < 4; k++ {
+		bad[p0+k], bad[p1+k] = bad[p1+k], bad[p0+k]
+	}
+	if err = Verify(bytes.NewReader(bad)); !errors.Is(err, ErrCorruptHeader) || !strings.Contains(err.Error(), "out of order") {
+		t.Errorf("expected values probed out of order, got %v", err)
+	}
+}
+
+// fakeDB is a database/sql connector recording the statements run on it
+// and answering every query with cols and rows.
+type fakeDB struct {
+	stmts     []string         // statements prepared, in order
+	inserts   [][]driver.Value // arguments of each INSERT
+	committed bool
+	cols      []string
+	rows      [][]driver.Value
+}
+
+func (db *fakeDB) Connect(context.Context) (driver.Conn, error) { return fakeConn{db}, nil }
+func (db *fakeDB) Driver() driver.Driver                        { return fakeDriver{} }
+
+type fakeDriver struct{}
+
+func (fakeDriver) Open(string) (driver.Conn, error) { return nil, errors.New("use sql.OpenDB") }
+
+type fakeConn struct{ db *fakeDB }
+
+func (c fakeConn) Prepare(query string) (driver.Stmt, error) {
+	c.db.stmts = append(c.db.stmts, query)
+	return fakeStmt{c.db, query}, nil
+}
+func (c fakeConn) Close() error              { return nil }
+func (c fakeConn) Begin() (driver.Tx, error) { return fakeTx{c.db}, nil }
+
+type fakeTx struct{ db *fakeDB }
+
+func (tx fakeTx) Commit() error   { tx.db.committed = true; return nil }
+func (tx fakeTx) Rollback() error { return nil }
+
+type fakeStmt struct {
+	db    *fakeDB
+	query string
+}
+
+func (s fakeStmt) Close() error  { return nil }
+func (s fakeStmt) NumInput() int { return -1 }
+
+func (s fakeStmt) Exec(args []driver.Value) (driver.Result, error) {
+	if strings.HasPrefix(s.query, "INSERT") {
+		row := make([]driver.Value, len(args))
+		for i, a := range args {
+			if b, ok := a.([]byte); ok {
+				a = append([]byte(nil), b...)
+			}
+			row[i] = a
+		}
+		s.db.inserts = append(s.db.inserts, row)
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s fakeStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &fakeRows{cols: s.db.cols, rows: s.db.rows}, nil
+}
+
+type fakeRows struct {
+	cols []string
+	rows [][]driver.Value
+}
+
+func (r *fakeRows) Columns() []string { return r.cols }
+func (r *fakeRows) Close() error      { return nil }
+
+func (r *fakeRows) Next(dest []driver.Value) error {
+	if len(r.rows) == 0 {
+		return io.EOF
+	}
+	copy(dest, r.rows[0])
+	r.rows = r.rows[1:]
+	return nil
+}
+
+func TestExportSQL(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for _, r := range records {
+		for _, v := range r.values {
+			if err = w.Put([]byte(r.key), []byte(v)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	fdb := new(fakeDB)
+	db := sql.OpenDB(fdb)
+	defer db.Close()
+
+	if err = ExportSQL(db, "records", tmp); err != nil {
+		t.Fatalf("ExportSQL failed: %s", err)
+	}
+	want := []string{
+		"CREATE TABLE IF NOT EXISTS records (k BLOB, v BLOB)",
+		"INSERT INTO records (k, v) VALUES (?, ?)",
+	}
+	if !reflect.DeepEqual(fdb.stmts, want) {
+		t.Fatalf("ExportSQL ran %q, want %q", fdb.stmts, want)
+	}
+	if !fdb.committed {
+		t.Fatalf("ExportSQL did not commit")
+	}
+
+	i := 0
+	for _, r := range records {
+		for _, v := range r.values {
+			row := []driver.Value{[]byte(r.key), []byte(v)}
+			if i >= len(fdb.inserts) || !reflect.DeepEqual(fdb.inserts[i], row) {
+				t.Fatalf("insert %d should be %q", i, row)
+			}
+			i++
+		}
+	}
+	if i != len(fdb.inserts) {
+		t.Fatalf("ExportSQL inserted %d rows, want %d", len(fdb.inserts), i)
+	}
+
+	for _, name := range []string{"", "1records", `"records"`, "records; DROP TABLE x", "kéy"} {
+		fdb.stmts = nil
+		if err = ExportSQL(db, name, tmp); err == nil {
+			t.Fatalf("ExportSQL should reject table name %q", name)
+		}
+		if len(fdb.stmts) != 0 {
+			t.Fatalf("ExportSQL ran %q for table name %q", fdb.stmts, name)
+		}
+	}
+}
+
+func TestWriteFromRows(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	fdb := &fakeDB{
+		cols: []string{"id", "sku", "name"},
+		rows: [][]driver.Value{
+			{int64(1), []byte("a1"), "Apple"},
+			{int64(2), []byte("b2"), nil},
+			{int64(3), nil, "Cherry"},
+			{int64(4), []byte("a1"), "Apricot"},
+		},
+	}
+	db := sql.OpenDB(fdb)
+	defer db.Close()
+
+	rows, err := db.Query("SELECT id, sku, name FROM products")
+	if err != nil {
+		t.Fatalf("Query failed: %s", err)
+	}
+	err = WriteFromRows(tmp, rows, 1, 2)
+	rows.Close()
+	if err != nil {
+		t.Fatalf("WriteFromRows failed: %s", err)
+	}
+
+	// NULL is written as an empty key or value.
+	want := map[string][]string{
+		"a1": {"Apple", "Apricot"},
+		"b2": {""},
+		"":   {"Cherry"},
+	}
+	m, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if !reflect.DeepEqual(m, want) {
+		t.Fatalf("WriteFromRows wrote %q, want %q", m, want)
+	}
+
+	for _, cols := range [][2]int{{0, 3}, {-1, 1}, {3, 0}} {
+		rows, err := db.Query("SELECT id, sku, name FROM products")
+		if err != nil {
+			t.Fatalf("Query failed: %s", err)
+		}
+		err = WriteFromRows(tmp, rows, cols[0], cols[1])
+		rows.Close()
+		if err == nil {
+			t.Fatalf("WriteFromRows should reject columns %d and %d of 3", cols[0], cols[1])
+		}
+	}
+
+	// ImportSQL wants exactly a key and a value column.
+	if err = ImportSQL(tmp, db, "SELECT id, sku, name FROM products"); err == nil {
+		t.Fatalf("ImportSQL should reject a query returning 3 columns")
+	}
+	fdb.cols = []string{"sku", "name"}
+	fdb.rows = [][]driver.Value{{"a1", "Apple"}, {"b2", int64(42)}}
+	if _, err = tmp.Seek(0, 0); err != nil {
+		t.Fatalf("Seek failed: %s", err)
+	}
+	if err = ImportSQL(tmp, db, "SELECT sku, name FROM products"); err != nil {
+		t.Fatalf("ImportSQL failed: %s", err)
+	}
+	if m, err = Read(tmp); err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if want = map[string][]string{"a1": {"Apple"}, "b2": {"42"}}; !reflect.DeepEqual(m, want) {
+		t.Fatalf("ImportSQL wrote %q, want %q", m, want)
+	}
+}
+
+func TestWrappedHeader(t *testing.T) {
+	b := wrappedHeader(t)
+
+	if _, err := New(bytes.NewReader(b)); err == nil {
+		if _, err = Read(bytes.NewReader(b)); err == nil {
+			t.Error("Read accepted a table that wraps")
+		}
+	}
+	if err := Verify(bytes.NewReader(b)); err == nil {
+		t.Error("Verify accepted a table that wraps")
+	}
+	if fi, err := DetectFormat(bytes.NewReader(b)); err != nil || fi.Valid {
+		t.Errorf("DetectFormat reported %v (%v) for a table that wraps", fi, err)
+	}
+	if _, err := Stats(bytes.NewReader(b)); err == nil {
+		t.Error("Stats accepted a table that wraps")
+	}
+
+	// Recover only reports errors writing; it must simply not trust the
+	// header.
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if _, err = Recover(bytes.NewReader(b), tmp); err != nil {
+		t.Errorf("Recover failed: %s", err)
+	}
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"time"
+)
+
+var (
+	records = flag.Int("n", 1000000, "number of records to generate")
+	keyLen  = flag.Int("keylen", 16, "length of each key in bytes, at least 8")
+	valLen  = flag.Int("vallen", 100, "length of each value in bytes")
+	dist    = flag.String("dist", "uniform", "distribution of looked up keys: uniform or zipf")
+	lookups = flag.Int("lookups", 1000000, "number of random lookups to time")
+	misses  = flag.Float64("misses", 0, "fraction of lookups for keys that are not in the database")
+	format  = flag.Int("format", 32, "database format: 32 or 64")
+	seed    = flag.Int64("seed", 1, "random seed, so runs can be repeated")
+	file    = flag.String("o", "", "write the database to `file` and keep it, instead of a temporary file")
+)
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "cdbbench: fatal: %s\n", err)
+	os.Exit(111)
+}
+
+func usage(msg string) {
+	fmt.Fprintf(os.Stderr, "cdbbench: usage: %s\n", msg)
+	os.Exit(100)
+}
+
+// key returns the key of record i, padded to keyLen bytes.  Keys that are
+// not in the database are made from i >= records.
+func key(buf []byte, i int) []byte {
+	buf = buf[:0]
+	buf = fmt.Appendf(buf, "%0*d", *keyLen, i)
+	return buf[len(buf)-*keyLen:]
+}
+
+// rate formats n units processed in d as a rate per second.
+func rate(n float64, unit string, d time.Duration) string {
+	return fmt.Sprintf("%.1f %s/s", n/d.Seconds(), unit)
+}
+
+func main() {
+	flag.Parse()
+	if *keyLen < 8 || *valLen < 0 || *records < 0 || *lookups < 0 || *misses < 0 || *misses > 1 {
+		usage("cdbbench [-n records] [-keylen n] [-vallen n] [-dist uniform|zipf] [-lookups n] [-misses f] [-format 32|64] [-seed n] [-o file]")
+	}
+	opts := cdbmap.WriterOptions{Format: cdbmap.Format32}
+	switch *format {
+	case 32:
+	case 64:
+		opts.Format = cdbmap.Format64
+	default:
+		usage("-format must be 32 or 64")
+	}
+	if *dist != "uniform" && *dist != "zipf" {
+		usage("-dist must be uniform or zipf")
+	}
+
+	rng := rand.New(rand.NewSource(*seed))
+	var f *os.File
+	var err error
+	if *file != "" {
+		f, err = os.Create(*file)
+	} else {
+		f, err = ioutil.TempFile("", "cdbbench")
+		if err == nil {
+			defer os.Remove(f.Name())
+		}
+	}
+	if err != nil {
+		fatal(err)
+	}
+	defer f.Close()
+
+	// Build.
+	value := make([]byte, *valLen)
+	rng.Read(value)
+	kbuf := make([]byte, 0, 32)
+	start := time.Now()
+	w, err := cdbmap.NewWriterWithOptions(f, opts)
+	if err != nil {
+		fatal(err)
+	}
+	for i := 0; i < *records; i++ {
+		if err = w.Put(key(kbuf, i), value); err != nil {
+			fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		fatal(err)
+	}
+	if err = f.Sync(); err != nil {
+		fatal(err)
+	}
+	build := time.Since(start)
+	fi, err := f.Stat()
+	if err != nil {
+		fatal(err)
+	}
+	size := float64(fi.Size())
+
+	bout := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(bout, "records %d\n", *records)
+	fmt.Fprintf(bout, "size %d\n", fi.Size())
+	fmt.Fprintf(bout, "build %v %s %s\n", build, rate(float64(*records), "records", build), rate(size/(1<<20), "MB", build))
+
+	// Sequential scan.
+	c, err := cdbmap.New(f)
+	if err != nil {
+		fatal(err)
+	}
+	start = time.Now()
+	var scanned int
+	err = c.Iterate(func(key, value []byte) error {
+		scanned++
+		return nil
+	})
+	if err != nil {
+		fatal(err)
+	}
+	scan := time.Since(start)
+	fmt.Fprintf(bout, "scan %v %s %s\n", scan, rate(float64(scanned), "records", scan), rate(size/(1<<20), "MB", scan))
+
+	// Random lookups.
+	var next func() int
+	if *dist == "zipf" && *records > 1 {
+		z := rand.NewZipf(rng, 1.1, 1, uint64(*records-1))
+		next = func() int { return int(z.Uint64()) }
+	} else {
+		next = func() int { return rng.Intn(max(*records, 1)) }
+	}
+	latencies := make([]time.Duration, *lookups)
+	start = time.Now()
+	for i := range latencies {
+		n := next()
+		if rng.Float64() < *misses {
+			n += *records
+		}
+		k := key(kbuf, n)
+		t := time.Now()
+		_, err := c.GetFirst(k)
+		latencies[i] = time.Since(t)
+		if err != nil && err != cdbmap.ErrNotFound {
+			fatal(err)
+		}
+	}
+	get := time.Since(start)
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		pct := func(p float64) time.Duration {
+			return latencies[int(p*float64(len(latencies)-1))]
+		}
+		fmt.Fprintf(bout, "get %v %s\n", get, rate(float64(len(latencies)), "lookups", get))
+		fmt.Fprintf(bout, "latency p50 %v p90 %v p99 %v p999 %v max %v\n",
+			pct(0.5), pct(0.9), pct(0.99), pct(0.999), latencies[len(latencies)-1])
+	}
+
+	if err = bout.Flush(); err != nil {
+		fatal(err)
+	}
+}
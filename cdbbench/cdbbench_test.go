@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs cdbbench itself instead of the tests when the test binary
+// is re-executed by runCdbbench.
+func TestMain(m *testing.M) {
+	if os.Getenv("CDBBENCH_TEST_MAIN") != "" {
+		os.Args = append(os.Args[:1], strings.Fields(os.Getenv("CDBBENCH_TEST_MAIN"))...)
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCdbbench runs cdbbench with args and returns its output and exit
+// status.
+func runCdbbench(t *testing.T, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "CDBBENCH_TEST_MAIN="+strings.Join(args, " "))
+	out := bytes.NewBuffer(nil)
+	cmd.Stdout = out
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return out.String(), e.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	return out.String(), 0
+}
+
+func TestKey(t *testing.T) {
+	buf := make([]byte, 0, 32)
+	for _, test := range []struct {
+		keyLen, i int
+		expected  string
+	}{
+		{8, 7, "00000007"},
+		{10, 123456, "0000123456"},
+		{8, 1234567890, "34567890"},
+	} {
+		*keyLen = test.keyLen
+		if got := string(key(buf, test.i)); got != test.expected {
+			t.Errorf("key %d of length %d: expected %q, got %q", test.i, test.keyLen, test.expected, got)
+		}
+	}
+	*keyLen = 16
+}
+
+func TestCdbbench(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, test := range []struct {
+		args    []string
+		records int
+	}{
+		{[]string{"-n", "1000", "-lookups", "500"}, 1000},
+		{[]string{"-n", "1000", "-lookups", "500", "-dist", "zipf", "-misses", "0.5", "-format", "64"}, 1000},
+		{[]string{"-n", "0", "-lookups", "0"}, 0},
+	} {
+		name := filepath.Join(dir, "bench.cdb")
+		cmdline := strings.Join(test.args, " ")
+		out, status := runCdbbench(t, append(test.args, "-o", name)...)
+		if status != 0 {
+			t.Fatalf("cdbbench %s: exit status %d", cmdline, status)
+		}
+		for _, prefix := range []string{"records ", "size ", "build ", "scan "} {
+			if !strings.HasPrefix(out, prefix) && !strings.Contains(out, "\n"+prefix) {
+				t.Errorf("cdbbench %s: no %q line in %q", cmdline, prefix, out)
+			}
+		}
+		if lookups := test.records > 0; strings.Contains(out, "\nlatency ") != lookups {
+			t.Errorf("cdbbench %s: expected latency line %t in %q", cmdline, lookups, out)
+		}
+
+		// -o keeps the database.
+		c, err := cdbmap.Open(name)
+		if err != nil {
+			t.Fatalf("Open failed: %s", err)
+		}
+		n, err := c.Len()
+		c.Close()
+		if err != nil || n != test.records {
+			t.Errorf("cdbbench %s: expected %d records, got %d (%v)", cmdline, test.records, n, err)
+		}
+	}
+
+	for _, args := range [][]string{
+		{"-keylen", "4"},
+		{"-misses", "2"},
+		{"-format", "16"},
+		{"-dist", "normal"},
+	} {
+		if _, status := runCdbbench(t, args...); status != 100 {
+			t.Errorf("cdbbench %s: expected exit status 100, got %d", strings.Join(args, " "), status)
+		}
+	}
+}
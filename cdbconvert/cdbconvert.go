@@ -0,0 +1,55 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"log"
+	"os"
+)
+
+var (
+	driver = flag.String("driver", "sqlite3", "database/sql driver name")
+	dsn    = flag.String("dsn", "", "data source name, such as the SQLite file")
+)
+
+func exitOnErr(err error) {
+	if err != nil {
+		log.Fatal(err)
+	}
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, "usage: cdbconvert [-driver d] -dsn dsn export table < f.cdb\n")
+	fmt.Fprint(os.Stderr, "       cdbconvert [-driver d] -dsn dsn import query f.cdb\n")
+	os.Exit(2)
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+	if len(args) != 2 && !(len(args) == 3 && args[0] == "import") {
+		usage()
+	}
+
+	db, err := sql.Open(*driver, *dsn)
+	exitOnErr(err)
+	defer db.Close()
+
+	switch args[0] {
+	case "export":
+		exitOnErr(cdbmap.ExportSQL(db, args[1], os.Stdin))
+	case "import":
+		if len(args) != 3 {
+			usage()
+		}
+		f, err := os.OpenFile(args[2], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+		exitOnErr(err)
+		exitOnErr(cdbmap.ImportSQL(f, db, args[1]))
+		exitOnErr(f.Close())
+	default:
+		usage()
+	}
+}
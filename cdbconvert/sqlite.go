@@ -0,0 +1,7 @@
+//go:build sqlite
+
+// Build with -tags sqlite to link in the SQLite driver.
+
+package main
+
+import _ "github.com/mattn/go-sqlite3"
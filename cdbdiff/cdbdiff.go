@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"os"
+	"sort"
+)
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "cdbdiff: fatal: %s\n", err)
+	os.Exit(111)
+}
+
+// writeRecords writes each value of key as a cdbdump-format record
+// prefixed by mark.
+func writeRecords(w *bufio.Writer, mark byte, key string, values [][]byte) {
+	for _, v := range values {
+		fmt.Fprintf(w, "%c+%d,%d:%s->%s\n", mark, len(key), len(v), key, v)
+	}
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprint(os.Stderr, "cdbdiff: usage: cdbdiff old.cdb new.cdb\n")
+		os.Exit(111)
+	}
+
+	fa, err := os.Open(os.Args[1])
+	if err != nil {
+		fatal(err)
+	}
+	fb, err := os.Open(os.Args[2])
+	if err != nil {
+		fatal(err)
+	}
+
+	changes, err := cdbmap.Diff(fa, fb)
+	if err != nil {
+		fatal(err)
+	}
+	if changes.Empty() {
+		return
+	}
+
+	a, err := cdbmap.New(fa)
+	if err != nil {
+		fatal(err)
+	}
+	b, err := cdbmap.New(fb)
+	if err != nil {
+		fatal(err)
+	}
+
+	// Print keys in order, whatever kind of change they have.
+	keys := append(append(append([]string(nil), changes.Removed...), changes.Added...), changes.Changed...)
+	sort.Strings(keys)
+
+	bout := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(bout, "--- %s\n+++ %s\n", os.Args[1], os.Args[2])
+	for _, key := range keys {
+		va, err := a.GetAll([]byte(key))
+		if err != nil && err != cdbmap.ErrNotFound {
+			fatal(err)
+		}
+		vb, err := b.GetAll([]byte(key))
+		if err != nil && err != cdbmap.ErrNotFound {
+			fatal(err)
+		}
+		writeRecords(bout, '-', key, va)
+		writeRecords(bout, '+', key, vb)
+	}
+	if err = bout.Flush(); err != nil {
+		fatal(err)
+	}
+
+	os.Exit(1)
+}
@@ -2,15 +2,79 @@ package main
 
 import (
 	"bufio"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/clee/go-cdbmap"
+	"io"
 	"os"
+	"regexp"
 )
 
+var (
+	jsonOut = flag.Bool("json", false, "dump as a JSON object of key to values")
+	ndjson  = flag.Bool("ndjson", false, "dump as newline-delimited JSON objects of k and v, one per record")
+	csvOut  = flag.Bool("csv", false, "dump as CSV rows of key and value")
+	tsvOut  = flag.Bool("tsv", false, "dump as tab-separated rows of key and value")
+	header  = flag.Bool("header", false, "with -csv or -tsv, write a header row")
+
+	prefix   = flag.String("prefix", "", "dump only keys beginning with `prefix`")
+	match    = flag.String("match", "", "dump only keys matching the regular expression `re`")
+	sorted   = flag.Bool("sort", false, "dump records in key order")
+	offset   = flag.Int("offset", 0, "skip the first `n` selected records")
+	limit    = flag.Int("limit", 0, "dump at most `n` records")
+	keysOnly = flag.Bool("keys", false, "dump each selected key once per line instead of records")
+)
+
+// protoDump is set when built with -tags proto.  It returns the dump
+// selected by the -proto flag, or nil if the flag was not given.
+var protoDump func() func(w io.Writer, r io.ReaderAt) error
+
+// fatal reports err and exits as djb's cdbdump does: 100 if the database
+// is corrupt, 111 for any other, temporary, error.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbdump: fatal: %s: %s\n", what, err)
+	if errors.Is(err, cdbmap.ErrCorruptHeader) || errors.Is(err, cdbmap.ErrCorruptRecord) {
+		os.Exit(100)
+	}
+	os.Exit(111)
+}
+
 func main() {
-	bin, bout := bufio.NewReader(os.Stdin), bufio.NewWriter(os.Stdout)
-	err := cdbmap.Dump(bout, bin)
-	bout.Flush()
+	flag.Parse()
+
+	var dumpProto func(w io.Writer, r io.ReaderAt) error
+	if protoDump != nil {
+		dumpProto = protoDump()
+	}
+
+	var err error
+	bout := bufio.NewWriter(os.Stdout)
+	switch {
+	case dumpProto != nil:
+		err = dumpProto(bout, os.Stdin)
+	case *jsonOut:
+		err = cdbmap.DumpJSON(bout, os.Stdin)
+	case *ndjson:
+		err = cdbmap.StreamJSON(bout, os.Stdin)
+	case *csvOut:
+		err = cdbmap.DumpCSV(bout, os.Stdin, cdbmap.CSVOptions{Header: *header})
+	case *tsvOut:
+		err = cdbmap.DumpCSV(bout, os.Stdin, cdbmap.CSVOptions{Comma: '\t', Header: *header})
+	default:
+		opts := cdbmap.DumpOptions{Prefix: *prefix, Sort: *sorted, Offset: *offset, Limit: *limit, KeysOnly: *keysOnly}
+		if *match != "" {
+			if opts.Match, err = regexp.Compile(*match); err != nil {
+				fatal("bad -match", err)
+			}
+		}
+		err = cdbmap.DumpWithOptions(bout, bufio.NewReader(os.Stdin), opts)
+	}
 	if err != nil {
-		os.Exit(111)
+		bout.Flush()
+		fatal("unable to read input", err)
+	}
+	if err = bout.Flush(); err != nil {
+		fatal("unable to write output", err)
 	}
 }
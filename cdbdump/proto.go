@@ -0,0 +1,95 @@
+//go:build proto
+
+// Build with -tags proto to add the -proto and -message flags, which need
+// the Go protobuf module.
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+	"io"
+	"os"
+)
+
+var (
+	protoFile    = flag.String("proto", "", "decode values as protobuf messages described by this descriptor set file (protoc --include_imports --descriptor_set_out)")
+	protoMessage = flag.String("message", "", "with -proto, the full name of the message type of the values")
+)
+
+func init() {
+	protoDump = func() func(w io.Writer, r io.ReaderAt) error {
+		if *protoFile == "" {
+			return nil
+		}
+		return dumpProtoJSON
+	}
+}
+
+// dumpProtoJSON writes each record as an indented JSON object holding the
+// key and the value decoded as a *protoMessage, in the order the records
+// were written.
+func dumpProtoJSON(w io.Writer, r io.ReaderAt) error {
+	mt, err := loadMessageType(*protoFile, *protoMessage)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return err
+	}
+
+	opts := protojson.MarshalOptions{Multiline: true, Indent: "  "}
+	return cdbmap.Iterate(r, func(key, value []byte) error {
+		m := mt.New().Interface()
+		if err := proto.Unmarshal(value, m); err != nil {
+			err = fmt.Errorf("value of %q: %w", key, err)
+			fmt.Fprintln(os.Stderr, err)
+			return err
+		}
+		js, err := opts.Marshal(m)
+		if err != nil {
+			return err
+		}
+		k, err := json.Marshal(string(key))
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(w, "{\n  \"key\": %s,\n  \"value\": %s\n}\n", k, js)
+		return err
+	})
+}
+
+// loadMessageType finds the message type called name in the descriptor set
+// in filename.
+func loadMessageType(filename, name string) (protoreflect.MessageType, error) {
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var set descriptorpb.FileDescriptorSet
+	if err = proto.Unmarshal(b, &set); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	d, err := files.FindDescriptorByName(protoreflect.FullName(name))
+	if err != nil {
+		return nil, fmt.Errorf("%s: message %q: %w", filename, name, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s: %q is not a message", filename, name)
+	}
+
+	return dynamicpb.NewMessageType(md), nil
+}
@@ -0,0 +1,87 @@
+//go:build proto
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/clee/go-cdbmap"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+func TestDumpProtoJSON(t *testing.T) {
+	// descriptor.proto describes itself, so it serves as both the
+	// descriptor set and the message type of the values.
+	set, err := proto.Marshal(&descriptorpb.FileDescriptorSet{
+		File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(descriptorpb.File_google_protobuf_descriptor_proto)},
+	})
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	descs, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(descs.Name())
+
+	descs.Write(set)
+	descs.Close()
+
+	value, err := proto.Marshal(&descriptorpb.FileDescriptorProto{Name: proto.String("a.proto"), Package: proto.String("pkg")})
+	if err != nil {
+		t.Fatalf("Marshal failed: %s", err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err = cdbmap.WriteStream(map[string][]string{"a": {string(value)}}, buf); err != nil {
+		t.Fatalf("WriteStream failed: %s", err)
+	}
+	db := bytes.NewReader(buf.Bytes())
+
+	if protoDump() != nil {
+		t.Fatalf("protoDump should return nil without -proto")
+	}
+	*protoFile, *protoMessage = descs.Name(), "google.protobuf.FileDescriptorProto"
+	defer func() { *protoFile, *protoMessage = "", "" }()
+	dump := protoDump()
+	if dump == nil {
+		t.Fatalf("protoDump should return a dump with -proto")
+	}
+
+	out := bytes.NewBuffer(nil)
+	if err = dump(out, db); err != nil {
+		t.Fatalf("dump failed: %s", err)
+	}
+	var got struct {
+		Key   string
+		Value map[string]string
+	}
+	if err = json.Unmarshal(out.Bytes(), &got); err != nil {
+		t.Fatalf("Unmarshal of %q failed: %s", out, err)
+	}
+	if got.Key != "a" || got.Value["name"] != "a.proto" || got.Value["package"] != "pkg" {
+		t.Fatalf("unexpected dump %q", out)
+	}
+
+	for _, name := range []string{"google.protobuf.Missing", "google.protobuf.FieldDescriptorProto.Type"} {
+		*protoMessage = name
+		if err = dump(ioutil.Discard, db); err == nil {
+			t.Errorf("dump with -message %s should fail", name)
+		}
+	}
+
+	// Values that are not the message type are reported.
+	*protoMessage = "google.protobuf.FileDescriptorProto"
+	buf.Reset()
+	if err = cdbmap.WriteStream(map[string][]string{"a": {"\xff"}}, buf); err != nil {
+		t.Fatalf("WriteStream failed: %s", err)
+	}
+	if err = dump(ioutil.Discard, bytes.NewReader(buf.Bytes())); err == nil {
+		t.Errorf("dump of a value that is not a message should fail")
+	}
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"os"
+	"strconv"
+)
+
+// fatal reports err and exits as djb's cdbget does: 100 if the database
+// is corrupt, 111 for any other, temporary, error.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbget: fatal: %s: %s\n", what, err)
+	if errors.Is(err, cdbmap.ErrCorruptHeader) || errors.Is(err, cdbmap.ErrCorruptRecord) {
+		os.Exit(100)
+	}
+	os.Exit(111)
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, "cdbget: usage: cdbget key [skip]\n")
+	os.Exit(111)
+}
+
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		usage()
+	}
+
+	key := []byte(os.Args[1])
+	skip := 0
+	if len(os.Args) == 3 {
+		n, err := strconv.Atoi(os.Args[2])
+		if err != nil || n < 0 {
+			usage()
+		}
+		skip = n
+	}
+
+	c, err := cdbmap.New(os.Stdin)
+	if err != nil {
+		fatal("unable to read input", err)
+	}
+
+	value, err := c.GetAt(key, skip)
+	if err == cdbmap.ErrNotFound {
+		os.Exit(100)
+	}
+	if err != nil {
+		fatal("unable to read input", err)
+	}
+
+	bout := bufio.NewWriter(os.Stdout)
+	bout.Write(value)
+	if err = bout.Flush(); err != nil {
+		fatal("unable to write output", err)
+	}
+}
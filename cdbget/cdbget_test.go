@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs cdbget itself instead of the tests when the test binary is
+// re-executed by runCdbget.
+func TestMain(m *testing.M) {
+	if os.Getenv("CDBGET_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCdbget runs cdbget with args and the named file as its input, and
+// returns its output and exit status.
+func runCdbget(t *testing.T, input string, args ...string) (string, int) {
+	in, err := os.Open(input)
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	defer in.Close()
+
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CDBGET_TEST_MAIN=1")
+	cmd.Stdin = in
+	out := bytes.NewBuffer(nil)
+	cmd.Stdout = out
+	err = cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return out.String(), e.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	return out.String(), 0
+}
+
+func TestCdbget(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = cdbmap.Write(map[string][]string{"one": {"1", "11"}, "two": {"2"}}, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	tmp.Close()
+
+	tests := []struct {
+		args     []string
+		expected string
+		status   int
+	}{
+		{[]string{"one"}, "1", 0},
+		{[]string{"one", "1"}, "11", 0},
+		{[]string{"two", "0"}, "2", 0},
+		{[]string{"one", "2"}, "", 100},
+		{[]string{"three"}, "", 100},
+		{[]string{}, "", 111},
+		{[]string{"one", "-1"}, "", 111},
+		{[]string{"one", "x"}, "", 111},
+		{[]string{"one", "1", "2"}, "", 111},
+	}
+	for _, test := range tests {
+		out, status := runCdbget(t, tmp.Name(), test.args...)
+		if out != test.expected || status != test.status {
+			t.Errorf("cdbget %s: expected %q and status %d, got %q and %d",
+				strings.Join(test.args, " "), test.expected, test.status, out, status)
+		}
+	}
+
+	// A truncated database is corrupt.
+	short, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(short.Name())
+
+	short.Write([]byte("truncated"))
+	short.Close()
+	if _, status := runCdbget(t, short.Name(), "one"); status != 100 {
+		t.Errorf("cdbget of a truncated database: expected status 100, got %d", status)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io"
+	"os"
+	"regexp"
+)
+
+var (
+	fixed      = flag.Bool("F", false, "match pattern as a fixed string rather than a regular expression")
+	ignoreCase = flag.Bool("i", false, "match case-insensitively")
+	invert     = flag.Bool("v", false, "select records that do not match")
+	keysOnly   = flag.Bool("keys", false, "match keys only")
+	valuesOnly = flag.Bool("values", false, "match values only")
+	list       = flag.Bool("l", false, "print each selected key once, escaped as for tsv, instead of records")
+	count      = flag.Bool("c", false, "print only the number of selected records")
+	format     = flag.String("format", "tsv", "print records as `fmt`: tsv (key, tab, value, with tab, newline, carriage return and backslash escaped as \\t, \\n, \\r and \\\\), dump (cdbdump records, for cdbmake) or json (newline-delimited objects of k and v)")
+)
+
+// fatal reports err and exits as cdbdump does: 100 if the database is
+// corrupt, 111 for any other, temporary, error.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbgrep: fatal: %s: %s\n", what, err)
+	if errors.Is(err, cdbmap.ErrCorruptHeader) || errors.Is(err, cdbmap.ErrCorruptRecord) {
+		os.Exit(100)
+	}
+	os.Exit(111)
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, "cdbgrep: usage: cdbgrep [flags] pattern [file.cdb]\n")
+	flag.PrintDefaults()
+	os.Exit(111)
+}
+
+// matcher returns a function reporting whether b matches pattern.
+func matcher(pattern string) (func(b []byte) bool, error) {
+	if *fixed && !*ignoreCase {
+		p := []byte(pattern)
+		return func(b []byte) bool { return bytes.Contains(b, p) }, nil
+	}
+
+	if *fixed {
+		pattern = regexp.QuoteMeta(pattern)
+	}
+	if *ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return re.Match, nil
+}
+
+// printer returns a function writing a selected record to w in the format
+// chosen by -format, -l or -c.
+func printer(w *bufio.Writer) (func(key, value []byte) error, error) {
+	switch {
+	case *count:
+		return func(key, value []byte) error { return nil }, nil
+	case *list:
+		seen := make(map[string]bool)
+		return func(key, value []byte) error {
+			if seen[string(key)] {
+				return nil
+			}
+			seen[string(key)] = true
+			writeEscaped(w, key)
+			return w.WriteByte('\n')
+		}, nil
+	}
+
+	switch *format {
+	case "tsv":
+		return func(key, value []byte) error {
+			writeEscaped(w, key)
+			w.WriteByte('\t')
+			writeEscaped(w, value)
+			return w.WriteByte('\n')
+		}, nil
+	case "dump":
+		return func(key, value []byte) error {
+			_, err := fmt.Fprintf(w, "+%d,%d:%s->%s\n", len(key), len(value), key, value)
+			return err
+		}, nil
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetEscapeHTML(false)
+		return func(key, value []byte) error {
+			return enc.Encode(struct {
+				K string `json:"k"`
+				V string `json:"v"`
+			}{string(key), string(value)})
+		}, nil
+	}
+	return nil, fmt.Errorf("unknown format %q", *format)
+}
+
+// writeEscaped writes b to w with the bytes that would break a tsv line
+// escaped.
+func writeEscaped(w *bufio.Writer, b []byte) {
+	for _, c := range b {
+		switch c {
+		case '\t':
+			w.WriteString(`\t`)
+		case '\n':
+			w.WriteString(`\n`)
+		case '\r':
+			w.WriteString(`\r`)
+		case '\\':
+			w.WriteString(`\\`)
+		default:
+			w.WriteByte(c)
+		}
+	}
+}
+
+// search passes each record of the database in r selected by match, as
+// the flags say, to emit, then writes any trailer the output needs to w.
+// It returns the number of records selected.
+func search(r io.ReaderAt, w *bufio.Writer, match func(b []byte) bool, emit func(key, value []byte) error) (int, error) {
+	n := 0
+	err := cdbmap.Iterate(r, func(key, value []byte) error {
+		matched := !*valuesOnly && match(key) || !*keysOnly && match(value)
+		if matched == *invert {
+			return nil
+		}
+		n++
+		return emit(key, value)
+	})
+	if err != nil {
+		return n, err
+	}
+	if *count {
+		fmt.Fprintln(w, n)
+	} else if *format == "dump" && !*list {
+		w.WriteByte('\n')
+	}
+	return n, nil
+}
+
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 || flag.NArg() > 2 || *keysOnly && *valuesOnly {
+		usage()
+	}
+
+	match, err := matcher(flag.Arg(0))
+	if err != nil {
+		fatal("bad pattern", err)
+	}
+
+	in := os.Stdin
+	if flag.NArg() == 2 {
+		if in, err = os.Open(flag.Arg(1)); err != nil {
+			fatal("unable to open database", err)
+		}
+	}
+
+	bout := bufio.NewWriter(os.Stdout)
+	emit, err := printer(bout)
+	if err != nil {
+		fatal("bad -format", err)
+	}
+
+	n, err := search(in, bout, match, emit)
+	if err != nil {
+		bout.Flush()
+		fatal("unable to read input", err)
+	}
+	if err = bout.Flush(); err != nil {
+		fatal("unable to write output", err)
+	}
+
+	if n == 0 {
+		os.Exit(1)
+	}
+}
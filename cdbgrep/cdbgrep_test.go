@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// parseFlags resets cdbgrep's flags, but not the testing package's, and
+// parses args.
+func parseFlags(args []string) error {
+	flag.VisitAll(func(f *flag.Flag) {
+		if !strings.HasPrefix(f.Name, "test.") {
+			f.Value.Set(f.DefValue)
+		}
+	})
+	return flag.CommandLine.Parse(args)
+}
+
+func TestGrep(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := cdbmap.NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for _, r := range [][2]string{
+		{"apple", "red"},
+		{"Apricot", "orange"},
+		{"banana", "yellow\tripe\nsweet"},
+		{"cherry", `back\slash`},
+		{"apple", "green"},
+	} {
+		if err = w.Put([]byte(r[0]), []byte(r[1])); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	tests := []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"ap"}, "apple\tred\napple\tgreen\n"},
+		{[]string{"-i", "ap"}, "apple\tred\nApricot\torange\napple\tgreen\n"},
+		{[]string{"-F", "e.t"}, ""},
+		{[]string{"e.t"}, "banana\tyellow\\tripe\\nsweet\n"},
+		{[]string{"-keys", "e"}, "apple\tred\ncherry\tback\\\\slash\napple\tgreen\n"},
+		{[]string{"-values", "e"}, "apple\tred\nApricot\torange\nbanana\tyellow\\tripe\\nsweet\napple\tgreen\n"},
+		{[]string{"-v", "p"}, "cherry\tback\\\\slash\n"},
+		{[]string{"-l", "r"}, "apple\nApricot\nbanana\ncherry\n"},
+		{[]string{"-c", "^a"}, "2\n"},
+		{[]string{"-format", "dump", "^a"}, "+5,3:apple->red\n+5,5:apple->green\n\n"},
+		{[]string{"-format", "json", "sweet"}, `{"k":"banana","v":"yellow\tripe\nsweet"}` + "\n"},
+	}
+	for _, test := range tests {
+		if err = parseFlags(test.args); err != nil {
+			t.Fatalf("%q: Parse failed: %s", test.args, err)
+		}
+
+		match, err := matcher(flag.Arg(0))
+		if err != nil {
+			t.Fatalf("%q: matcher failed: %s", test.args, err)
+		}
+		out := bytes.NewBuffer(nil)
+		bout := bufio.NewWriter(out)
+		emit, err := printer(bout)
+		if err != nil {
+			t.Fatalf("%q: printer failed: %s", test.args, err)
+		}
+		if _, err = search(tmp, bout, match, emit); err != nil {
+			t.Fatalf("%q: search failed: %s", test.args, err)
+		}
+		bout.Flush()
+		if out.String() != test.expected {
+			t.Errorf("%q: expected %q, got %q", test.args, test.expected, out.String())
+		}
+	}
+
+	parseFlags([]string{"-format", "csv", "x"})
+	if _, err = printer(bufio.NewWriter(bytes.NewBuffer(nil))); err == nil {
+		t.Errorf("printer should reject an unknown format")
+	}
+	if _, err = matcher("("); err == nil {
+		t.Errorf("matcher should reject a bad pattern")
+	}
+}
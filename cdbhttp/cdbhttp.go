@@ -0,0 +1,108 @@
+// Package cdbhttp serves read-only key lookups from a cdb over HTTP.
+//
+// A request for /key returns the values stored under key.  Mount the
+// handler under a prefix with http.StripPrefix.
+package cdbhttp
+
+import (
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Handler serves GET and HEAD requests for /key with the values stored
+// under key, or 404 Not Found if the key is absent.
+type Handler struct {
+	// ContentType is sent with every value.  It defaults to
+	// application/octet-stream.
+	ContentType string
+
+	// Separator is written between the values of a key that has more than
+	// one.  It defaults to a newline.
+	Separator string
+
+	c    *cdbmap.Reader
+	etag string
+}
+
+// NewHandler returns a Handler that serves lookups from c.  If etag is not
+// empty it is sent as the ETag of every response and used to answer
+// conditional requests; it should change whenever the database does.
+func NewHandler(c *cdbmap.Reader, etag string) *Handler {
+	return &Handler{
+		ContentType: "application/octet-stream",
+		Separator:   "\n",
+		c:           c,
+		etag:        etag,
+	}
+}
+
+// Open opens the named cdb file and returns a Handler for it, with an ETag
+// derived from the file's size and modification time.  Close releases the
+// file.
+func Open(filename string) (*Handler, error) {
+	fi, err := os.Stat(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := cdbmap.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	etag := fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size())
+	return NewHandler(c, etag), nil
+}
+
+// Close closes the underlying Reader.
+func (h *Handler) Close() error {
+	return h.c.Close()
+}
+
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" && r.Method != "HEAD" {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if h.etag != "" {
+		w.Header().Set("ETag", h.etag)
+		if r.Header.Get("If-None-Match") == h.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	values, err := h.c.GetAll([]byte(strings.TrimPrefix(r.URL.Path, "/")))
+	if err == cdbmap.ErrNotFound {
+		http.NotFound(w, r)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	size := len(h.Separator) * (len(values) - 1)
+	for _, v := range values {
+		size += len(v)
+	}
+	w.Header().Set("Content-Type", h.ContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(size))
+	if r.Method == "HEAD" {
+		return
+	}
+
+	for i, v := range values {
+		if i > 0 {
+			io.WriteString(w, h.Separator)
+		}
+		w.Write(v)
+	}
+}
@@ -0,0 +1,55 @@
+package cdbhttp
+
+import (
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+)
+
+func TestHandler(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	m := map[string][]string{"one": {"1"}, "two": {"2", "22"}}
+	if err = cdbmap.Write(m, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	h, err := Open(tmp.Name())
+	if err != nil {
+		t.Fatalf("Open failed: %s", err)
+	}
+	defer h.Close()
+
+	tests := []struct {
+		path string
+		code int
+		body string
+	}{
+		{"/one", http.StatusOK, "1"},
+		{"/two", http.StatusOK, "2\n22"},
+		{"/three", http.StatusNotFound, "404 page not found\n"},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+		if w.Code != tt.code || w.Body.String() != tt.body {
+			t.Fatalf("GET %s: expected %d %q, got %d %q", tt.path, tt.code, tt.body, w.Code, w.Body.String())
+		}
+	}
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest("GET", "/one", nil)
+	r.Header.Set("If-None-Match", h.etag)
+	h.ServeHTTP(w, r)
+	if w.Code != http.StatusNotModified {
+		t.Fatalf("expected 304 for matching ETag, got %d", w.Code)
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
+)
+
+// tmpname is the temporary file cdbmake-12 created itself, if it did,
+// which fatal removes.  A temporary file named on the command line is
+// left, as cdbmake leaves it.
+var tmpname string
+
+// fatal reports err and exits with djb's code for temporary errors.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbmake-12: fatal: %s: %s\n", what, err)
+	if tmpname != "" {
+		os.Remove(tmpname)
+	}
+	os.Exit(111)
+}
+
+func usage() {
+	fmt.Fprint(os.Stderr, "cdbmake-12: usage: cdbmake-12 f [ftmp]\n")
+	os.Exit(100)
+}
+
+// cdbmake-12 builds a database from lines of a key and a value separated
+// by whitespace, as djb's script of the same name does.  Lines starting
+// with # and blank lines are ignored.
+func main() {
+	var tmp *os.File
+	var err error
+
+	switch len(os.Args) {
+	case 2:
+		dir, _ := path.Split(os.Args[1])
+		if tmp, err = ioutil.TempFile(dir, ""); err == nil {
+			tmpname = tmp.Name()
+			err = tmp.Chmod(0644)
+		}
+	case 3:
+		tmp, err = os.OpenFile(os.Args[2], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
+	default:
+		usage()
+	}
+	if err != nil {
+		fatal("unable to create temporary file", err)
+	}
+
+	w, err := cdbmap.NewWriter(tmp)
+	if err != nil {
+		fatal("unable to write "+tmp.Name(), err)
+	}
+
+	sc := bufio.NewScanner(os.Stdin)
+	sc.Buffer(nil, 1<<30)
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		var value []byte
+		if len(fields) > 1 {
+			value = []byte(fields[1])
+		}
+		if err = w.Put([]byte(fields[0]), value); err != nil {
+			fatal("unable to write "+tmp.Name(), err)
+		}
+	}
+	if err = sc.Err(); err != nil {
+		fatal("unable to read input", err)
+	}
+
+	if err = w.Close(); err != nil {
+		fatal("unable to write "+tmp.Name(), err)
+	}
+	if err = tmp.Sync(); err != nil {
+		fatal("unable to sync "+tmp.Name(), err)
+	}
+	if err = tmp.Close(); err != nil {
+		fatal("unable to close "+tmp.Name(), err)
+	}
+	if err = os.Rename(tmp.Name(), os.Args[1]); err != nil {
+		fatal("unable to move "+tmp.Name()+" to "+os.Args[1], err)
+	}
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestMain runs cdbmake-12 itself instead of the tests when the test
+// binary is re-executed by runCdbmake12.
+func TestMain(m *testing.M) {
+	if os.Getenv("CDBMAKE12_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCdbmake12 runs cdbmake-12 with args and input on its standard input,
+// and returns its exit status.
+func runCdbmake12(t *testing.T, input string, args ...string) int {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CDBMAKE12_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = bytes.NewBuffer(nil)
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	return 0
+}
+
+func TestCdbmake12(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.cdb")
+	input := "# users\none 1\n\ntwo  22 ignored\n  three\none 11\n"
+	if status := runCdbmake12(t, input, name); status != 0 {
+		t.Fatalf("cdbmake-12: exit status %d", status)
+	}
+	m, err := cdbmap.FromFile(name)
+	if err != nil {
+		t.Fatalf("FromFile failed: %s", err)
+	}
+	if want := map[string][]string{"one": {"1", "11"}, "two": {"22"}, "three": {""}}; !reflect.DeepEqual(m, want) {
+		t.Fatalf("expected %q, got %q", want, m)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v", fi.Mode().Perm())
+	}
+
+	// With ftmp, the temporary file is the one named.
+	b := filepath.Join(dir, "b.cdb")
+	if status := runCdbmake12(t, "k v\n", b, filepath.Join(dir, "b.tmp")); status != 0 {
+		t.Fatalf("cdbmake-12 with ftmp: exit status %d", status)
+	}
+	if m, err = cdbmap.FromFile(b); err != nil || !reflect.DeepEqual(m, map[string][]string{"k": {"v"}}) {
+		t.Errorf("cdbmake-12 with ftmp wrote %q (%v)", m, err)
+	}
+
+	// A failure leaves neither the database nor the temporary file: here
+	// the rename fails, as the target is a non-empty directory.
+	target := filepath.Join(dir, "target")
+	if err = os.MkdirAll(filepath.Join(target, "x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if status := runCdbmake12(t, "k v\n", target); status != 111 {
+		t.Errorf("cdbmake-12 onto a directory: expected exit status 111, got %d", status)
+	}
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %s", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	if want := []string{"a.cdb", "b.cdb", "target"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("expected %q, found %q", want, names)
+	}
+
+	for _, args := range [][]string{{}, {"a", "b", "c"}} {
+		if status := runCdbmake12(t, "", args...); status != 100 {
+			t.Errorf("cdbmake-12 with %d arguments: expected exit status 100, got %d", len(args), status)
+		}
+	}
+}
@@ -2,48 +2,90 @@ package main
 
 import (
 	"bufio"
+	"errors"
 	"flag"
 	"fmt"
 	"github.com/clee/go-cdbmap"
 	"io/ioutil"
-	"log"
 	"os"
 	"path"
 )
 
-func exitOnErr(err error) {
-	if err != nil {
-		log.Fatal(err)
+var (
+	jsonIn = flag.Bool("json", false, "read a JSON object of key to values instead of cdbmake records")
+	csvIn  = flag.Bool("csv", false, "read CSV rows of key and value instead of cdbmake records")
+	tsvIn  = flag.Bool("tsv", false, "read tab-separated rows of key and value instead of cdbmake records")
+	header = flag.Bool("header", false, "with -csv or -tsv, skip a header row")
+)
+
+// tmpname is the temporary file cdbmake created itself, if it did, which
+// fatal removes.  A temporary file named on the command line is left, as
+// djb's cdbmake leaves it.
+var tmpname string
+
+// fatal reports err and exits as djb's cdbmake does: 100 if the input is
+// malformed, 111 for any other, temporary, error.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbmake: fatal: %s: %s\n", what, err)
+	if tmpname != "" {
+		os.Remove(tmpname)
 	}
+	if errors.Is(err, cdbmap.BadFormatError) {
+		os.Exit(100)
+	}
+	os.Exit(111)
 }
 
 func usage() {
-	fmt.Fprint(os.Stderr, "usage: cdbmake f [ftmp]\n")
-	os.Exit(2)
+	fmt.Fprint(os.Stderr, "cdbmake: usage: cdbmake [-json | -csv | -tsv [-header]] f [ftmp]\n")
+	os.Exit(100)
 }
 
 func main() {
 	var tmp *os.File
 	var err error
 
+	flag.Usage = usage
 	flag.Parse()
 	args := flag.Args()
 	if len(args) == 1 {
 		dir, _ := path.Split(args[0])
-		tmp, err = ioutil.TempFile(dir, "")
-		exitOnErr(err)
+		if tmp, err = ioutil.TempFile(dir, ""); err == nil {
+			tmpname = tmp.Name()
+			err = tmp.Chmod(0644)
+		}
 	} else if len(args) == 2 {
-		tmp, err = os.OpenFile(args[1], os.O_RDWR|os.O_CREATE, 0644)
-		exitOnErr(err)
+		tmp, err = os.OpenFile(args[1], os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0644)
 	} else {
 		usage()
 	}
+	if err != nil {
+		fatal("unable to create temporary file", err)
+	}
 
 	fname := args[0]
-	tmpname := tmp.Name()
 
-	exitOnErr(cdb.Make(tmp, bufio.NewReader(os.Stdin)))
-	exitOnErr(tmp.Sync())
-	exitOnErr(tmp.Close())
-	exitOnErr(os.Rename(tmpname, fname))
+	in := bufio.NewReader(os.Stdin)
+	switch {
+	case *jsonIn:
+		err = cdbmap.MakeJSON(tmp, in)
+	case *csvIn:
+		err = cdbmap.MakeCSV(tmp, in, cdbmap.CSVOptions{Header: *header})
+	case *tsvIn:
+		err = cdbmap.MakeCSV(tmp, in, cdbmap.CSVOptions{Comma: '\t', Header: *header})
+	default:
+		err = cdbmap.Make(tmp, in)
+	}
+	if err != nil {
+		fatal("unable to make database", err)
+	}
+	if err = tmp.Sync(); err != nil {
+		fatal("unable to sync "+tmp.Name(), err)
+	}
+	if err = tmp.Close(); err != nil {
+		fatal("unable to close "+tmp.Name(), err)
+	}
+	if err = os.Rename(tmp.Name(), fname); err != nil {
+		fatal("unable to move "+tmp.Name()+" to "+fname, err)
+	}
 }
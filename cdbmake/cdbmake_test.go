@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// TestMain runs cdbmake itself instead of the tests when the test binary
+// is re-executed by runCdbmake.
+func TestMain(m *testing.M) {
+	if os.Getenv("CDBMAKE_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCdbmake runs cdbmake with args and input on its standard input, and
+// returns its exit status.
+func runCdbmake(t *testing.T, input string, args ...string) int {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(os.Environ(), "CDBMAKE_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader(input)
+	cmd.Stderr = bytes.NewBuffer(nil)
+	err := cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return e.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	return 0
+}
+
+// dirNames returns the names of the files in dir.
+func dirNames(t *testing.T, dir string) []string {
+	fis, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir failed: %s", err)
+	}
+	var names []string
+	for _, fi := range fis {
+		names = append(names, fi.Name())
+	}
+	return names
+}
+
+func TestCdbmake(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "a.cdb")
+	if status := runCdbmake(t, "+3,1:one->1\n+3,2:two->22\n\n", name); status != 0 {
+		t.Fatalf("cdbmake: exit status %d", status)
+	}
+	m, err := cdbmap.FromFile(name)
+	if err != nil {
+		t.Fatalf("FromFile failed: %s", err)
+	}
+	if want := map[string][]string{"one": {"1"}, "two": {"22"}}; !reflect.DeepEqual(m, want) {
+		t.Fatalf("expected %q, got %q", want, m)
+	}
+	fi, err := os.Stat(name)
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	if runtime.GOOS != "windows" && fi.Mode().Perm() != 0644 {
+		t.Errorf("expected mode 0644, got %v", fi.Mode().Perm())
+	}
+	if names := dirNames(t, dir); !reflect.DeepEqual(names, []string{"a.cdb"}) {
+		t.Errorf("expected only a.cdb, found %q", names)
+	}
+
+	// Bad input leaves neither the database nor the temporary file.
+	bad := filepath.Join(dir, "bad.cdb")
+	if status := runCdbmake(t, "+3,1:one=>1\n\n", bad); status != 100 {
+		t.Errorf("cdbmake of bad input: expected exit status 100, got %d", status)
+	}
+	if names := dirNames(t, dir); !reflect.DeepEqual(names, []string{"a.cdb"}) {
+		t.Errorf("bad input left %q", names)
+	}
+
+	// With ftmp, the temporary file is the one named.
+	b := filepath.Join(dir, "b.cdb")
+	if status := runCdbmake(t, "{\"k\":[\"v\"]}", "-json", b, filepath.Join(dir, "b.tmp")); status != 0 {
+		t.Fatalf("cdbmake -json: exit status %d", status)
+	}
+	if m, err = cdbmap.FromFile(b); err != nil || !reflect.DeepEqual(m, map[string][]string{"k": {"v"}}) {
+		t.Errorf("cdbmake -json wrote %q (%v)", m, err)
+	}
+
+	if status := runCdbmake(t, "", "a", "b", "c"); status != 100 {
+		t.Errorf("cdbmake with three arguments: expected exit status 100, got %d", status)
+	}
+}
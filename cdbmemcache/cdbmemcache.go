@@ -0,0 +1,154 @@
+// Package cdbmemcache serves read-only lookups from cdb files over the
+// memcached text protocol, so existing memcached clients can read them.
+//
+// The retrieval commands get and gets are supported, along with version
+// and quit.  Storage and other commands that would change data are
+// answered with SERVER_ERROR.  Every value is returned with flags 0, and
+// gets reports a cas unique of 0.
+package cdbmemcache
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io"
+	"net"
+	"strconv"
+)
+
+// Getter looks up the first value of a key, returning cdbmap.ErrNotFound if
+// there is none.  *cdbmap.Reader, *cdbmap.WatchingReader and
+// *cdbmap.ShardedReader all implement it.
+type Getter interface {
+	GetFirst(key []byte) ([]byte, error)
+}
+
+// Server answers memcached requests from one or more databases.  A key is
+// looked up in each database in turn and the first value found is
+// returned.
+type Server struct {
+	dbs []Getter
+}
+
+// NewServer returns a Server backed by dbs.
+func NewServer(dbs ...Getter) *Server {
+	return &Server{dbs: dbs}
+}
+
+// Serve accepts connections on ln and serves each in its own goroutine.
+// It returns when Accept fails.
+func (s *Server) Serve(ln net.Listener) error {
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			return err
+		}
+		go s.ServeConn(conn)
+	}
+}
+
+// storageCommands are followed by a data block, which must be skipped.
+var storageCommands = map[string]bool{
+	"set": true, "add": true, "replace": true, "append": true, "prepend": true, "cas": true,
+}
+
+// ServeConn answers requests on conn until the client quits or the
+// connection fails, and then closes it.
+func (s *Server) ServeConn(conn io.ReadWriteCloser) {
+	defer conn.Close()
+
+	rb := bufio.NewReader(conn)
+	wb := bufio.NewWriter(conn)
+	for {
+		line, err := rb.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		fields := bytes.Fields(line)
+		if len(fields) == 0 {
+			wb.WriteString("ERROR\r\n")
+			continue
+		}
+
+		switch cmd := string(fields[0]); {
+		case cmd == "get" || cmd == "gets":
+			if len(fields) < 2 {
+				wb.WriteString("ERROR\r\n")
+				break
+			}
+			if err = s.get(wb, fields[1:], cmd == "gets"); err != nil {
+				wb.WriteString("SERVER_ERROR " + err.Error() + "\r\n")
+			}
+		case cmd == "version":
+			wb.WriteString("VERSION cdbmap\r\n")
+		case cmd == "quit":
+			wb.Flush()
+			return
+		case storageCommands[cmd]:
+			// The data block length is the fifth field.
+			if len(fields) < 5 {
+				wb.WriteString("ERROR\r\n")
+				break
+			}
+			n, err := strconv.ParseUint(string(fields[4]), 10, 32)
+			if err != nil {
+				wb.WriteString("CLIENT_ERROR bad data chunk\r\n")
+				break
+			}
+			if _, err = rb.Discard(int(n) + 2); err != nil {
+				return
+			}
+			wb.WriteString("SERVER_ERROR read-only\r\n")
+		case cmd == "delete" || cmd == "incr" || cmd == "decr" || cmd == "touch" || cmd == "flush_all":
+			wb.WriteString("SERVER_ERROR read-only\r\n")
+		default:
+			wb.WriteString("ERROR\r\n")
+		}
+
+		// Flush once the pipelined requests already received are answered.
+		if rb.Buffered() == 0 {
+			if err = wb.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// get writes the values of keys found in any database, followed by END.
+func (s *Server) get(wb *bufio.Writer, keys [][]byte, cas bool) error {
+	// Look every key up before writing, so an error can still be reported
+	// on its own.
+	values := make([][]byte, len(keys))
+	for i, key := range keys {
+		for _, db := range s.dbs {
+			v, err := db.GetFirst(key)
+			if err == cdbmap.ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			values[i] = v
+			break
+		}
+	}
+
+	for i, v := range values {
+		if v == nil {
+			continue
+		}
+		wb.WriteString("VALUE ")
+		wb.Write(keys[i])
+		wb.WriteString(" 0 ")
+		wb.WriteString(strconv.Itoa(len(v)))
+		if cas {
+			wb.WriteString(" 0")
+		}
+		wb.WriteString("\r\n")
+		wb.Write(v)
+		wb.WriteString("\r\n")
+	}
+	wb.WriteString("END\r\n")
+
+	return nil
+}
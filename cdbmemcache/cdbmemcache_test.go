@@ -0,0 +1,42 @@
+package cdbmemcache
+
+import (
+	"bytes"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"net"
+	"testing"
+)
+
+func TestServer(t *testing.T) {
+	var dbs []Getter
+	for _, m := range []map[string][]string{{"one": {"1"}}, {"one": {"11"}, "two": {"2"}}} {
+		buf := bytes.NewBuffer(nil)
+		if err := cdbmap.WriteStream(m, buf); err != nil {
+			t.Fatalf("WriteStream failed: %s", err)
+		}
+		c, err := cdbmap.New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("New failed: %s", err)
+		}
+		dbs = append(dbs, c)
+	}
+
+	client, server := net.Pipe()
+	go NewServer(dbs...).ServeConn(server)
+
+	go func() {
+		client.Write([]byte("get one three two\r\ngets two\r\nset x 0 0 2\r\nxx\r\nquit\r\n"))
+	}()
+	got, err := ioutil.ReadAll(client)
+	if err != nil {
+		t.Fatalf("ReadAll failed: %s", err)
+	}
+
+	expected := "VALUE one 0 1\r\n1\r\nVALUE two 0 1\r\n2\r\nEND\r\n" +
+		"VALUE two 0 1 0\r\n2\r\nEND\r\n" +
+		"SERVER_ERROR read-only\r\n"
+	if string(got) != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+func fatal(err error) {
+	fmt.Fprintf(os.Stderr, "cdbrepair: fatal: %s\n", err)
+	os.Exit(111)
+}
+
+func main() {
+	if len(os.Args) != 3 {
+		fmt.Fprint(os.Stderr, "cdbrepair: usage: cdbrepair damaged.cdb repaired.cdb\n")
+		os.Exit(111)
+	}
+
+	in, err := os.Open(os.Args[1])
+	if err != nil {
+		fatal(err)
+	}
+	defer in.Close()
+
+	// Write to a temporary file and rename it into place, as cdbmake does.
+	out := os.Args[2]
+	tmp, err := ioutil.TempFile(filepath.Dir(out), filepath.Base(out)+".tmp")
+	if err != nil {
+		fatal(err)
+	}
+	n, err := cdbmap.Recover(in, tmp)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if e := tmp.Close(); err == nil {
+		err = e
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), out)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		fatal(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "cdbrepair: recovered %d records\n", n)
+}
@@ -0,0 +1,96 @@
+// cdbserver answers lookups in a cdb over TCP or a unix socket, so that
+// programs not written in Go can query it.  Each request is a line
+//
+//	GET key
+//
+// answered by a line "VALUE n" followed by the n bytes of the value and a
+// newline for each value of the key, in the order they were written, and
+// then a line "END".  A key that does not exist is answered by "END" alone,
+// and a bad request by a line starting with "ERROR".  On Unix, sending SIGHUP
+// makes cdbserver reopen the file if it has been replaced.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"log"
+	"net"
+	"os"
+)
+
+var (
+	network  = flag.String("net", "tcp", "network to listen on: tcp or unix")
+	addr     = flag.String("addr", "localhost:5555", "address or socket path to listen on")
+	interval = flag.Duration("interval", 0, "also check the file for replacement this often")
+)
+
+func usage() {
+	fmt.Fprint(os.Stderr, "usage: cdbserver [-net tcp|unix] [-addr address] [-interval duration] file\n")
+	os.Exit(2)
+}
+
+func serve(conn net.Conn, db *cdbmap.WatchingReader) {
+	defer conn.Close()
+
+	rb := bufio.NewReader(conn)
+	wb := bufio.NewWriter(conn)
+	for {
+		line, err := rb.ReadBytes('\n')
+		if err != nil {
+			return
+		}
+		line = bytes.TrimRight(line, "\r\n")
+
+		if !bytes.HasPrefix(line, []byte("GET ")) {
+			wb.WriteString("ERROR unknown command\n")
+		} else {
+			values, err := db.GetAll(line[4:])
+			if err != nil && err != cdbmap.ErrNotFound {
+				fmt.Fprintf(wb, "ERROR %s\n", err)
+			} else {
+				for _, v := range values {
+					fmt.Fprintf(wb, "VALUE %d\n", len(v))
+					wb.Write(v)
+					wb.WriteByte('\n')
+				}
+				wb.WriteString("END\n")
+			}
+		}
+
+		// Flush once the pipelined requests already received are answered.
+		if rb.Buffered() == 0 {
+			if err = wb.Flush(); err != nil {
+				return
+			}
+		}
+	}
+}
+
+func main() {
+	flag.Parse()
+	if flag.NArg() != 1 {
+		usage()
+	}
+
+	db, err := cdbmap.Watch(flag.Arg(0), *interval)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	reloadOnHangup(db)
+
+	ln, err := net.Listen(*network, *addr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	for {
+		conn, err := ln.Accept()
+		if err != nil {
+			log.Fatal(err)
+		}
+		go serve(conn, db)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"github.com/clee/go-cdbmap"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// request sends req to a connection served by serve and returns the first
+// n bytes of the response.
+func request(t *testing.T, db *cdbmap.WatchingReader, req string, n int) string {
+	client, server := net.Pipe()
+	defer client.Close()
+	go serve(server, db)
+
+	go client.Write([]byte(req))
+	got := make([]byte, n)
+	if _, err := io.ReadFull(client, got); err != nil {
+		t.Fatalf("ReadFull failed: %s", err)
+	}
+	return string(got)
+}
+
+func TestServe(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.cdb")
+	if err = cdbmap.ToFile(map[string][]string{"one": {"1", "11"}, "a b": {"line\nbreak"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	db, err := cdbmap.Watch(name, 0)
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	defer db.Close()
+
+	expected := "VALUE 1\n1\nVALUE 2\n11\nEND\n" +
+		"END\n" +
+		"VALUE 10\nline\nbreak\nEND\n" +
+		"ERROR unknown command\n"
+	if got := request(t, db, "GET one\r\nGET two\nGET a b\nPUT one 1\n", len(expected)); got != expected {
+		t.Fatalf("expected %q, got %q", expected, got)
+	}
+
+	// A replaced file is only seen once reloaded.
+	if err = cdbmap.ToFile(map[string][]string{"two": {"2"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	expected = "END\n"
+	if got := request(t, db, "GET two\n", len(expected)); got != expected {
+		t.Fatalf("before Reload: expected %q, got %q", expected, got)
+	}
+	if err = db.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	expected = "VALUE 1\n2\nEND\n"
+	if got := request(t, db, "GET two\n", len(expected)); got != expected {
+		t.Fatalf("after Reload: expected %q, got %q", expected, got)
+	}
+}
@@ -0,0 +1,9 @@
+//go:build !darwin && !dragonfly && !freebsd && !linux && !netbsd && !openbsd
+
+package main
+
+import "github.com/clee/go-cdbmap"
+
+// reloadOnHangup does nothing where there is no SIGHUP; -interval still
+// picks up a replaced file.
+func reloadOnHangup(db *cdbmap.WatchingReader) {}
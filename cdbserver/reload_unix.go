@@ -0,0 +1,24 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"github.com/clee/go-cdbmap"
+	"log"
+	"os"
+	"os/signal"
+	"syscall"
+)
+
+// reloadOnHangup reopens db whenever cdbserver receives SIGHUP.
+func reloadOnHangup(db *cdbmap.WatchingReader) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	go func() {
+		for range hup {
+			if err := db.Reload(); err != nil {
+				log.Printf("reload failed: %s", err)
+			}
+		}
+	}()
+}
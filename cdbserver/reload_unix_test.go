@@ -0,0 +1,47 @@
+//go:build darwin || dragonfly || freebsd || linux || netbsd || openbsd
+
+package main
+
+import (
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestReloadOnHangup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.cdb")
+	if err = cdbmap.ToFile(map[string][]string{"one": {"1"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	db, err := cdbmap.Watch(name, 0)
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	defer db.Close()
+
+	reloadOnHangup(db)
+	if err = cdbmap.ToFile(map[string][]string{"two": {"2"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	if err = syscall.Kill(os.Getpid(), syscall.SIGHUP); err != nil {
+		t.Fatalf("Kill failed: %s", err)
+	}
+
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if v, err := db.Get("two"); err == nil && len(v) == 1 && v[0] == "2" {
+			return
+		}
+	}
+	t.Fatalf("database not reloaded after SIGHUP")
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"os"
+)
+
+var tables = flag.Bool("t", false, "also print per-table record and slot counts")
+
+func main() {
+	flag.Parse()
+
+	s, err := cdbmap.Stats(os.Stdin)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "cdbstats: fatal: %s\n", err)
+		os.Exit(111)
+	}
+
+	bout := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(bout, "records %d\n", s.Records)
+	fmt.Fprintf(bout, "keybytes %d\n", s.KeyBytes)
+	fmt.Fprintf(bout, "databytes %d\n", s.DataBytes)
+	fmt.Fprintf(bout, "slots %d\n", s.Slots)
+	fmt.Fprintf(bout, "load %.3f\n", s.Load())
+	fmt.Fprintf(bout, "maxprobe %d\n", s.MaxProbe)
+	for i, n := range s.Probes[:10] {
+		fmt.Fprintf(bout, "d%d %d\n", i, n)
+	}
+	fmt.Fprintf(bout, ">9 %d\n", s.Probes[10])
+	fmt.Fprintf(bout, "collisions %d\n", s.HashCollisions)
+
+	if *tables {
+		for i, t := range s.Tables {
+			fmt.Fprintf(bout, "table %d %d %d %.3f\n", i, t.Records, t.Slots, t.Load())
+		}
+	}
+
+	if err = bout.Flush(); err != nil {
+		os.Exit(111)
+	}
+}
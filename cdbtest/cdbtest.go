@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/clee/go-cdbmap"
+	"os"
+)
+
+// maxKeyLen is the longest key djb's cdbtest looks up; longer keys are
+// counted as untested.
+const maxKeyLen = 1024
+
+// fatal reports err and exits as djb's cdbtest does: 100 if the database
+// is corrupt, 111 for any other, temporary, error.
+func fatal(what string, err error) {
+	fmt.Fprintf(os.Stderr, "cdbtest: fatal: %s: %s\n", what, err)
+	if errors.Is(err, cdbmap.ErrCorruptHeader) || errors.Is(err, cdbmap.ErrCorruptRecord) {
+		os.Exit(100)
+	}
+	os.Exit(111)
+}
+
+// cdbtest reads a database from standard input and checks that every
+// record in the data section can be found through the hash tables.
+func main() {
+	c, err := cdbmap.New(os.Stdin)
+	if err != nil {
+		fatal("unable to read input", err)
+	}
+
+	var found, different, badLength, notFound, untested int
+	seen := make(map[string]int)
+	err = c.Iterate(func(key, value []byte) error {
+		if len(key) > maxKeyLen {
+			untested++
+			return nil
+		}
+
+		skip := seen[string(key)]
+		seen[string(key)]++
+		v, err := c.GetAt(key, skip)
+		switch {
+		case err == cdbmap.ErrNotFound:
+			notFound++
+		case err != nil:
+			return err
+		case len(v) != len(value):
+			badLength++
+		case !bytes.Equal(v, value):
+			different++
+		default:
+			found++
+		}
+		return nil
+	})
+	if err != nil {
+		fatal("unable to read input", err)
+	}
+
+	bout := bufio.NewWriter(os.Stdout)
+	fmt.Fprintf(bout, "found: %d\n", found)
+	fmt.Fprintf(bout, "different record: %d\n", different)
+	fmt.Fprintf(bout, "bad length: %d\n", badLength)
+	fmt.Fprintf(bout, "not found: %d\n", notFound)
+	fmt.Fprintf(bout, "untested: %d\n", untested)
+	if err = bout.Flush(); err != nil {
+		fatal("unable to write output", err)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/clee/go-cdbmap"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// TestMain runs cdbtest itself instead of the tests when the test binary
+// is re-executed by runCdbtest.
+func TestMain(m *testing.M) {
+	if os.Getenv("CDBTEST_TEST_MAIN") != "" {
+		main()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+// runCdbtest runs cdbtest with db as its input, and returns its output
+// and exit status.
+func runCdbtest(t *testing.T, db []byte) (string, int) {
+	in, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(in.Name())
+	defer in.Close()
+
+	if _, err = in.Write(db); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	cmd := exec.Command(os.Args[0])
+	cmd.Env = append(os.Environ(), "CDBTEST_TEST_MAIN=1")
+	cmd.Stdin = in
+	out := bytes.NewBuffer(nil)
+	cmd.Stdout = out
+	err = cmd.Run()
+	if e, ok := err.(*exec.ExitError); ok {
+		return out.String(), e.ExitCode()
+	}
+	if err != nil {
+		t.Fatalf("Run failed: %s", err)
+	}
+	return out.String(), 0
+}
+
+func TestCdbtest(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := cdbmap.NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for _, r := range [][2]string{{"one", "1"}, {"two", "2"}, {"one", "11"}, {strings.Repeat("k", 1025), "long"}} {
+		if err = w.Put([]byte(r[0]), []byte(r[1])); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+	db, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "found: 3\ndifferent record: 0\nbad length: 0\nnot found: 0\nuntested: 1\n"
+	if out, status := runCdbtest(t, db); out != expected || status != 0 {
+		t.Errorf("good database: expected %q and status 0, got %q and %d", expected, out, status)
+	}
+
+	// Empty every hash table slot, so no record can be found.
+	lost := append([]byte(nil), db...)
+	eod := binary.LittleEndian.Uint32(lost)
+	for i := int(eod); i < len(lost); i++ {
+		lost[i] = 0
+	}
+	expected = "found: 0\ndifferent record: 0\nbad length: 0\nnot found: 3\nuntested: 1\n"
+	if out, status := runCdbtest(t, lost); out != expected || status != 0 {
+		t.Errorf("emptied hash tables: expected %q and status 0, got %q and %d", expected, out, status)
+	}
+
+	if _, status := runCdbtest(t, db[:100]); status != 100 {
+		t.Errorf("truncated database: expected status 100, got %d", status)
+	}
+}
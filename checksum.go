@@ -0,0 +1,93 @@
+package cdbmap
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// ChecksumError is returned when a value or file fails checksum
+// verification.
+var ChecksumError = errors.New("checksum mismatch")
+
+// A database written with WriterOptions.Checksum stores a little-endian
+// CRC-32 (IEEE) of each value in the last checksumSize bytes of the value,
+// and is followed by a trailer holding the CRC-32 of the header, the
+// CRC-32 of everything from the end of the header to the end of the hash
+// tables, and checksumMagic.
+const (
+	checksumSize        = 4
+	checksumMagic       = "cdbmapC1"
+	checksumTrailerSize = 4 + 4 + len(checksumMagic)
+)
+
+// splitChecksum checks the checksum at the end of data and returns the
+// value before it.
+func splitChecksum(data []byte) ([]byte, error) {
+	if len(data) < checksumSize {
+		return nil, ChecksumError
+	}
+
+	value, sum := data[:len(data)-checksumSize], data[len(data)-checksumSize:]
+	if binary.LittleEndian.Uint32(sum) != crc32.ChecksumIEEE(value) {
+		return nil, ChecksumError
+	}
+	return value, nil
+}
+
+func checksumTrailer(headerSum, bodySum uint32) []byte {
+	trailer := make([]byte, checksumTrailerSize)
+	binary.LittleEndian.PutUint32(trailer, headerSum)
+	binary.LittleEndian.PutUint32(trailer[4:], bodySum)
+	copy(trailer[8:], checksumMagic)
+	return trailer
+}
+
+// tablesEnd returns the position just past the last hash table.
+func tablesEnd(f Format, t *[256]table) uint64 {
+	return t[255].pos + 2*uint64(f.numSize())*t[255].nslots
+}
+
+// readChecksumTrailer returns the checksum trailer following the hash
+// tables, or nil if there is none.
+func readChecksumTrailer(r io.ReaderAt, f Format, t *[256]table) []byte {
+	trailer := make([]byte, checksumTrailerSize)
+	if _, err := r.ReadAt(trailer, int64(tablesEnd(f, t))); err != nil {
+		return nil
+	}
+	if string(trailer[8:]) != checksumMagic {
+		return nil
+	}
+	return trailer
+}
+
+// VerifyChecksums checks the whole-file checksums in the trailer of a
+// database written with WriterOptions.Checksum, reading the entire file.
+// It returns BadFormatError if the database has no checksums.
+func (c *Reader) VerifyChecksums() error {
+	trailer := readChecksumTrailer(c.r, c.format, &c.tables)
+	if trailer == nil {
+		return BadFormatError
+	}
+
+	header := make([]byte, c.format.headerSize())
+	if _, err := c.r.ReadAt(header, 0); err != nil {
+		return corrupt(ErrCorruptHeader, err)
+	}
+	if crc32.ChecksumIEEE(header) != binary.LittleEndian.Uint32(trailer) {
+		return ChecksumError
+	}
+
+	start := int64(c.format.headerSize())
+	body := io.NewSectionReader(c.r, start, int64(tablesEnd(c.format, &c.tables))-start)
+	sum := crc32.NewIEEE()
+	if _, err := io.Copy(sum, body); err != nil {
+		return err
+	}
+	if sum.Sum32() != binary.LittleEndian.Uint32(trailer[4:]) {
+		return ChecksumError
+	}
+
+	return nil
+}
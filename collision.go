@@ -0,0 +1,95 @@
+package cdbmap
+
+import (
+	"io"
+	"sort"
+)
+
+// probeSlot is a used hash table slot, with the number of slots a lookup
+// of its hash probes past the initial one to reach it.
+type probeSlot struct {
+	h         uint32
+	dist, pos uint64
+}
+
+// collisionChains calls fn for each hash shared by more than one of the
+// used slots of one table, with the keys of the records those slots point
+// at and the records' positions, in the order a lookup of the hash visits
+// them.  Only the keys of those records are read.  slots is reordered.
+func collisionChains(r io.ReaderAt, f Format, slots []probeSlot, fn func(keys [][]byte, pos []uint64) error) error {
+	sort.Slice(slots, func(a, b int) bool {
+		if slots[a].h != slots[b].h {
+			return slots[a].h < slots[b].h
+		}
+		return slots[a].dist < slots[b].dist
+	})
+
+	n := uint64(f.numSize())
+	buf := make([]byte, 2*n)
+	for i := 0; i < len(slots); {
+		j := i + 1
+		for j < len(slots) && slots[j].h == slots[i].h {
+			j++
+		}
+		if j-i == 1 {
+			i = j
+			continue
+		}
+
+		keys := make([][]byte, 0, j-i)
+		pos := make([]uint64, 0, j-i)
+		for _, s := range slots[i:j] {
+			if _, err := r.ReadAt(buf, int64(s.pos)); err != nil {
+				return corrupt(ErrCorruptRecord, err)
+			}
+			key, err := readFullAt(r, nil, s.pos+2*n, f.getNum(buf))
+			if err != nil {
+				return corrupt(ErrCorruptRecord, err)
+			}
+			keys = append(keys, key)
+			pos = append(pos, s.pos)
+		}
+		if err := fn(keys, pos); err != nil {
+			return err
+		}
+		i = j
+	}
+
+	return nil
+}
+
+// countCollisions returns the number of true hash collisions in the hash
+// tables t: for each 32-bit hash, the number of different keys with that
+// hash beyond the first.
+func countCollisions(r io.ReaderAt, f Format, t *[256]table) (uint64, error) {
+	var n uint64
+	count := func(keys [][]byte, _ []uint64) error {
+		seen := make(map[string]bool, len(keys))
+		for _, k := range keys {
+			seen[string(k)] = true
+		}
+		n += uint64(len(seen) - 1)
+		return nil
+	}
+
+	var slots []probeSlot
+	cur := 0
+	err := walkSlots(r, f, t, func(i int, j, h, pos uint64) error {
+		if i != cur {
+			if err := collisionChains(r, f, slots, count); err != nil {
+				return err
+			}
+			slots, cur = slots[:0], i
+		}
+		if pos != 0 {
+			nslots := t[i].nslots
+			slots = append(slots, probeSlot{uint32(h), (j + nslots - (h/256)%nslots) % nslots, pos})
+		}
+		return nil
+	})
+	if err == nil {
+		err = collisionChains(r, f, slots, count)
+	}
+
+	return n, err
+}
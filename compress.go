@@ -0,0 +1,308 @@
+package cdbmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"io"
+)
+
+// A compressed database keeps values in DEFLATE-compressed blocks of about
+// compressedBlockSize bytes each.  It is laid out as
+//
+//	index     a standard cdb mapping each key to references to its values
+//	marker    compressedMagic, by which New recognizes the file
+//	blocks    the compressed blocks, one after another
+//	offsets   nblocks+1 little-endian uint64 block start offsets
+//	trailer   index length, offsets position (uint64 each), compressedMagic
+//
+// Each index value is three uvarints: block number, offset of the value
+// within the uncompressed block, and value length.  Since the file begins
+// with an ordinary cdb, other tools can still list its keys.  A block
+// holds at most compressedBlockSize bytes once decompressed, unless it
+// holds a single larger value.
+const (
+	compressedBlockSize   = 64 << 10
+	compressedMagic       = "cdbmapZ1"
+	compressedTrailerSize = 8 + 8 + len(compressedMagic)
+)
+
+// WriteCompressed writes the map in m to w as a compressed database, to be
+// read with OpenCompressed or NewCompressed.
+func WriteCompressed(m map[string][]string, w io.WriteSeeker) (err error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	// Lay the values out in blocks and write the index first; references
+	// only depend on uncompressed sizes.
+	cw, err := NewWriter(w)
+	if err != nil {
+		return
+	}
+	defer cw.Abort()
+	var block, off uint64
+	ref := make([]byte, 3*binary.MaxVarintLen64)
+	for _, k := range keys {
+		for _, v := range m[k] {
+			if off > 0 && off+uint64(len(v)) > compressedBlockSize {
+				block, off = block+1, 0
+			}
+			n := binary.PutUvarint(ref, block)
+			n += binary.PutUvarint(ref[n:], off)
+			n += binary.PutUvarint(ref[n:], uint64(len(v)))
+			if err = cw.Put([]byte(k), ref[:n]); err != nil {
+				return
+			}
+			off += uint64(len(v))
+		}
+	}
+	if err = cw.Close(); err != nil {
+		return
+	}
+
+	indexLen, err := w.Seek(0, 2)
+	if err != nil {
+		return
+	}
+	if _, err = io.WriteString(w, compressedMagic); err != nil {
+		return
+	}
+
+	// Compress the blocks in the same order.
+	start := uint64(indexLen) + uint64(len(compressedMagic))
+	offsets := []uint64{start}
+	cb := &countWriter{w: w, n: start}
+	zw, err := flate.NewWriter(cb, flate.DefaultCompression)
+	if err != nil {
+		return
+	}
+	off = 0
+	for _, k := range keys {
+		for _, v := range m[k] {
+			if off > 0 && off+uint64(len(v)) > compressedBlockSize {
+				if err = zw.Close(); err != nil {
+					return
+				}
+				offsets = append(offsets, cb.n)
+				zw.Reset(cb)
+				off = 0
+			}
+			if _, err = io.WriteString(zw, v); err != nil {
+				return
+			}
+			off += uint64(len(v))
+		}
+	}
+	if err = zw.Close(); err != nil {
+		return
+	}
+	offsets = append(offsets, cb.n)
+
+	tail := make([]byte, 8*len(offsets)+compressedTrailerSize)
+	for i, o := range offsets {
+		binary.LittleEndian.PutUint64(tail[8*i:], o)
+	}
+	trailer := tail[8*len(offsets):]
+	binary.LittleEndian.PutUint64(trailer, uint64(indexLen))
+	binary.LittleEndian.PutUint64(trailer[8:], cb.n)
+	copy(trailer[16:], compressedMagic)
+	_, err = w.Write(tail)
+
+	return
+}
+
+// countWriter counts the bytes written through it.
+type countWriter struct {
+	w io.Writer
+	n uint64
+}
+
+func (cw *countWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n += uint64(n)
+	return n, err
+}
+
+// CompressedReader looks up keys in a database written by WriteCompressed,
+// decompressing only the blocks holding the values asked for.  Like Reader,
+// it is safe for concurrent use.
+type CompressedReader struct {
+	index   *Reader
+	r       io.ReaderAt
+	offsets []uint64
+	closer  io.Closer
+	maxSize uint64 // see ReaderOptions.MaxRecordSize
+}
+
+// compressedIndex reports whether the hash tables t are followed by the
+// marker of a compressed database, making the database its index.
+func compressedIndex(r io.ReaderAt, f Format, t *[256]table) bool {
+	marker := make([]byte, len(compressedMagic))
+	_, err := r.ReadAt(marker, int64(tablesEnd(f, t)))
+	return err == nil && string(marker) == compressedMagic
+}
+
+// NewCompressed returns a CompressedReader for the size bytes of r.  It
+// returns BadFormatError if r does not end with a compressed database
+// trailer.
+func NewCompressed(r io.ReaderAt, size int64) (*CompressedReader, error) {
+	return NewCompressedWithOptions(r, size, ReaderOptions{})
+}
+
+// NewCompressedWithOptions is like NewCompressed, but reads the index with
+// opts.  Values larger than opts.MaxRecordSize are rejected with
+// ErrRecordTooLarge before their blocks are decompressed.
+func NewCompressedWithOptions(r io.ReaderAt, size int64, opts ReaderOptions) (*CompressedReader, error) {
+	if size < int64(compressedTrailerSize) {
+		return nil, BadFormatError
+	}
+	trailer := make([]byte, compressedTrailerSize)
+	if _, err := r.ReadAt(trailer, size-int64(len(trailer))); err != nil {
+		return nil, corrupt(ErrCorruptHeader, err)
+	}
+	if string(trailer[16:]) != compressedMagic {
+		return nil, BadFormatError
+	}
+
+	indexLen := binary.LittleEndian.Uint64(trailer)
+	offsetsPos := binary.LittleEndian.Uint64(trailer[8:])
+	end := uint64(size) - uint64(len(trailer))
+	if indexLen > offsetsPos || offsetsPos > end || (end-offsetsPos)%8 != 0 {
+		return nil, corruptf(ErrCorruptHeader, "bad compressed trailer")
+	}
+
+	buf := make([]byte, end-offsetsPos)
+	if _, err := r.ReadAt(buf, int64(offsetsPos)); err != nil {
+		return nil, corrupt(ErrCorruptHeader, err)
+	}
+	offsets := make([]uint64, len(buf)/8)
+	for i := range offsets {
+		offsets[i] = binary.LittleEndian.Uint64(buf[8*i:])
+	}
+
+	index, err := NewWithOptions(io.NewSectionReader(r, 0, int64(indexLen)), opts)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CompressedReader{index: index, r: r, offsets: offsets, maxSize: opts.MaxRecordSize}, nil
+}
+
+// OpenCompressed opens the named compressed database for reading.  The
+// CompressedReader should be closed with Close when no longer needed.
+func OpenCompressed(filename string) (*CompressedReader, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	c, err := NewCompressed(f, fi.Size())
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+
+	return c, nil
+}
+
+// Close closes the file opened by OpenCompressed.
+func (c *CompressedReader) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// Get returns all values stored under key, in the order they were written.
+// It returns ErrNotFound if the key does not exist.
+func (c *CompressedReader) Get(key string) ([]string, error) {
+	values, err := c.GetAll([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+
+	return s, nil
+}
+
+// GetAll returns all values stored under key, in the order they were
+// written.  It returns ErrNotFound if the key does not exist.
+func (c *CompressedReader) GetAll(key []byte) ([][]byte, error) {
+	refs, err := c.index.GetAll(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// Parse every reference first, to learn how much of each block is
+	// needed.
+	type valueRef struct{ block, off, n uint64 }
+	vrefs := make([]valueRef, len(refs))
+	need := make(map[uint64]uint64)
+	for i, ref := range refs {
+		rb := bytes.NewReader(ref)
+		block, err1 := binary.ReadUvarint(rb)
+		off, err2 := binary.ReadUvarint(rb)
+		n, err3 := binary.ReadUvarint(rb)
+		if err1 != nil || err2 != nil || err3 != nil || block+1 >= uint64(len(c.offsets)) {
+			return nil, corruptf(ErrCorruptRecord, "bad value reference %x", ref)
+		}
+		if off > 0 && (off > compressedBlockSize || n > compressedBlockSize-off) {
+			return nil, corruptf(ErrCorruptRecord, "value reference %x outside block %d", ref, block)
+		}
+		if err := checkRecordSize(off, n, c.maxSize); err != nil {
+			return nil, err
+		}
+		vrefs[i] = valueRef{block, off, n}
+		if off+n > need[block] {
+			need[block] = off + n
+		}
+	}
+
+	values := make([][]byte, len(refs))
+	var data []byte
+	cur := uint64(0)
+	for i, ref := range vrefs {
+		// Values of one key are usually in the same block.
+		if data == nil || ref.block != cur {
+			if data, err = c.readBlock(ref.block, need[ref.block]); err != nil {
+				return nil, err
+			}
+			cur = ref.block
+		}
+		values[i] = append([]byte(nil), data[ref.off:ref.off+ref.n]...)
+	}
+
+	return values, nil
+}
+
+// readBlock reads block i and decompresses its first n bytes.  Only n bytes
+// are inflated, however much the block claims to hold, and a block that
+// holds fewer is corrupt.
+func (c *CompressedReader) readBlock(i, n uint64) ([]byte, error) {
+	start, end := c.offsets[i], c.offsets[i+1]
+	if end < start {
+		return nil, corruptf(ErrCorruptHeader, "block %d ends before it starts", i)
+	}
+
+	zr := flate.NewReader(io.NewSectionReader(c.r, int64(start), int64(end-start)))
+	defer zr.Close()
+
+	data, err := readFull(zr, nil, n)
+	if err != nil {
+		return nil, corrupt(ErrCorruptRecord, unexpected(err))
+	}
+	return data, nil
+}
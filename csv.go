@@ -0,0 +1,92 @@
+package cdbmap
+
+import (
+	"encoding/csv"
+	"io"
+)
+
+// CSVOptions configures DumpCSV and MakeCSV.  Each row holds a key and one
+// value; a key with several values takes several rows.
+type CSVOptions struct {
+	// Comma is the field delimiter.  It defaults to ','; use '\t' for
+	// TSV.
+	Comma rune
+
+	// Header writes, or expects and skips, a first row of "key,value".
+	Header bool
+
+	// LazyQuotes allows quotes in unquoted fields and unescaped quotes in
+	// quoted fields when reading, as spreadsheets sometimes produce.
+	LazyQuotes bool
+}
+
+func (opts CSVOptions) comma() rune {
+	if opts.Comma == 0 {
+		return ','
+	}
+	return opts.Comma
+}
+
+// DumpCSV writes every record of the cdb in r to w as a CSV row of key and
+// value, in the order the records were written.  Fields are quoted as
+// needed.
+func DumpCSV(w io.Writer, r io.ReaderAt, opts CSVOptions) error {
+	cw := csv.NewWriter(w)
+	cw.Comma = opts.comma()
+
+	if opts.Header {
+		if err := cw.Write([]string{"key", "value"}); err != nil {
+			return err
+		}
+	}
+
+	row := make([]string, 2)
+	err := Iterate(r, func(key, value []byte) error {
+		row[0], row[1] = string(key), string(value)
+		return cw.Write(row)
+	})
+	if err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// MakeCSV reads CSV rows of key and value from r, as written by DumpCSV,
+// and writes them as cdb records to w in the order given.  A row without
+// exactly two fields is an error.
+func MakeCSV(w io.WriteSeeker, r io.Reader, opts CSVOptions) error {
+	cr := csv.NewReader(r)
+	cr.Comma = opts.comma()
+	cr.FieldsPerRecord = 2
+	cr.LazyQuotes = opts.LazyQuotes
+	cr.ReuseRecord = true
+
+	if opts.Header {
+		if _, err := cr.Read(); err != nil && err != io.EOF {
+			return err
+		}
+	}
+
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err = cw.Put([]byte(row[0]), []byte(row[1])); err != nil {
+			return err
+		}
+	}
+
+	return cw.Close()
+}
@@ -0,0 +1,84 @@
+package cdbmap
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// Database is an in-memory database, the map Read returns with methods to
+// query and change it.  It implements io.WriterTo and io.ReaderFrom, so it
+// can be serialized to and from any stream.  The zero value is an empty
+// Database ready to use.
+type Database struct {
+	m map[string][]string
+}
+
+// NewDatabase returns a Database holding m.  The map is used directly, not
+// copied.
+func NewDatabase(m map[string][]string) *Database {
+	return &Database{m: m}
+}
+
+// Map returns the map holding the database's contents.
+func (db *Database) Map() map[string][]string {
+	if db.m == nil {
+		db.m = make(map[string][]string)
+	}
+	return db.m
+}
+
+// Get returns the values stored under key, or nil if there are none.
+func (db *Database) Get(key string) []string {
+	return db.m[key]
+}
+
+// Set replaces the values stored under key.
+func (db *Database) Set(key string, values ...string) {
+	db.Map()[key] = values
+}
+
+// Delete removes key and all its values.
+func (db *Database) Delete(key string) {
+	delete(db.m, key)
+}
+
+// Len returns the number of keys in the database.
+func (db *Database) Len() int {
+	return len(db.m)
+}
+
+// WriteTo writes the database to w in cdb format, as WriteStream does.
+func (db *Database) WriteTo(w io.Writer) (int64, error) {
+	cw := &countWriter{w: w}
+	err := WriteStream(db.m, cw)
+	return int64(cw.n), err
+}
+
+// ReadFrom replaces the contents of the database with the cdb read from r.
+// It consumes r up to EOF, hash tables included.  If reading fails the
+// database is left unchanged.
+func (db *Database) ReadFrom(r io.Reader) (int64, error) {
+	cr := &countReader{r: r}
+	m, err := ReadStream(cr)
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, cr)
+	}
+	if err != nil {
+		return int64(cr.n), err
+	}
+
+	db.m = m
+	return int64(cr.n), nil
+}
+
+// countReader counts the bytes read through it.
+type countReader struct {
+	r io.Reader
+	n uint64
+}
+
+func (cr *countReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.n += uint64(n)
+	return n, err
+}
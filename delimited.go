@@ -0,0 +1,45 @@
+package cdbmap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+)
+
+// FromDelimited reads "key<sep>value" lines from r and writes them as cdb
+// records to w.  The key ends at the first sep on a line; the rest of the
+// line, minus its line ending, is the value.  Repeated keys are written as
+// multiple records, as cdbmake does.  A line without sep is an error.
+func FromDelimited(w io.WriteSeeker, r io.Reader, sep byte) error {
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	rb := bufio.NewReader(r)
+	for lineno := 1; ; lineno++ {
+		line, err := rb.ReadBytes('\n')
+		if err == io.EOF {
+			if len(line) == 0 {
+				break
+			}
+		} else if err != nil {
+			return err
+		}
+
+		line = bytes.TrimSuffix(line, []byte("\n"))
+		line = bytes.TrimSuffix(line, []byte("\r"))
+		i := bytes.IndexByte(line, sep)
+		if i < 0 {
+			return fmt.Errorf("line %d: missing separator %q", lineno, sep)
+		}
+
+		if err = cw.Put(line[:i], line[i+1:]); err != nil {
+			return err
+		}
+	}
+
+	return cw.Close()
+}
@@ -0,0 +1,104 @@
+package cdbmap
+
+import (
+	"io/ioutil"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFromDelimited(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	input := "one\t1\ntwo\t2\r\ntwo\t22\nthree\t3\t33"
+	if err = FromDelimited(tmp, strings.NewReader(input), '\t'); err != nil {
+		t.Fatalf("FromDelimited failed: %s", err)
+	}
+
+	m, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+
+	expected := map[string][]string{
+		"one":   {"1"},
+		"two":   {"2", "22"},
+		"three": {"3\t33"},
+	}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+
+	err = FromDelimited(tmp, strings.NewReader("a,1\nb\n"), ',')
+	if err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+}
+
+func TestCSV(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	input := "key\tvalue\none\t1\ntwo\t2\ntwo\t\"2\t2\"\n"
+	opts := CSVOptions{Comma: '\t', Header: true}
+	if err = MakeCSV(tmp, strings.NewReader(input), opts); err != nil {
+		t.Fatalf("MakeCSV failed: %s", err)
+	}
+
+	m, err := Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	expected := map[string][]string{"one": {"1"}, "two": {"2", "2\t2"}}
+	if !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v", expected, m)
+	}
+
+	var out strings.Builder
+	if err = DumpCSV(&out, tmp, opts); err != nil {
+		t.Fatalf("DumpCSV failed: %s", err)
+	}
+	if out.String() != input {
+		t.Fatalf("expected %q, got %q", input, out.String())
+	}
+
+	if err = MakeCSV(tmp, strings.NewReader("a,1\nb\n"), CSVOptions{}); err == nil || !strings.Contains(err.Error(), "line 2") {
+		t.Fatalf("expected error for line 2, got %v", err)
+	}
+}
+
+func TestStreamJSON(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	records := []Record{{[]byte("one"), []byte("1")}, {[]byte("two"), []byte("<2>")}, {[]byte("two"), []byte("\"2\"\n")}}
+	if err = WriteRecords(records, tmp); err != nil {
+		t.Fatalf("WriteRecords failed: %s", err)
+	}
+
+	var out strings.Builder
+	if err = StreamJSON(&out, tmp); err != nil {
+		t.Fatalf("StreamJSON failed: %s", err)
+	}
+	expected := `{"k":"one","v":"1"}
+{"k":"two","v":"<2>"}
+{"k":"two","v":"\"2\"\n"}
+`
+	if out.String() != expected {
+		t.Fatalf("expected %q, got %q", expected, out.String())
+	}
+}
@@ -0,0 +1,100 @@
+package cdbmap
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// FormatInfo describes what DetectFormat found at the start of a file.
+type FormatInfo struct {
+	// Format is the layout the header was decoded as.
+	Format Format
+
+	// Valid is set if the header is well formed for Format: the hash
+	// tables start after the header, follow each other without gaps and
+	// end within the file.
+	Valid bool
+
+	// BigEndian is set if the header is not valid as written but would be
+	// if its numbers were read as big-endian, as a cdb written by a naive
+	// port on a big-endian machine is.  Such a file cannot be read.
+	BigEndian bool
+
+	// Problem says why the header is not valid, if it is not.
+	Problem string
+}
+
+func (fi FormatInfo) String() string {
+	name := "cdb"
+	if fi.Format == Format64 {
+		name = "64-bit cdb"
+	}
+	switch {
+	case fi.Valid:
+		return name
+	case fi.BigEndian:
+		return "big-endian " + name
+	}
+	return "not a cdb: " + fi.Problem
+}
+
+// DetectFormat reads the header of the database in r and checks that it
+// is sane, reporting whether r looks like a standard cdb, a Format64 one,
+// or neither.  Only the header and the last byte of the hash tables are
+// read, so DetectFormat is cheap enough to run before opening any file.  A
+// file too short to hold a header is reported as ErrCorruptHeader; a
+// header that is merely not sane is described by the FormatInfo, not an
+// error.
+func DetectFormat(r io.ReaderAt) (FormatInfo, error) {
+	buf := make([]byte, Format64.headerSize())
+	n, err := r.ReadAt(buf, 0)
+	if n < int(HeaderSize) {
+		return FormatInfo{}, corrupt(ErrCorruptHeader, err)
+	}
+	buf = buf[:n]
+
+	f, t := detectFormat(buf)
+	fi := FormatInfo{Format: f}
+	if fi.Problem = checkTables(r, f, &t); fi.Problem == "" {
+		fi.Valid = true
+		return fi, nil
+	}
+
+	var be [256]table
+	for i := range be {
+		be[i].pos = uint64(binary.BigEndian.Uint32(buf[i*8:]))
+		be[i].nslots = uint64(binary.BigEndian.Uint32(buf[i*8+4:]))
+	}
+	fi.BigEndian = checkTables(r, Format32, &be) == ""
+
+	return fi, nil
+}
+
+// checkTables returns what is wrong with the hash tables in t, or "" if
+// they are laid out as a cdb writer lays them out and end within r.
+func checkTables(r io.ReaderAt, f Format, t *[256]table) string {
+	if t[0].pos < f.headerSize() {
+		return fmt.Sprintf("hash tables start at %d, inside the header", t[0].pos)
+	}
+	for i := range t {
+		if !f.tableFits(t[i], f.maxPos()) {
+			return fmt.Sprintf("hash table %d is impossibly large", i)
+		}
+	}
+	if !f.contiguous(t) {
+		return "hash tables overlap or have gaps between them"
+	}
+
+	end := tablesEnd(f, t)
+	if end < t[0].pos || end > f.maxPos() {
+		return "hash tables are impossibly large"
+	}
+	if end > f.headerSize() {
+		var b [1]byte
+		if _, err := r.ReadAt(b[:], int64(end-1)); err != nil {
+			return fmt.Sprintf("hash tables end at %d, past the end of the file", end)
+		}
+	}
+	return ""
+}
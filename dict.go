@@ -0,0 +1,116 @@
+package cdbmap
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"sync"
+)
+
+// DictionaryKey is the reserved key under which a database written with
+// WriterOptions.CompressValues stores the dictionary its values are
+// compressed with.
+const DictionaryKey = "\x00cdbmap:dictionary"
+
+// dictSize is the most data a dictionary holds, and the most sampled from
+// the values to train one: DEFLATE cannot refer back further than this.
+const dictSize = 32 << 10
+
+// Each compressed value starts with one of these, since values too small or
+// too unusual to shrink are better stored as they are.
+const (
+	valueStored   byte = 0
+	valueDeflated byte = 1
+)
+
+// trainDictionary builds a dictionary from sample values.  DEFLATE finds
+// matches anywhere in the dictionary, so the samples are simply
+// concatenated, keeping the last dictSize bytes, which are the cheapest to
+// refer back to.
+func trainDictionary(samples [][]byte) []byte {
+	var dict []byte
+	for _, s := range samples {
+		dict = append(dict, s...)
+	}
+	if len(dict) > dictSize {
+		dict = dict[len(dict)-dictSize:]
+	}
+	return dict
+}
+
+// valueCompressor compresses values with a dictionary.  It reuses one
+// flate.Writer, so it is not safe for concurrent use.
+type valueCompressor struct {
+	fw  *flate.Writer
+	buf bytes.Buffer
+}
+
+func newValueCompressor(dict []byte) *valueCompressor {
+	vc := new(valueCompressor)
+	vc.fw, _ = flate.NewWriterDict(&vc.buf, flate.BestCompression, dict) // only fails for a bad level
+	return vc
+}
+
+// compress appends value to dst, compressed if that makes it smaller.
+func (vc *valueCompressor) compress(dst, value []byte) []byte {
+	vc.buf.Reset()
+	vc.fw.Reset(&vc.buf)
+	vc.fw.Write(value) // writes to a bytes.Buffer cannot fail
+	vc.fw.Close()
+	if vc.buf.Len() < len(value) {
+		return append(append(dst, valueDeflated), vc.buf.Bytes()...)
+	}
+	return append(append(dst, valueStored), value...)
+}
+
+// valueDecompressor decompresses values compressed by a valueCompressor
+// with the same dictionary.  It is safe for concurrent use.
+type valueDecompressor struct {
+	dict    []byte
+	maxSize uint64 // see ReaderOptions.MaxRecordSize
+	readers sync.Pool
+}
+
+// parseDictionary returns a valueDecompressor for the value stored under
+// DictionaryKey.
+func parseDictionary(value []byte, maxSize uint64) (*valueDecompressor, error) {
+	if len(value) == 0 || value[0] != valueStored {
+		return nil, corruptf(ErrCorruptRecord, "bad compression dictionary")
+	}
+	return &valueDecompressor{dict: value[1:], maxSize: maxSize}, nil
+}
+
+func (vd *valueDecompressor) decompress(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, corruptf(ErrCorruptRecord, "compressed value is empty")
+	}
+	switch data[0] {
+	case valueStored:
+		return data[1:], nil
+	case valueDeflated:
+	default:
+		return nil, corruptf(ErrCorruptRecord, "unknown value compression %d", data[0])
+	}
+
+	br := bytes.NewReader(data[1:])
+	fr, _ := vd.readers.Get().(io.ReadCloser)
+	if fr == nil {
+		fr = flate.NewReaderDict(br, vd.dict)
+	} else {
+		fr.(flate.Resetter).Reset(br, vd.dict)
+	}
+	defer vd.readers.Put(fr)
+
+	var src io.Reader = fr
+	if vd.maxSize > 0 {
+		src = io.LimitReader(fr, int64(vd.maxSize)+1)
+	}
+	value, err := io.ReadAll(src)
+	if err != nil {
+		return nil, corruptf(ErrCorruptRecord, "bad compressed value: %v", err)
+	}
+	if vd.maxSize > 0 && uint64(len(value)) > vd.maxSize {
+		return nil, ErrRecordTooLarge
+	}
+	return value, nil
+}
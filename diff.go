@@ -0,0 +1,88 @@
+package cdbmap
+
+import (
+	"bytes"
+	"io"
+	"sort"
+)
+
+// Changes lists the keys that differ between two databases.  Each list is
+// sorted.
+type Changes struct {
+	Added   []string // keys only in the new database
+	Removed []string // keys only in the old database
+	Changed []string // keys in both whose values differ
+}
+
+// Empty reports whether the databases hold the same keys and values.
+func (c *Changes) Empty() bool {
+	return len(c.Added) == 0 && len(c.Removed) == 0 && len(c.Changed) == 0
+}
+
+// Diff compares the old cdb in a with the new one in b.  A key's values are
+// compared as a list, so reordering the values of a key counts as a change.
+// Keys are streamed from each database and looked up in the other, so
+// neither is decoded into a map.
+func Diff(a, b io.ReaderAt) (*Changes, error) {
+	ca, err := New(a)
+	if err != nil {
+		return nil, err
+	}
+	cb, err := New(b)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := &Changes{}
+	for key, err := range ca.Keys() {
+		if err != nil {
+			return nil, err
+		}
+		va, err := ca.GetAll(key)
+		if err != nil {
+			return nil, err
+		}
+		vb, err := cb.GetAll(key)
+		if err == ErrNotFound {
+			changes.Removed = append(changes.Removed, string(key))
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !equalValues(va, vb) {
+			changes.Changed = append(changes.Changed, string(key))
+		}
+	}
+
+	for key, err := range cb.Keys() {
+		if err != nil {
+			return nil, err
+		}
+		ok, err := ca.Exists(key)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			changes.Added = append(changes.Added, string(key))
+		}
+	}
+
+	sort.Strings(changes.Added)
+	sort.Strings(changes.Removed)
+	sort.Strings(changes.Changed)
+
+	return changes, nil
+}
+
+func equalValues(a, b [][]byte) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i], b[i]) {
+			return false
+		}
+	}
+	return true
+}
@@ -2,9 +2,12 @@ package cdbmap
 
 import (
 	"bufio"
-	"encoding/binary"
+	"bytes"
 	"fmt"
 	"io"
+	"regexp"
+	"sort"
+	"strconv"
 )
 
 // Dump reads the cdb-formatted data in r and dumps it as a series of formatted
@@ -18,38 +21,156 @@ func Dump(w io.Writer, r io.Reader) (err error) {
 		}
 	}()
 
-	rb := bufio.NewReader(r)
-	readNum := makeNumReader(rb)
+	rb := bufio.NewReaderSize(r, int(Format64.headerSize()))
 	rw := &recWriter{bufio.NewWriter(w)}
 
-	eod := readNum()
-	// Read rest of header.
-	for i := 0; i < 511; i++ {
-		readNum()
+	// Detect the format from as much of the header as is available.
+	header, err := rb.Peek(int(Format64.headerSize()))
+	if len(header) < int(HeaderSize) {
+		return corrupt(ErrCorruptHeader, err)
 	}
+	f, t := detectFormat(header)
+	if _, err = rb.Discard(int(f.headerSize())); err != nil {
+		return
+	}
+	readNum := makeNumReader(rb, f)
 
-	pos := HeaderSize
+	pos, eod, err := skipToData(rb, f, &t)
+	if err != nil {
+		return err
+	}
 	for pos < eod {
+		if eod == toEOF {
+			if _, err := rb.Peek(1); err == io.EOF {
+				break
+			}
+		}
 		klen, dlen := readNum(), readNum()
+		if rem := eod - pos - 2*uint64(f.numSize()); klen > rem || dlen > rem-klen {
+			return corruptf(ErrCorruptRecord, "record at %d runs past the data section", pos)
+		}
 		rw.writeString(fmt.Sprintf("+%d,%d:", klen, dlen))
 		rw.copyn(rb, klen)
 		rw.writeString("->")
 		rw.copyn(rb, dlen)
 		rw.writeString("\n")
-		pos += 8 + klen + dlen
+		pos += 2*uint64(f.numSize()) + klen + dlen
 	}
 	rw.writeString("\n")
 
 	return rw.Flush()
 }
 
-func makeNumReader(r io.Reader) func() uint32 {
-	buf := make([]byte, 4)
-	return func() uint32 {
-		if _, err := r.Read(buf); err != nil {
-			panic(err)
+// DumpOptions selects and orders the records written by DumpWithOptions.
+type DumpOptions struct {
+	// Prefix, if not empty, keeps only records whose keys begin with it.
+	Prefix string
+
+	// Match, if set, keeps only records whose keys it matches.
+	Match *regexp.Regexp
+
+	// Sort writes the records in key order, keeping the values of each
+	// key in the order they were written.  The selected records are held
+	// in memory to be sorted.
+	Sort bool
+
+	// Offset skips the first Offset selected records, and Limit, if not 0,
+	// stops after Limit more.
+	Offset, Limit int
+
+	// KeysOnly writes each selected key once, on a line of its own,
+	// instead of records.  Offset and Limit then count keys.
+	KeysOnly bool
+}
+
+// DumpWithOptions is like Dump, but writes only the records selected by
+// opts, in the order it gives.  Unless opts.KeysOnly is set, the output is
+// still suitable as input to Make.
+func DumpWithOptions(w io.Writer, r io.Reader, opts DumpOptions) error {
+	if opts == (DumpOptions{}) {
+		return Dump(w, r)
+	}
+
+	wb := bufio.NewWriter(w)
+	prefix := []byte(opts.Prefix)
+	skip, left := opts.Offset, opts.Limit
+	seen := make(map[string]bool) // keys written, with KeysOnly
+	var sorted []Record
+	var buf []byte
+
+	// emit writes one selected record, returning errStop once the limit
+	// is reached.
+	emit := func(key, value []byte) error {
+		if opts.KeysOnly {
+			if seen[string(key)] {
+				return nil
+			}
+			seen[string(key)] = true
+		}
+		if skip > 0 {
+			skip--
+			return nil
+		}
+
+		if opts.KeysOnly {
+			buf = append(append(buf[:0], key...), '\n')
+		} else {
+			buf = append(buf[:0], '+')
+			buf = strconv.AppendInt(buf, int64(len(key)), 10)
+			buf = append(buf, ',')
+			buf = strconv.AppendInt(buf, int64(len(value)), 10)
+			buf = append(append(append(buf, ':'), key...), "->"...)
+			buf = append(append(buf, value...), '\n')
+		}
+		if _, err := wb.Write(buf); err != nil {
+			return err
+		}
+
+		if left > 0 {
+			if left--; left == 0 {
+				return errStop
+			}
+		}
+		return nil
+	}
+
+	err := iterateStream(r, func(key, value []byte) error {
+		if !bytes.HasPrefix(key, prefix) || opts.Match != nil && !opts.Match.Match(key) {
+			return nil
+		}
+		if opts.Sort {
+			sorted = append(sorted, Record{bytes.Clone(key), bytes.Clone(value)})
+			return nil
+		}
+		return emit(key, value)
+	})
+	if opts.Sort && err == nil {
+		sort.SliceStable(sorted, func(i, j int) bool {
+			return bytes.Compare(sorted[i].Key, sorted[j].Key) < 0
+		})
+		for _, rec := range sorted {
+			if err = emit(rec.Key, rec.Value); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil && err != errStop {
+		return err
+	}
+
+	if !opts.KeysOnly {
+		wb.WriteString("\n")
+	}
+	return wb.Flush()
+}
+
+func makeNumReader(r io.Reader, f Format) func() uint64 {
+	buf := make([]byte, f.numSize())
+	return func() uint64 {
+		if _, err := io.ReadFull(r, buf); err != nil {
+			panic(corrupt(ErrCorruptRecord, err))
 		}
-		return binary.LittleEndian.Uint32(buf)
+		return f.getNum(buf)
 	}
 }
 
@@ -63,7 +184,7 @@ func (rw *recWriter) writeString(s string) {
 	}
 }
 
-func (rw *recWriter) copyn(r io.Reader, n uint32) {
+func (rw *recWriter) copyn(r io.Reader, n uint64) {
 	if _, err := io.CopyN(rw, r, int64(n)); err != nil {
 		panic(err)
 	}
@@ -0,0 +1,60 @@
+package cdbmap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+var (
+	// ErrNotFound is returned by lookups for a key that is not in the
+	// database.
+	ErrNotFound = errors.New("key not found")
+
+	// ErrCorruptHeader is returned when the header or hash tables of a
+	// database are damaged or truncated.
+	ErrCorruptHeader = errors.New("corrupt header")
+
+	// ErrCorruptRecord is returned when a record in the data section is
+	// damaged or truncated.
+	ErrCorruptRecord = errors.New("corrupt record")
+
+	// ErrTooLarge is returned when a database would exceed the limits of
+	// its format: 4 gigabytes for Format32.
+	ErrTooLarge = errors.New("database too large for format")
+
+	// ErrRecordTooLarge is returned when a record is larger than
+	// ReaderOptions.MaxRecordSize allows.
+	ErrRecordTooLarge = errors.New("record too large")
+
+	// ErrDuplicateKey is returned by a Builder using DuplicatesError when a
+	// key is put more than once.
+	ErrDuplicateKey = errors.New("duplicate key")
+
+	// ErrKeyTooLong, ErrValueTooLong and ErrInvalidKey are returned when a
+	// record fails the checks set in WriterOptions.
+	ErrKeyTooLong   = errors.New("key too long")
+	ErrValueTooLong = errors.New("value too long")
+	ErrInvalidKey   = errors.New("key is not valid UTF-8")
+
+	// ErrCompressed is returned by New and Open for a database written by
+	// WriteCompressed, whose values must be read with OpenCompressed or
+	// NewCompressed.
+	ErrCompressed = errors.New("database is compressed")
+)
+
+// corruptf returns an error wrapping kind, one of ErrCorruptHeader or
+// ErrCorruptRecord, that describes the damage.
+func corruptf(kind error, format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{kind}, args...)...)
+}
+
+// corrupt converts an error from reading part of a database.  Running out
+// of data means that part is truncated, which is reported as kind wrapping
+// io.ErrUnexpectedEOF; other errors are returned unchanged.
+func corrupt(kind, err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return fmt.Errorf("%w: %w", kind, io.ErrUnexpectedEOF)
+	}
+	return err
+}
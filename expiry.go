@@ -0,0 +1,21 @@
+package cdbmap
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// A database written with WriterOptions.Expiry stores a little-endian
+// expiry time, in Unix seconds, in the first expirySize bytes of each
+// value.  An expiry time of 0 means the record never expires.  If the
+// database also has checksums, they cover the expiry time as well as the
+// value.
+const expirySize = 8
+
+var errNoExpiry = errors.New("writer has no expiry times")
+
+// expired reports whether the expiry time at the start of data has passed.
+func (c *Reader) expired(data []byte) bool {
+	exp := int64(binary.LittleEndian.Uint64(data))
+	return exp != 0 && c.opts.Now().Unix() >= exp
+}
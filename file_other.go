@@ -0,0 +1,26 @@
+//go:build !windows
+
+package cdbmap
+
+import "os"
+
+// openFile opens the named database for reading.
+func openFile(filename string) (*os.File, error) {
+	return os.Open(filename)
+}
+
+// replaceFile renames from over to, which readers may have open.
+func replaceFile(from, to string) error {
+	return os.Rename(from, to)
+}
+
+// syncDir flushes the directory entry for a renamed file to disk.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+
+	return d.Sync()
+}
@@ -0,0 +1,65 @@
+package cdbmap
+
+import (
+	"errors"
+	"os"
+	"syscall"
+	"time"
+)
+
+// errorSharingViolation is ERROR_SHARING_VIOLATION, which the syscall
+// package does not define.
+const errorSharingViolation syscall.Errno = 32
+
+// replaceRetries and replaceBackoff bound how long replaceFile keeps
+// retrying a rename that another process is blocking.
+const (
+	replaceRetries = 10
+	replaceBackoff = 10 * time.Millisecond
+)
+
+// openFile opens the named database for reading.  Unlike os.Open, the file
+// is opened with FILE_SHARE_DELETE, so ToFile and friends can rename a new
+// database over it while it is open, as they can on other platforms.
+func openFile(filename string) (*os.File, error) {
+	name, err := syscall.UTF16PtrFromString(filename)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	h, err := syscall.CreateFile(name, syscall.GENERIC_READ,
+		syscall.FILE_SHARE_READ|syscall.FILE_SHARE_WRITE|syscall.FILE_SHARE_DELETE,
+		nil, syscall.OPEN_EXISTING, syscall.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: filename, Err: err}
+	}
+
+	return os.NewFile(uintptr(h), filename), nil
+}
+
+// replaceFile renames from over to.  os.Rename uses MoveFileEx with
+// MOVEFILE_REPLACE_EXISTING, which fails while another process has the
+// file open without FILE_SHARE_DELETE, as virus scanners and indexers
+// briefly do, so the rename is retried with increasing delays before giving up.
+func replaceFile(from, to string) (err error) {
+	delay := replaceBackoff
+	for i := 0; i < replaceRetries; i++ {
+		if err = os.Rename(from, to); err == nil || !isSharingError(err) {
+			return
+		}
+		time.Sleep(delay)
+		delay *= 2
+	}
+
+	return
+}
+
+func isSharingError(err error) bool {
+	return errors.Is(err, syscall.ERROR_ACCESS_DENIED) || errors.Is(err, errorSharingViolation)
+}
+
+// syncDir does nothing on Windows, where directories cannot be opened for
+// syncing and renames are flushed with the file system's metadata.
+func syncDir(dir string) error {
+	return nil
+}
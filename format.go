@@ -0,0 +1,209 @@
+package cdbmap
+
+import (
+	"encoding/binary"
+	"io"
+	"math"
+)
+
+// Format identifies the on-disk layout of a database.
+type Format int
+
+const (
+	// Format32 is the standard cdb layout, with 32-bit offsets and
+	// lengths.  A Format32 database is limited to 4 gigabytes.
+	Format32 Format = iota
+
+	// Format64 widens every header entry, record length and hash slot
+	// field to 64 bits, lifting the 4 gigabyte limit.  Keys are still
+	// hashed with the 32-bit cdb hash.  Format64 is not part of the cdb
+	// specification, so other cdb tools cannot read it.
+	Format64
+)
+
+// numSize returns the size in bytes of a number in f.
+func (f Format) numSize() int {
+	if f == Format64 {
+		return 8
+	}
+	return 4
+}
+
+// headerSize returns the size in bytes of the header in f.
+func (f Format) headerSize() uint64 {
+	return 256 * 2 * uint64(f.numSize())
+}
+
+func (f Format) getNum(buf []byte) uint64 {
+	if f == Format64 {
+		return binary.LittleEndian.Uint64(buf)
+	}
+	return uint64(binary.LittleEndian.Uint32(buf))
+}
+
+func (f Format) putNum(buf []byte, x uint64) {
+	if f == Format64 {
+		binary.LittleEndian.PutUint64(buf, x)
+	} else {
+		binary.LittleEndian.PutUint32(buf, uint32(x))
+	}
+}
+
+// maxPos returns the largest file offset a number in f can hold.
+func (f Format) maxPos() uint64 {
+	if f == Format64 {
+		return math.MaxUint64
+	}
+	return math.MaxUint32
+}
+
+// table is a hash table entry from the header.
+type table struct {
+	pos, nslots uint64
+}
+
+// tables decodes the 256 hash table entries from header.
+func (f Format) tables(header []byte) (t [256]table) {
+	n := f.numSize()
+	for i := range t {
+		t[i].pos = f.getNum(header[i*2*n:])
+		t[i].nslots = f.getNum(header[i*2*n+n:])
+	}
+	return
+}
+
+// tableFits reports whether table tab ends at or before limit, without
+// the end overflowing.
+func (f Format) tableFits(tab table, limit uint64) bool {
+	return tab.pos <= limit && tab.nslots <= (limit-tab.pos)/(2*uint64(f.numSize()))
+}
+
+// contiguous reports whether the tables in t follow the header and each
+// other without gaps, which is how every cdb writer lays them out.
+func (f Format) contiguous(t *[256]table) bool {
+	if t[0].pos < f.headerSize() {
+		return false
+	}
+	for i := range t {
+		if !f.tableFits(t[i], math.MaxUint64) {
+			return false
+		}
+	}
+	for i := 1; i < len(t); i++ {
+		if t[i].pos != t[i-1].pos+2*uint64(f.numSize())*t[i-1].nslots {
+			return false
+		}
+	}
+	return true
+}
+
+// indexFirst reports whether t describes the layout written with
+// WriterOptions.IndexFirst, in which the hash tables directly follow the
+// header and the records follow the hash tables.  The tables are known to
+// come first because they start at the end of the header and are not
+// empty: in the standard layout that would leave no room for the records
+// they point at.
+func (f Format) indexFirst(t *[256]table) bool {
+	return t[0].pos == f.headerSize() && tablesEnd(f, t) > f.headerSize()
+}
+
+// dataSection returns the start and end of the records of the database
+// in r with hash tables t.  In the standard layout they lie between the
+// header and the hash tables.  In the index-first layout they follow the
+// hash tables, and end with the record the hash tables point at last,
+// which is found by reading every slot.
+func dataSection(r io.ReaderAt, f Format, t *[256]table) (start, end uint64, err error) {
+	if !f.indexFirst(t) {
+		if t[0].pos < f.headerSize() {
+			return 0, 0, corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", t[0].pos)
+		}
+		return f.headerSize(), t[0].pos, nil
+	}
+
+	start = tablesEnd(f, t)
+	var last uint64
+	err = walkSlots(r, f, t, func(i int, j, _, pos uint64) error {
+		if pos != 0 && pos < start {
+			return corruptf(ErrCorruptHeader, "table %d slot %d: record pointer %d inside the hash tables", i, j, pos)
+		}
+		if pos > last {
+			last = pos
+		}
+		return nil
+	})
+	if err != nil || last == 0 {
+		return start, start, err
+	}
+
+	n := uint64(f.numSize())
+	buf := make([]byte, 2*n)
+	if _, err = r.ReadAt(buf, int64(last)); err != nil {
+		return 0, 0, corrupt(ErrCorruptRecord, err)
+	}
+	klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+	if rem := f.maxPos() - last; rem < 2*n || klen > rem-2*n || dlen > rem-2*n-klen {
+		return 0, 0, corruptf(ErrCorruptRecord, "record at %d runs past the largest position", last)
+	}
+	return start, last + 2*n + klen + dlen, nil
+}
+
+// detectFormat decodes the header at the start of buf, which must hold at
+// least HeaderSize bytes.  A header is taken to be Format64 only if it is
+// not a well-formed Format32 header and buf holds a well-formed Format64
+// header; anything else is treated as standard Format32.
+func detectFormat(buf []byte) (Format, [256]table) {
+	t := Format32.tables(buf)
+	if Format32.contiguous(&t) || uint64(len(buf)) < Format64.headerSize() {
+		return Format32, t
+	}
+	if t64 := Format64.tables(buf); Format64.contiguous(&t64) {
+		return Format64, t64
+	}
+	return Format32, t
+}
+
+// readHeader reads and decodes the header of the database in r.
+func readHeader(r io.ReaderAt) (Format, [256]table, error) {
+	buf := make([]byte, Format64.headerSize())
+	n, err := r.ReadAt(buf, 0)
+	if n < int(HeaderSize) {
+		return Format32, [256]table{}, corrupt(ErrCorruptHeader, err)
+	}
+
+	f, t := detectFormat(buf[:n])
+	return f, t, nil
+}
+
+// walkChunk is the number of slots walkSlots reads at a time.
+const walkChunk = 4096
+
+// walkSlots reads each hash table in t and calls fn with the table number,
+// slot number, hash and record position of every slot, including empty
+// ones, whose position is 0.  Tables are read walkChunk slots at a time, so
+// a corrupt slot count cannot force a huge allocation.
+func walkSlots(r io.ReaderAt, f Format, t *[256]table, fn func(table int, slot, h, pos uint64) error) error {
+	n := uint64(f.numSize())
+	buf := make([]byte, walkChunk*2*n)
+	for i, tab := range t {
+		for j := uint64(0); j < tab.nslots; {
+			m := tab.nslots - j
+			if m > walkChunk {
+				m = walkChunk
+			}
+			chunk := buf[:m*2*n]
+			if _, err := r.ReadAt(chunk, int64(tab.pos+j*2*n)); err != nil {
+				return corrupt(ErrCorruptHeader, err)
+			}
+
+			for ; m > 0; m-- {
+				if err := fn(i, j, f.getNum(chunk), f.getNum(chunk[n:])); err != nil {
+					return err
+				}
+				chunk = chunk[2*n:]
+				j++
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,37 @@
+package cdbmap
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+)
+
+// OpenFS opens the named cdb file from fsys for reading.  Files that
+// implement io.ReaderAt, such as those from os.DirFS and embed.FS, are read
+// in place; any other file is read into memory first.  The Reader should be
+// closed with Close when no longer needed.
+func OpenFS(fsys fs.FS, name string) (*Reader, error) {
+	f, err := fsys.Open(name)
+	if err != nil {
+		return nil, err
+	}
+
+	r, ok := f.(io.ReaderAt)
+	if !ok {
+		b, err := io.ReadAll(f)
+		if err != nil {
+			f.Close()
+			return nil, err
+		}
+		r = bytes.NewReader(b)
+	}
+
+	c, err := New(r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+
+	return c, nil
+}
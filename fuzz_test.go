@@ -0,0 +1,101 @@
+package cdbmap
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"testing"
+)
+
+// fuzzSeeds returns databases to start fuzzing from: the test records in
+// both formats, with and without checksums, an empty database, and a
+// Format64 database whose hash table size wraps.
+func fuzzSeeds(f *testing.F) [][]byte {
+	var seeds [][]byte
+	for _, opts := range []WriterOptions{{}, {Format: Format64}, {Checksum: true}} {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			f.Fatalf("Failed to create temp file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+
+		w, err := NewWriterWithOptions(tmp, opts)
+		if err != nil {
+			f.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		for _, rec := range records {
+			for _, value := range rec.values {
+				if err = w.Put([]byte(rec.key), []byte(value)); err != nil {
+					f.Fatalf("Put failed: %s", err)
+				}
+			}
+		}
+		if err = w.Close(); err != nil {
+			f.Fatalf("Close failed: %s", err)
+		}
+
+		b, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			f.Fatal(err)
+		}
+		seeds = append(seeds, b)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteStream(nil, buf); err != nil {
+		f.Fatalf("WriteStream failed: %s", err)
+	}
+	return append(seeds, buf.Bytes(), wrappedHeader(f))
+}
+
+func FuzzRead(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		f.Fatalf("Failed to create temp file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	f.Fuzz(func(t *testing.T, b []byte) {
+		Read(bytes.NewReader(b))
+		ReadParallel(bytes.NewReader(b), 3)
+		ReadRefs(bytes.NewReader(b))
+		ReadStream(bytes.NewReader(b))
+		Stats(bytes.NewReader(b))
+		Verify(bytes.NewReader(b))
+		DetectFormat(bytes.NewReader(b))
+		tmp.Seek(0, 0)
+		Recover(bytes.NewReader(b), tmp)
+	})
+}
+
+func FuzzDump(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed)
+	}
+	f.Fuzz(func(t *testing.T, b []byte) {
+		Dump(ioutil.Discard, bytes.NewReader(b))
+	})
+}
+
+func FuzzGet(f *testing.F) {
+	for _, seed := range fuzzSeeds(f) {
+		f.Add(seed, "one")
+	}
+	f.Fuzz(func(t *testing.T, b []byte, key string) {
+		c, err := New(bytes.NewReader(b))
+		if err != nil {
+			return
+		}
+		c.Get(key)
+		c.Len()
+		c.DataBytes()
+		for _, err := range c.Keys() {
+			if err != nil {
+				break
+			}
+		}
+	})
+}
@@ -0,0 +1,53 @@
+package cdbmap
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"go/token"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// GenerateGo writes Go source for package pkg to w declaring varName as a
+// map[string][]string holding m, so that a small dataset can be compiled
+// into a program instead of shipped as a file.  Keys are written in sorted
+// order, so the same map always produces the same source.
+func GenerateGo(w io.Writer, m map[string][]string, pkg, varName string) error {
+	if !token.IsIdentifier(pkg) {
+		return fmt.Errorf("invalid package name %q", pkg)
+	}
+	if !token.IsIdentifier(varName) {
+		return fmt.Errorf("invalid variable name %q", varName)
+	}
+
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var b bytes.Buffer
+	b.WriteString("// Code generated by cdbmap.GenerateGo; DO NOT EDIT.\n\n")
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+	fmt.Fprintf(&b, "var %s = map[string][]string{\n", varName)
+	for _, k := range keys {
+		fmt.Fprintf(&b, "%s: {", strconv.Quote(k))
+		for i, v := range m[k] {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(strconv.Quote(v))
+		}
+		b.WriteString("},\n")
+	}
+	b.WriteString("}\n")
+
+	src, err := format.Source(b.Bytes())
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(src)
+	return err
+}
@@ -1,6 +1,9 @@
 package cdbmap
 
-import "hash"
+import (
+	"hash"
+	"hash/fnv"
+)
 
 const (
 	start = 5381 // Initial cdb checksum value.
@@ -48,3 +51,15 @@ func (d *digest) Sum(in []byte) []byte {
 func (d *digest) BlockSize() int { return 1 }
 
 func checksum(data []byte) uint32 { return update(start, data) }
+
+// Hash returns the standard cdb hash of key.  It is the default for
+// WriterOptions.Hash and ReaderOptions.Hash.
+func Hash(key []byte) uint32 { return checksum(key) }
+
+// HashFNV1a returns the 32-bit FNV-1a hash of key, an alternative to Hash
+// for private database variants.
+func HashFNV1a(key []byte) uint32 {
+	h := fnv.New32a()
+	h.Write(key)
+	return h.Sum32()
+}
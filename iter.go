@@ -0,0 +1,65 @@
+package cdbmap
+
+import (
+	"errors"
+	"iter"
+)
+
+// errStop ends an iteration early when the consumer stops ranging.
+var errStop = errors.New("stop iteration")
+
+// Keys returns an iterator over the distinct keys in the database, in the
+// order each first appears.  Values are not read.  A key that has several
+// values is yielded once, when its first record is reached; telling
+// whether a record is a key's first takes a hash table lookup.  The key
+// slice is only valid until the next iteration.  If reading fails, the
+// iterator yields the error and stops.
+func (c *Reader) Keys() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		n := uint64(2 * c.format.numSize())
+		var key []byte
+		err := scanRecords(c.r, c.format, c.sod, c.eod, func(pos, klen, dlen uint64) error {
+			if err := checkRecordSize(pos, klen, c.opts.MaxRecordSize); err != nil {
+				return err
+			}
+			var err error
+			if key, err = readFullAt(c.r, key, pos+n, klen); err != nil {
+				return corrupt(ErrCorruptRecord, err)
+			}
+
+			first := false
+			err = c.lookup(key, nil, func(vpos, vlen uint64) (bool, error) {
+				first = vpos == pos+n+klen
+				return false, nil
+			})
+			if err != nil {
+				return err
+			}
+
+			if first && !yield(key, nil) {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			yield(nil, err)
+		}
+	}
+}
+
+// Values returns an iterator over every value in the database, in the
+// order they were written.  The value slice is only valid until the next
+// iteration.  If reading fails, the iterator yields the error and stops.
+func (c *Reader) Values() iter.Seq2[[]byte, error] {
+	return func(yield func([]byte, error) bool) {
+		err := c.Iterate(func(key, value []byte) error {
+			if !yield(value, nil) {
+				return errStop
+			}
+			return nil
+		})
+		if err != nil && err != errStop {
+			yield(nil, err)
+		}
+	}
+}
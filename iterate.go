@@ -0,0 +1,164 @@
+package cdbmap
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// Iterate walks the data section of the cdb in r sequentially, calling fn
+// for each record in the order the records were written.  The key and value
+// slices are only valid until fn returns.  If fn returns an error, Iterate
+// stops and returns that error.
+func Iterate(r io.ReaderAt, fn func(key, value []byte) error) error {
+	c, err := New(r)
+	if err != nil {
+		return err
+	}
+
+	return c.Iterate(fn)
+}
+
+// iterate walks the records from start, which must be the end of the
+// header or the start of a record, up to eod.  Records larger than max
+// bytes, if max is not 0, are rejected with ErrRecordTooLarge.
+func iterate(r io.ReaderAt, f Format, start, eod, max uint64, fn func(key, value []byte) error) error {
+	if eod < f.headerSize() {
+		return corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", eod)
+	}
+
+	rb := bufio.NewReader(io.NewSectionReader(r, int64(start), int64(eod-start)))
+	return readRecords(rb, f, start, eod, max, fn)
+}
+
+// toEOF is the end of the data section of an index-first database read as
+// a stream, whose records run to the end of the input.
+const toEOF = math.MaxUint64
+
+// readRecords reads records sequentially from rb, which must be positioned
+// at pos, up to eod, or the end of rb if eod is toEOF, rejecting records
+// larger than max as iterate does.
+func readRecords(rb io.Reader, f Format, pos, eod, max uint64, fn func(key, value []byte) error) error {
+	n := f.numSize()
+	buf := make([]byte, 2*n)
+	var rec []byte
+	for pos < eod {
+		if _, err := io.ReadFull(rb, buf); err != nil {
+			if err == io.EOF && eod == toEOF {
+				return nil
+			}
+			return corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+		if rem := eod - pos - uint64(2*n); klen > rem || dlen > rem-klen {
+			return corruptf(ErrCorruptRecord, "record at %d runs past the data section", pos)
+		}
+
+		if err := checkRecordSize(pos, klen+dlen, max); err != nil {
+			return err
+		}
+
+		var err error
+		if rec, err = readFull(rb, rec, klen+dlen); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+
+		if err := fn(rec[:klen], rec[klen:]); err != nil {
+			return err
+		}
+		pos += uint64(2*n) + klen + dlen
+	}
+
+	return nil
+}
+
+// scanRecords walks the record headers from start, the start of the data
+// section, up to eod, calling fn with the position and lengths of each
+// record.  Keys and values are skipped without being read into memory.
+func scanRecords(r io.ReaderAt, f Format, start, eod uint64, fn func(pos, klen, dlen uint64) error) error {
+	if eod < start {
+		return corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", eod)
+	}
+
+	rb := bufio.NewReader(io.NewSectionReader(r, int64(start), int64(eod-start)))
+	n := f.numSize()
+	buf := make([]byte, 2*n)
+	for pos := start; pos < eod; {
+		if _, err := io.ReadFull(rb, buf); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+		if rem := eod - pos - uint64(2*n); klen > rem || dlen > rem-klen {
+			return corruptf(ErrCorruptRecord, "record at %d runs past the data section", pos)
+		}
+
+		if _, err := io.CopyN(ioutil.Discard, rb, int64(klen+dlen)); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+
+		if err := fn(pos, klen, dlen); err != nil {
+			return err
+		}
+		pos += uint64(2*n) + klen + dlen
+	}
+
+	return nil
+}
+
+// readChunk is the most readFull allocates before any data has arrived.
+const readChunk = 1 << 20
+
+// readFull reads n bytes from r, reusing buf if it is big enough.  Lengths
+// come from the file and may be corrupt, so a large n is not allocated up
+// front: the buffer grows only as data arrives, and a bogus length fails
+// with io.ErrUnexpectedEOF at the end of the file instead of exhausting
+// memory.
+func readFull(r io.Reader, buf []byte, n uint64) ([]byte, error) {
+	if n <= uint64(cap(buf)) || n <= readChunk {
+		if uint64(cap(buf)) < n {
+			buf = make([]byte, n)
+		}
+		buf = buf[:n]
+		_, err := io.ReadFull(r, buf)
+		return buf, err
+	}
+	if n > math.MaxInt64 {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	b := bytes.NewBuffer(buf[:0])
+	m, err := b.ReadFrom(io.LimitReader(r, int64(n)))
+	if err == nil && uint64(m) < n {
+		err = io.ErrUnexpectedEOF
+	}
+	return b.Bytes(), err
+}
+
+// readFullAt is like readFull, but reads from r at pos.
+func readFullAt(r io.ReaderAt, buf []byte, pos, n uint64) ([]byte, error) {
+	if pos > math.MaxInt64 || n > math.MaxInt64 {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return readFull(io.NewSectionReader(r, int64(pos), int64(n)), buf, n)
+}
+
+// checkRecordSize returns ErrRecordTooLarge if the record at pos, whose key
+// and value take size bytes, exceeds max.  A max of 0 means no limit.
+func checkRecordSize(pos, size, max uint64) error {
+	if max != 0 && size > max {
+		return fmt.Errorf("%w: record at %d is %d bytes, limit is %d", ErrRecordTooLarge, pos, size, max)
+	}
+	return nil
+}
+
+// unexpected converts io.EOF to io.ErrUnexpectedEOF, for reads that
+// stopped short in the middle of a record.
+func unexpected(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,150 @@
+package cdbmap
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"strconv"
+)
+
+// AppendJournal appends records to a journal file kept beside a database,
+// for cheap updates between full rebuilds.  Readers of the database do not
+// see journaled records until Compact merges them in.  Records are stored
+// in the cdbmake format that Make and Dump use, one per line, without the
+// final empty line.
+type AppendJournal struct {
+	f   *os.File
+	buf []byte
+}
+
+// OpenJournal opens the named journal for appending, creating it if it
+// does not exist.
+func OpenJournal(filename string) (*AppendJournal, error) {
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	return &AppendJournal{f: f}, nil
+}
+
+// Put appends a record to the journal.  Each record is written with a
+// single write, but is not synced to disk until Sync or Close.
+func (j *AppendJournal) Put(key, value []byte) error {
+	b := append(j.buf[:0], '+')
+	b = strconv.AppendInt(b, int64(len(key)), 10)
+	b = append(b, ',')
+	b = strconv.AppendInt(b, int64(len(value)), 10)
+	b = append(b, ':')
+	b = append(b, key...)
+	b = append(b, "->"...)
+	b = append(b, value...)
+	b = append(b, '\n')
+	j.buf = b
+
+	_, err := j.f.Write(b)
+	return err
+}
+
+// Sync commits the journal to disk.
+func (j *AppendJournal) Sync() error {
+	return j.f.Sync()
+}
+
+// Close syncs and closes the journal.
+func (j *AppendJournal) Close() error {
+	if err := j.f.Sync(); err != nil {
+		j.f.Close()
+		return err
+	}
+	return j.f.Close()
+}
+
+// Compact merges the records in the named journal into the database named
+// base, whose values for a key are followed by those journaled for it,
+// and then empties the journal.  The new database replaces base
+// atomically, as ToFile does.  A missing base or journal is treated as
+// empty.  Nothing must append to the journal while Compact runs, or those
+// records may be lost.
+func Compact(base, journal string) error {
+	err := writeFile(base, func(f *os.File) error {
+		cw, err := NewWriter(f)
+		if err != nil {
+			return err
+		}
+		defer cw.Abort()
+
+		if b, err := openFile(base); err == nil {
+			err = Iterate(b, cw.Put)
+			b.Close()
+			if err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		if jf, err := os.Open(journal); err == nil {
+			err = readJournal(jf, cw.Put)
+			jf.Close()
+			if err != nil {
+				return err
+			}
+		} else if !os.IsNotExist(err) {
+			return err
+		}
+
+		return cw.Close()
+	})
+	if err != nil {
+		return err
+	}
+
+	if err = os.Truncate(journal, 0); os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
+
+// readJournal calls fn with each record in the journal in r.  A record cut
+// short at the end of the journal, as a crash in the middle of Put can
+// leave, is ignored.
+func readJournal(r io.Reader, fn func(key, value []byte) error) (err error) {
+	defer func() { // recReader panics on errors.
+		if e := recover(); e != nil {
+			err = e.(error)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+		}
+	}()
+
+	rr := &recReader{bufio.NewReader(r)}
+	var key, value bytes.Buffer
+	for {
+		c, err := rr.ReadByte()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if c != '+' {
+			return BadFormatError
+		}
+
+		klen, dlen := rr.readNum(','), rr.readNum(':')
+		key.Reset()
+		value.Reset()
+		rr.copyn(&key, klen)
+		rr.eatByte('-')
+		rr.eatByte('>')
+		rr.copyn(&value, dlen)
+		rr.eatByte('\n')
+
+		if err = fn(key.Bytes(), value.Bytes()); err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package cdbmap
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+)
+
+// DumpJSON writes the cdb in r to w as a JSON object mapping each key to
+// the array of its values, {"key": ["v1", "v2"]}, one key per line in the
+// order keys first appear in the database.  The output is suitable as input
+// to MakeJSON.  Keys and values are written as JSON strings, so bytes that
+// are not valid UTF-8 are replaced by U+FFFD.
+//
+// DumpJSON streams the database rather than loading it into a map: values
+// are gathered with a lookup for each key returned by Reader.Keys.
+func DumpJSON(w io.Writer, r io.ReaderAt) error {
+	c, err := New(r)
+	if err != nil {
+		return err
+	}
+
+	wb := bufio.NewWriter(w)
+	if _, err = wb.WriteString("{"); err != nil {
+		return err
+	}
+
+	first := true
+	for key, err := range c.Keys() {
+		if err != nil {
+			return err
+		}
+
+		values, err := c.Get(string(key))
+		if err != nil {
+			return err
+		}
+
+		k, err := json.Marshal(string(key))
+		if err != nil {
+			return err
+		}
+		v, err := json.Marshal(values)
+		if err != nil {
+			return err
+		}
+
+		if !first {
+			wb.WriteString(",")
+		}
+		first = false
+		wb.WriteString("\n")
+		wb.Write(k)
+		wb.WriteString(":")
+		if _, err = wb.Write(v); err != nil {
+			return err
+		}
+	}
+
+	wb.WriteString("\n}\n")
+	return wb.Flush()
+}
+
+// jsonRecord is a line of StreamJSON output.
+type jsonRecord struct {
+	Key   string `json:"k"`
+	Value string `json:"v"`
+}
+
+// StreamJSON writes the cdb in r to w as newline-delimited JSON, one
+// object per record, {"k":"key","v":"value"}, in the order the records
+// were written.  Only one record is held in memory at a time, so databases
+// of any size can be exported.  As with DumpJSON, bytes that are not valid
+// UTF-8 are replaced by U+FFFD.
+func StreamJSON(w io.Writer, r io.ReaderAt) error {
+	wb := bufio.NewWriter(w)
+	enc := json.NewEncoder(wb)
+	enc.SetEscapeHTML(false)
+	err := Iterate(r, func(key, value []byte) error {
+		return enc.Encode(jsonRecord{string(key), string(value)})
+	})
+	if err != nil {
+		return err
+	}
+
+	return wb.Flush()
+}
+
+// MakeJSON reads a JSON object mapping keys to arrays of string values, as
+// written by DumpJSON, from r and writes a cdb to w.  Records are written
+// in the order they appear in the input.
+func MakeJSON(w io.WriteSeeker, r io.Reader) error {
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	dec := json.NewDecoder(r)
+	if err = expectDelim(dec, '{'); err != nil {
+		return err
+	}
+	for dec.More() {
+		t, err := dec.Token()
+		if err != nil {
+			return err
+		}
+		key := []byte(t.(string))
+
+		if err = expectDelim(dec, '['); err != nil {
+			return err
+		}
+		for dec.More() {
+			t, err := dec.Token()
+			if err != nil {
+				return err
+			}
+			value, ok := t.(string)
+			if !ok {
+				return errors.New("json: values must be strings")
+			}
+			if err = cw.Put(key, []byte(value)); err != nil {
+				return err
+			}
+		}
+		if err = expectDelim(dec, ']'); err != nil {
+			return err
+		}
+	}
+	if err = expectDelim(dec, '}'); err != nil {
+		return err
+	}
+
+	return cw.Close()
+}
+
+func expectDelim(dec *json.Decoder, delim json.Delim) error {
+	t, err := dec.Token()
+	if err != nil {
+		return unexpected(err)
+	}
+	if t != delim {
+		return errors.New("json: expected " + delim.String())
+	}
+	return nil
+}
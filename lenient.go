@@ -0,0 +1,53 @@
+package cdbmap
+
+import (
+	"bytes"
+	"io"
+)
+
+// dataEnd returns the end of the last record in the database, found from
+// the hash tables, for a Reader created with ReaderOptions.Lenient.  If the
+// bytes from there to the hash tables are all zero they are padding, added
+// by writers that align sections, and the data section is taken to end
+// before them.  Otherwise the data section ends at the hash tables as
+// usual.
+func dataEnd(r io.ReaderAt, f Format, t *[256]table) (uint64, error) {
+	eod := t[0].pos
+	if eod < f.headerSize() {
+		return 0, corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", eod)
+	}
+
+	var last uint64
+	err := walkSlots(r, f, t, func(_ int, _, _, pos uint64) error {
+		if pos > last && pos < eod {
+			last = pos
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	end := f.headerSize()
+	if last != 0 {
+		n := uint64(f.numSize())
+		buf := make([]byte, 2*n)
+		if _, err := r.ReadAt(buf, int64(last)); err != nil {
+			return 0, corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+		if rem := eod - last; rem < 2*n || klen > rem-2*n || dlen > rem-2*n-klen {
+			return eod, nil
+		}
+		end = last + 2*n + klen + dlen
+	}
+
+	pad := make([]byte, eod-end)
+	if _, err := r.ReadAt(pad, int64(end)); err != nil {
+		return 0, corrupt(ErrCorruptRecord, err)
+	}
+	if len(bytes.Trim(pad, "\x00")) != 0 {
+		return eod, nil
+	}
+	return end, nil
+}
@@ -0,0 +1,49 @@
+package cdbmap
+
+import "os"
+
+// FileLock is an advisory lock on a database, held on a separate file
+// named filename+".lock" so that it survives the database being replaced
+// by rename.  Writers that take Lock before rebuilding a database and
+// readers that take RLock before opening it never see each other mid-way;
+// processes that do not lock are not stopped.
+type FileLock struct {
+	f *os.File
+}
+
+// Lock takes an exclusive lock on the named database, waiting until no
+// other process holds a lock on it.
+func Lock(filename string) (*FileLock, error) {
+	return lock(filename, true)
+}
+
+// RLock takes a shared lock on the named database, waiting until no other
+// process holds an exclusive lock on it.  Any number of processes may hold
+// shared locks at once.
+func RLock(filename string) (*FileLock, error) {
+	return lock(filename, false)
+}
+
+func lock(filename string, exclusive bool) (*FileLock, error) {
+	f, err := os.OpenFile(filename+".lock", os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err = lockFile(f, exclusive); err != nil {
+		f.Close()
+		return nil, &os.PathError{Op: "lock", Path: f.Name(), Err: err}
+	}
+
+	return &FileLock{f}, nil
+}
+
+// Unlock releases the lock.  The lock file is left in place, since
+// removing it would race with processes waiting on it.
+func (l *FileLock) Unlock() error {
+	err := unlockFile(l.f)
+	if cerr := l.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
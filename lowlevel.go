@@ -0,0 +1,160 @@
+package cdbmap
+
+import (
+	"io"
+	"math"
+)
+
+// The types and functions below expose the binary layout of a cdb for tools
+// that need more than lookups, such as analyzers and repairers.
+//
+// A database starts with a header of 256 entries, each the position and
+// slot count of one hash table.  The records follow, each a key length,
+// a data length, the key and the data.  The 256 hash tables come last; a
+// slot holds a key's hash and the position of its record, or a position of
+// 0 if it is empty.  A key with hash h is found in table h%256 by probing
+// from slot (h/256)%nslots.  A database written with
+// WriterOptions.IndexFirst puts the hash tables before the records.  All
+// numbers are little-endian, 32 bits wide in
+// Format32 and 64 bits wide in Format64.
+
+// Header is the decoded header of a database.
+type Header struct {
+	Format Format
+	Tables [256]HashTable
+}
+
+// HashTable locates one of the 256 hash tables.
+type HashTable struct {
+	Pos    uint64 // position of the first slot
+	NSlots uint64 // number of slots
+}
+
+// SlotRef is one slot of a hash table.
+type SlotRef struct {
+	Hash uint32
+	Pos  uint64 // position of the record, or 0 if the slot is empty
+}
+
+// Size returns the size of the header in bytes, which is also the position
+// of the first record.
+func (h *Header) Size() uint64 {
+	return h.Format.headerSize()
+}
+
+// DataEnd returns the position just past the last record, where the first
+// hash table starts.  In the IndexFirst layout the records end where the
+// hash tables say, which the header alone does not tell, so DataEnd
+// returns math.MaxUint64; DataSection finds the actual end.
+func (h *Header) DataEnd() uint64 {
+	if h.IndexFirst() {
+		return math.MaxUint64
+	}
+	return h.Tables[0].Pos
+}
+
+// DataSection returns the start and end of the records of the database in
+// r, whose header is h.  In the standard layout these are Size and
+// DataEnd; in the IndexFirst layout the records start after the hash
+// tables, and every slot is read to find where they end.
+func (h *Header) DataSection(r io.ReaderAt) (start, end uint64, err error) {
+	t := h.tables()
+	return dataSection(r, h.Format, &t)
+}
+
+// IndexFirst reports whether the database has the layout written with
+// WriterOptions.IndexFirst, in which the records follow the hash tables
+// instead, so that Size and DataEnd do not bound them.
+func (h *Header) IndexFirst() bool {
+	t := h.tables()
+	return h.Format.indexFirst(&t)
+}
+
+func (h *Header) tables() (t [256]table) {
+	for i, tab := range h.Tables {
+		t[i] = table{tab.Pos, tab.NSlots}
+	}
+	return
+}
+
+// ParseHeader decodes the header at the start of buf and detects its
+// format.  buf must hold at least HeaderSize bytes, and must hold the
+// 4096 bytes of a Format64 header for that format to be detected.
+func ParseHeader(buf []byte) (*Header, error) {
+	if len(buf) < int(HeaderSize) {
+		return nil, corrupt(ErrCorruptHeader, io.ErrUnexpectedEOF)
+	}
+
+	f, t := detectFormat(buf)
+	return newHeader(f, &t), nil
+}
+
+// ReadHeader reads and decodes the header of the database in r.
+func ReadHeader(r io.ReaderAt) (*Header, error) {
+	f, t, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return newHeader(f, &t), nil
+}
+
+func newHeader(f Format, t *[256]table) *Header {
+	h := &Header{Format: f}
+	for i, tab := range t {
+		h.Tables[i] = HashTable{tab.pos, tab.nslots}
+	}
+	return h
+}
+
+// ReadTable reads every slot of hash table i of the database in r, whose
+// header is h.
+func ReadTable(r io.ReaderAt, h *Header, i int) ([]SlotRef, error) {
+	var t [256]table
+	t[i] = table{h.Tables[i].Pos, h.Tables[i].NSlots}
+
+	var slots []SlotRef
+	err := walkSlots(r, h.Format, &t, func(_ int, _, hash, pos uint64) error {
+		slots = append(slots, SlotRef{uint32(hash), pos})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return slots, nil
+}
+
+// WalkTables calls fn for every slot of the hash tables, in table and
+// slot order, with the hash and record position the slot holds.  Empty
+// slots are included, with a position of 0.  A key with hash h is first
+// probed at slot (h/256)%n of its table of n slots, so the distance from
+// there to where the slot actually is gives the key's probe length.  If fn
+// returns an error, WalkTables stops and returns that error.
+func (c *Reader) WalkTables(fn func(table, slot int, hash uint32, pos uint64) error) error {
+	return walkSlots(c.r, c.format, &c.tables, func(table int, slot, hash, pos uint64) error {
+		return fn(table, int(slot), uint32(hash), pos)
+	})
+}
+
+// ReadRecord reads the record at pos in a database of format f, returning
+// its key and data and the position of the record after it.  The data
+// includes any checksum or expiry time the database stores with values.
+func ReadRecord(r io.ReaderAt, f Format, pos uint64) (key, data []byte, next uint64, err error) {
+	n := uint64(f.numSize())
+	buf := make([]byte, 2*n)
+	if _, err = r.ReadAt(buf, int64(pos)); err != nil {
+		return nil, nil, 0, corrupt(ErrCorruptRecord, err)
+	}
+	klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+
+	if klen+dlen < klen {
+		return nil, nil, 0, corruptf(ErrCorruptRecord, "record at %d has impossible lengths", pos)
+	}
+	rec, err := readFullAt(r, nil, pos+2*n, klen+dlen)
+	if err != nil {
+		return nil, nil, 0, corrupt(ErrCorruptRecord, err)
+	}
+
+	return rec[:klen], rec[klen:], pos + 2*n + klen + dlen, nil
+}
@@ -4,18 +4,23 @@ import (
 	"bufio"
 	"encoding/binary"
 	"errors"
+	"fmt"
 	"io"
 	"strconv"
 )
 
+// BadFormatError is returned when input to Make, or a sidecar file, is not
+// in the expected format.
 var BadFormatError = errors.New("bad format")
 
 // Make reads cdb-formatted records from r and writes a cdb-format database
 // to w.  See the documentation for Dump for details on the input record format. 
+// It returns ErrTooLarge if the database would exceed 4 gigabytes, and an
+// error wrapping BadFormatError if the input is malformed or truncated.
 func Make(w io.WriteSeeker, r io.Reader) (err error) {
 	defer func() { // Centralize error handling.
 		if e := recover(); e != nil {
-			err = e.(error)
+			err = inputError(e.(error))
 		}
 	}()
 
@@ -30,7 +35,7 @@ func Make(w io.WriteSeeker, r io.Reader) (err error) {
 	hw := io.MultiWriter(hash, wb) // Computes hash when writing record key.
 	rr := &recReader{rb}
 	htables := make(map[uint32][]slot)
-	pos := HeaderSize
+	pos := uint64(HeaderSize)
 	// Read all records and write to output.
 	for {
 		// Record format is "+klen,dlen:key->data\n"
@@ -42,6 +47,9 @@ func Make(w io.WriteSeeker, r io.Reader) (err error) {
 			return BadFormatError
 		}
 		klen, dlen := rr.readNum(','), rr.readNum(':')
+		if size := 8 + uint64(klen) + uint64(dlen); size > Format32.maxPos()-pos {
+			return ErrTooLarge
+		}
 		writeNums(wb, klen, dlen, buf)
 		hash.Reset()
 		rr.copyn(hw, klen)
@@ -52,11 +60,39 @@ func Make(w io.WriteSeeker, r io.Reader) (err error) {
 		h := hash.Sum32()
 		tableNum := h % 256
 		htables[tableNum] = append(htables[tableNum], slot{h, pos})
-		pos += 8 + klen + dlen
+		pos += 8 + uint64(klen) + uint64(dlen)
+	}
+
+	_, err = writeTables(w, wb, Format32, htables, pos)
+	return
+}
+
+// writeTables writes the hash tables for htables at pos in format f,
+// flushes wb, and then seeks back to the start of w to write the header,
+// which it returns.
+func writeTables(w io.WriteSeeker, wb *bufio.Writer, f Format, htables map[uint32][]slot, pos uint64) (header []byte, err error) {
+	header, err = buildTables(wb, f, htables, pos)
+	if err != nil {
+		return
+	}
+
+	if err = wb.Flush(); err != nil {
+		return
+	}
+
+	if _, err = w.Seek(0, 0); err != nil {
+		return
 	}
 
-	// Write hash tables and header.
+	_, err = w.Write(header)
+
+	return
+}
 
+// buildTables writes the hash tables for htables, which start at pos, to w
+// and returns the header that points to them.  It returns ErrTooLarge if the
+// tables would end past the largest position f can hold.
+func buildTables(w io.Writer, f Format, htables map[uint32][]slot, pos uint64) (header []byte, err error) {
 	// Create and reuse a single hash table.
 	maxSlots := 0
 	for _, slots := range htables {
@@ -66,16 +102,22 @@ func Make(w io.WriteSeeker, r io.Reader) (err error) {
 	}
 	slotTable := make([]slot, maxSlots*2)
 
-	header := make([]byte, HeaderSize)
+	n := f.numSize()
+	buf := make([]byte, 2*n)
+	header = make([]byte, f.headerSize())
 	// Write hash tables.
-	for i := uint32(0); i < 256; i++ {
-		slots := htables[i]
+	for i := 0; i < 256; i++ {
+		entry := header[i*2*n:]
+		slots := htables[uint32(i)]
 		if slots == nil {
-			putNum(header[i*8:], pos)
+			f.putNum(entry, pos)
 			continue
 		}
 
-		nslots := uint32(len(slots) * 2)
+		nslots := uint64(len(slots) * 2)
+		if pos > f.maxPos() || uint64(2*n)*nslots > f.maxPos()-pos {
+			return nil, ErrTooLarge
+		}
 		hashSlotTable := slotTable[:nslots]
 		// Reset table slots.
 		for j := 0; j < len(hashSlotTable); j++ {
@@ -84,36 +126,39 @@ func Make(w io.WriteSeeker, r io.Reader) (err error) {
 		}
 
 		for _, slot := range slots {
-			slotPos := (slot.h / 256) % nslots
+			slotPos := uint64(slot.h/256) % nslots
 			for hashSlotTable[slotPos].pos != 0 {
 				slotPos++
-				if slotPos == uint32(len(hashSlotTable)) {
+				if slotPos == nslots {
 					slotPos = 0
 				}
 			}
 			hashSlotTable[slotPos] = slot
 		}
 
-		if err = writeSlots(wb, hashSlotTable, buf); err != nil {
+		if err = writeSlots(w, f, hashSlotTable, buf); err != nil {
 			return
 		}
 
-		putNum(header[i*8:], pos)
-		putNum(header[i*8+4:], nslots)
-		pos += 8 * nslots
+		f.putNum(entry, pos)
+		f.putNum(entry[n:], nslots)
+		pos += uint64(2*n) * nslots
 	}
 
-	if err = wb.Flush(); err != nil {
-		return
-	}
+	return
+}
 
-	if _, err = w.Seek(0, 0); err != nil {
-		return
+// inputError converts an error from a recReader to one wrapping
+// BadFormatError if it came from malformed or truncated input.
+func inputError(err error) error {
+	var numErr *strconv.NumError
+	switch {
+	case err == io.EOF || err == io.ErrUnexpectedEOF:
+		return fmt.Errorf("%w: truncated input", BadFormatError)
+	case errors.As(err, &numErr):
+		return fmt.Errorf("%w: %w", BadFormatError, err)
 	}
-
-	_, err = w.Write(header)
-
-	return
+	return err
 }
 
 type recReader struct {
@@ -131,7 +176,7 @@ func (rr *recReader) readByte() byte {
 
 func (rr *recReader) eatByte(c byte) {
 	if rr.readByte() != c {
-		panic(errors.New("unexpected character"))
+		panic(fmt.Errorf("%w: unexpected character", BadFormatError))
 	}
 }
 
@@ -169,14 +214,16 @@ func writeNums(w io.Writer, x, y uint32, buf []byte) {
 }
 
 type slot struct {
-	h, pos uint32
+	h   uint32
+	pos uint64
 }
 
-func writeSlots(w io.Writer, slots []slot, buf []byte) (err error) {
+func writeSlots(w io.Writer, f Format, slots []slot, buf []byte) (err error) {
+	n := f.numSize()
 	for _, np := range slots {
-		putNum(buf, np.h)
-		putNum(buf[4:], np.pos)
-		if _, err = w.Write(buf[:8]); err != nil {
+		f.putNum(buf, uint64(np.h))
+		f.putNum(buf[n:], np.pos)
+		if _, err = w.Write(buf[:2*n]); err != nil {
 			return
 		}
 	}
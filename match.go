@@ -0,0 +1,56 @@
+package cdbmap
+
+import "io"
+
+// Match selects which values of a key with several are returned.  A key's
+// values are always kept in the order they were written, however they are
+// interleaved with other records, as djb's cdb does: the hash tables list
+// them in that order, and lookups probe the tables in order.
+type Match int
+
+const (
+	// FirstMatch returns only the first value written for a key, as
+	// djb's cdbget and cdb_find do.
+	FirstMatch Match = iota
+
+	// AllMatches returns every value written for a key, in the order
+	// written.
+	AllMatches
+)
+
+// Lookup returns the values of key selected by m: a single value for
+// FirstMatch, as GetFirst returns, or every value for AllMatches, as
+// GetAll returns.  It returns ErrNotFound if the key does not exist.
+func (c *Reader) Lookup(key []byte, m Match) ([][]byte, error) {
+	if m == AllMatches {
+		return c.GetAll(key)
+	}
+
+	v, err := c.GetFirst(key)
+	if err != nil {
+		return nil, err
+	}
+	return [][]byte{v}, nil
+}
+
+// ReadMatches is like Read, but keeps only the values of each key selected
+// by m.  With FirstMatch every key maps to a single value, the one a lookup
+// would return.
+func ReadMatches(r io.ReaderAt, m Match) (map[string][]string, error) {
+	if m == AllMatches {
+		return Read(r)
+	}
+
+	res := make(map[string][]string)
+	err := Iterate(r, func(key, value []byte) error {
+		if _, ok := res[string(key)]; !ok {
+			res[string(key)] = []string{string(value)}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return res, nil
+}
@@ -0,0 +1,67 @@
+package cdbmap
+
+import "io"
+
+// MergePolicy decides which values Merge keeps for a key found in more than
+// one database.
+type MergePolicy int
+
+const (
+	// MergeAppend keeps the values from every database, in argument order.
+	MergeAppend MergePolicy = iota
+
+	// MergeFirstWins keeps only the values from the first database that
+	// has the key.
+	MergeFirstWins
+
+	// MergeLastWins keeps only the values from the last database that has
+	// the key.
+	MergeLastWins
+)
+
+// Merge writes a database to w combining the records of every database in
+// readers, resolving keys present in more than one of them by policy.
+// Records are streamed from each database in turn; conflicts are found by
+// looking keys up in the other databases rather than decoding them into a
+// map.
+func Merge(w io.WriteSeeker, policy MergePolicy, readers ...io.ReaderAt) error {
+	dbs := make([]*Reader, len(readers))
+	for i, r := range readers {
+		c, err := New(r)
+		if err != nil {
+			return err
+		}
+		dbs[i] = c
+	}
+
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	for i, c := range dbs {
+		// Databases whose values take precedence over this one's.
+		var shadows []*Reader
+		switch policy {
+		case MergeFirstWins:
+			shadows = dbs[:i]
+		case MergeLastWins:
+			shadows = dbs[i+1:]
+		}
+
+		err := c.Iterate(func(key, value []byte) error {
+			for _, s := range shadows {
+				if ok, err := s.Exists(key); ok || err != nil {
+					return err
+				}
+			}
+			return cw.Put(key, value)
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return cw.Close()
+}
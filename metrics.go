@@ -0,0 +1,88 @@
+package cdbmap
+
+import (
+	"expvar"
+	"time"
+)
+
+// LookupStats describes one key lookup, as reported to Metrics.
+type LookupStats struct {
+	Found     bool          // whether the key was present
+	Probes    int           // number of hash table slots probed
+	BytesRead int           // bytes read from the database, slots included
+	Duration  time.Duration // time taken by the lookup
+}
+
+// Metrics receives a report of every lookup made by a Reader created with
+// ReaderOptions.Metrics: Get, GetFirst, GetAt, GetAll, Exists and Count.
+// Lookup is called by the goroutine that made the lookup, after it
+// finishes, so it must be safe for concurrent use and should be quick.
+type Metrics interface {
+	Lookup(s LookupStats)
+}
+
+// latencyBuckets are the upper bounds of the ExpvarMetrics latency
+// histogram.  Slower lookups are counted under "inf".
+var latencyBuckets = []struct {
+	max  time.Duration
+	name string
+}{
+	{time.Microsecond, "1us"},
+	{10 * time.Microsecond, "10us"},
+	{100 * time.Microsecond, "100us"},
+	{time.Millisecond, "1ms"},
+	{10 * time.Millisecond, "10ms"},
+	{100 * time.Millisecond, "100ms"},
+}
+
+// ExpvarMetrics is a Metrics that publishes lookup counts with the expvar
+// package, as a map holding "lookups", "hits", "misses", "probes" and
+// "bytes_read" counters and a "latency" map counting lookups by the
+// smallest of 1us, 10us, 100us, 1ms, 10ms, 100ms or inf that their duration
+// does not exceed.
+type ExpvarMetrics struct {
+	lookups, hits, misses, probes, bytesRead expvar.Int
+	latency                                  expvar.Map
+}
+
+// NewExpvarMetrics returns an ExpvarMetrics published under name.  Like
+// expvar.Publish, it panics if name is already in use.
+func NewExpvarMetrics(name string) *ExpvarMetrics {
+	m := &ExpvarMetrics{}
+	m.latency.Init()
+	for _, b := range latencyBuckets {
+		m.latency.Set(b.name, new(expvar.Int))
+	}
+	m.latency.Set("inf", new(expvar.Int))
+
+	vars := expvar.NewMap(name)
+	vars.Set("lookups", &m.lookups)
+	vars.Set("hits", &m.hits)
+	vars.Set("misses", &m.misses)
+	vars.Set("probes", &m.probes)
+	vars.Set("bytes_read", &m.bytesRead)
+	vars.Set("latency", &m.latency)
+
+	return m
+}
+
+// Lookup records s.
+func (m *ExpvarMetrics) Lookup(s LookupStats) {
+	m.lookups.Add(1)
+	if s.Found {
+		m.hits.Add(1)
+	} else {
+		m.misses.Add(1)
+	}
+	m.probes.Add(int64(s.Probes))
+	m.bytesRead.Add(int64(s.BytesRead))
+
+	bucket := "inf"
+	for _, b := range latencyBuckets {
+		if s.Duration <= b.max {
+			bucket = b.name
+			break
+		}
+	}
+	m.latency.Add(bucket, 1)
+}
@@ -0,0 +1,67 @@
+//go:build prometheus
+
+// Build with -tags prometheus to include PrometheusMetrics, which needs the
+// Prometheus client library.
+
+package cdbmap
+
+import "github.com/prometheus/client_golang/prometheus"
+
+// PrometheusMetrics is a Metrics that reports lookups as Prometheus
+// metrics: a counter of lookups labelled by result, "hit" or "miss", a
+// counter of bytes read and histograms of probes and latency.
+type PrometheusMetrics struct {
+	lookups   *prometheus.CounterVec
+	bytesRead prometheus.Counter
+	probes    prometheus.Histogram
+	latency   prometheus.Histogram
+}
+
+// NewPrometheusMetrics returns a PrometheusMetrics whose metrics are named
+// with namespace and registered with reg.
+func NewPrometheusMetrics(reg prometheus.Registerer, namespace string) (*PrometheusMetrics, error) {
+	m := &PrometheusMetrics{
+		lookups: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cdb_lookups_total",
+			Help:      "Number of cdb key lookups, by result.",
+		}, []string{"result"}),
+		bytesRead: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: namespace,
+			Name:      "cdb_lookup_read_bytes_total",
+			Help:      "Bytes read from cdb files by lookups.",
+		}),
+		probes: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cdb_lookup_probes",
+			Help:      "Hash table slots read per cdb lookup.",
+			Buckets:   []float64{1, 2, 3, 4, 6, 8, 12, 16},
+		}),
+		latency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Namespace: namespace,
+			Name:      "cdb_lookup_duration_seconds",
+			Help:      "Duration of cdb lookups.",
+			Buckets:   prometheus.ExponentialBuckets(1e-6, 10, 6),
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{m.lookups, m.bytesRead, m.probes, m.latency} {
+		if err := reg.Register(c); err != nil {
+			return nil, err
+		}
+	}
+
+	return m, nil
+}
+
+// Lookup records s.
+func (m *PrometheusMetrics) Lookup(s LookupStats) {
+	result := "miss"
+	if s.Found {
+		result = "hit"
+	}
+	m.lookups.WithLabelValues(result).Inc()
+	m.bytesRead.Add(float64(s.BytesRead))
+	m.probes.Observe(float64(s.Probes))
+	m.latency.Observe(s.Duration.Seconds())
+}
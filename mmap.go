@@ -0,0 +1,34 @@
+package cdbmap
+
+import "bytes"
+
+// OpenMmap opens the named cdb file and memory-maps it, so lookups are
+// served from the mapping instead of a system call per read.  On platforms
+// without mmap the file is read into memory instead.  The mapping is
+// released by Close.
+func OpenMmap(filename string) (*Reader, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, unmap, err := mmapFile(f)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := New(bytes.NewReader(data))
+	if err != nil {
+		unmap()
+		return nil, err
+	}
+	c.closer = closerFunc(unmap)
+	c.mapped = data
+
+	return c, nil
+}
+
+type closerFunc func() error
+
+func (f closerFunc) Close() error { return f() }
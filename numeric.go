@@ -0,0 +1,78 @@
+package cdbmap
+
+import (
+	"encoding/binary"
+	"math"
+)
+
+// The Put and Get methods below store numbers and booleans in fixed binary
+// encodings: integers as 8 big-endian bytes, as Uint64Codec does, floats as
+// the 8 big-endian bytes of their IEEE 754 bits, and booleans as a single
+// byte of 0 or 1.  A value of the wrong size or content is reported as
+// BadFormatError.
+
+// PutUint64 writes v under key as 8 big-endian bytes.
+func (w *Writer) PutUint64(key []byte, v uint64) error {
+	var b [8]byte
+	binary.BigEndian.PutUint64(b[:], v)
+	return w.Put(key, b[:])
+}
+
+// PutInt64 writes v under key as 8 big-endian bytes.
+func (w *Writer) PutInt64(key []byte, v int64) error {
+	return w.PutUint64(key, uint64(v))
+}
+
+// PutFloat64 writes v under key as the 8 big-endian bytes of its bits.
+func (w *Writer) PutFloat64(key []byte, v float64) error {
+	return w.PutUint64(key, math.Float64bits(v))
+}
+
+// PutBool writes v under key as a byte of 0 or 1.
+func (w *Writer) PutBool(key []byte, v bool) error {
+	b := []byte{0}
+	if v {
+		b[0] = 1
+	}
+	return w.Put(key, b)
+}
+
+// GetUint64 returns the first value of key written by PutUint64.  It
+// returns ErrNotFound if the key does not exist.
+func (c *Reader) GetUint64(key []byte) (uint64, error) {
+	b, err := c.GetFirst(key)
+	if err != nil {
+		return 0, err
+	}
+	if len(b) != 8 {
+		return 0, BadFormatError
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// GetInt64 returns the first value of key written by PutInt64.  It returns
+// ErrNotFound if the key does not exist.
+func (c *Reader) GetInt64(key []byte) (int64, error) {
+	v, err := c.GetUint64(key)
+	return int64(v), err
+}
+
+// GetFloat64 returns the first value of key written by PutFloat64.  It
+// returns ErrNotFound if the key does not exist.
+func (c *Reader) GetFloat64(key []byte) (float64, error) {
+	v, err := c.GetUint64(key)
+	return math.Float64frombits(v), err
+}
+
+// GetBool returns the first value of key written by PutBool.  It returns
+// ErrNotFound if the key does not exist.
+func (c *Reader) GetBool(key []byte) (bool, error) {
+	b, err := c.GetFirst(key)
+	if err != nil {
+		return false, err
+	}
+	if len(b) != 1 || b[0] > 1 {
+		return false, BadFormatError
+	}
+	return b[0] == 1, nil
+}
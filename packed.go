@@ -0,0 +1,29 @@
+package cdbmap
+
+import "encoding/binary"
+
+// packValues appends values to dst in the packed form written with
+// WriterOptions.PackValues: each value prefixed with its length as a
+// uvarint.
+func packValues(dst []byte, values [][]byte) []byte {
+	for _, v := range values {
+		dst = binary.AppendUvarint(dst, uint64(len(v)))
+		dst = append(dst, v...)
+	}
+	return dst
+}
+
+// unpackValues appends the values packed in data to values.  The values
+// share data's memory.
+func unpackValues(values [][]byte, data []byte) ([][]byte, error) {
+	for len(data) > 0 {
+		n, size := binary.Uvarint(data)
+		if size <= 0 || n > uint64(len(data)-size) {
+			return nil, corruptf(ErrCorruptRecord, "malformed packed values")
+		}
+		data = data[size:]
+		values = append(values, data[:n:n])
+		data = data[n:]
+	}
+	return values, nil
+}
@@ -0,0 +1,280 @@
+package cdbmap
+
+import (
+	"io"
+	"runtime"
+	"sort"
+	"sync"
+)
+
+// parallelBatchSize is the number of bytes of keys and values a parallel
+// Writer collects before handing them to a worker.
+const parallelBatchSize = 1 << 20
+
+// A writeBatch is a run of records put to a parallel Writer.  A worker
+// hashes and serializes it, and the Writer's output goroutine then writes
+// the batches in the order they were put.
+type writeBatch struct {
+	in    []byte // keys and values, back to back
+	recs  []pendingRecord
+	out   []byte // the serialized records
+	slots []slot // positions relative to the start of out
+	ready chan struct{}
+}
+
+type pendingRecord struct {
+	klen, vlen int
+	exp        int64
+}
+
+// parallelWriter is the pipeline behind a Writer created with
+// WriterOptions.Parallelism above 1.
+type parallelWriter struct {
+	cw    *Writer
+	batch *writeBatch
+
+	work  chan *writeBatch // to the workers
+	order chan *writeBatch // to the output goroutine, in put order
+	free  chan *writeBatch // written batches for reuse
+	wg    sync.WaitGroup   // workers
+	done  chan struct{}    // closed when the output goroutine exits
+
+	mu  sync.Mutex
+	err error // first error from the output goroutine
+}
+
+func newParallelWriter(cw *Writer, n int) *parallelWriter {
+	p := &parallelWriter{
+		cw:    cw,
+		batch: &writeBatch{},
+		work:  make(chan *writeBatch, n),
+		order: make(chan *writeBatch, 2*n),
+		free:  make(chan *writeBatch, 2*n),
+		done:  make(chan struct{}),
+	}
+
+	p.wg.Add(n)
+	for i := 0; i < n; i++ {
+		go p.worker()
+	}
+	go p.output()
+
+	return p
+}
+
+// put queues a record.  Errors writing earlier records are returned by
+// later calls.
+func (p *parallelWriter) put(key, value []byte, exp int64) error {
+	if err := p.failed(); err != nil {
+		return err
+	}
+	if _, err := p.cw.recordSize(len(key), len(value)); err != nil {
+		return err
+	}
+
+	b := p.batch
+	b.in = append(append(b.in, key...), value...)
+	b.recs = append(b.recs, pendingRecord{len(key), len(value), exp})
+	if len(b.in) >= parallelBatchSize {
+		p.flush()
+	}
+
+	return nil
+}
+
+// flush hands the current batch on and starts a new one.
+func (p *parallelWriter) flush() {
+	b := p.batch
+	b.ready = make(chan struct{})
+	p.order <- b
+	p.work <- b
+
+	select {
+	case p.batch = <-p.free:
+	default:
+		p.batch = &writeBatch{}
+	}
+}
+
+// close writes the remaining records and stops the pipeline.
+func (p *parallelWriter) close() error {
+	if len(p.batch.recs) > 0 {
+		p.flush()
+	}
+	close(p.order)
+	close(p.work)
+	p.wg.Wait()
+	<-p.done
+
+	return p.err
+}
+
+func (p *parallelWriter) failed() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.err
+}
+
+func (p *parallelWriter) worker() {
+	defer p.wg.Done()
+
+	cw := p.cw
+	for b := range p.work {
+		var off int
+		for _, r := range b.recs {
+			key := b.in[off : off+r.klen]
+			value := b.in[off+r.klen : off+r.klen+r.vlen]
+			off += r.klen + r.vlen
+
+			b.slots = append(b.slots, slot{cw.hashKey(key), uint64(len(b.out))})
+			b.out = cw.appendRecord(b.out, key, value, r.exp)
+		}
+		close(b.ready)
+	}
+}
+
+// output writes the batches in order.  After an error it keeps receiving
+// batches, discarding them, so that put never blocks.
+func (p *parallelWriter) output() {
+	defer close(p.done)
+
+	for b := range p.order {
+		<-b.ready
+		if p.failed() == nil {
+			if err := p.write(b); err != nil {
+				p.mu.Lock()
+				p.err = err
+				p.mu.Unlock()
+			}
+		}
+
+		b.in, b.recs, b.out, b.slots = b.in[:0], b.recs[:0], b.out[:0], b.slots[:0]
+		select {
+		case p.free <- b:
+		default:
+		}
+	}
+}
+
+func (p *parallelWriter) write(b *writeBatch) error {
+	cw := p.cw
+	if uint64(len(b.out)) > cw.format.maxPos()-cw.pos {
+		return ErrTooLarge
+	}
+	if _, err := cw.wb.Write(b.out); err != nil {
+		return err
+	}
+
+	var off int
+	for i, r := range b.recs {
+		key := b.in[off : off+r.klen]
+		off += r.klen + r.vlen
+
+		end := uint64(len(b.out))
+		if i+1 < len(b.slots) {
+			end = b.slots[i+1].pos
+		}
+		cw.added(key, b.slots[i].h, end-b.slots[i].pos)
+	}
+
+	return nil
+}
+
+// ReadParallel is like Read, but decodes the data section in workers
+// chunks at once, which is faster for large databases when allocation
+// rather than I/O is the bottleneck.  If workers is less than 1,
+// runtime.GOMAXPROCS(0) is used.  Chunk boundaries are taken from the hash
+// tables, so a corrupt database may be reported differently than by Read.
+func ReadParallel(r io.ReaderAt, workers int) (map[string][]string, error) {
+	if workers < 1 {
+		workers = runtime.GOMAXPROCS(0)
+	}
+	c, err := New(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bounds, err := c.chunkBounds(workers)
+	if err != nil {
+		return nil, err
+	}
+
+	maps := make([]map[string][]string, len(bounds)-1)
+	errs := make([]error, len(maps))
+	var wg sync.WaitGroup
+	for i := range maps {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			m := make(map[string][]string)
+			errs[i] = c.iterateRange(bounds[i], bounds[i+1], func(key, value []byte) error {
+				k := string(key)
+				m[k] = append(m[k], string(value))
+				return nil
+			})
+			maps[i] = m
+		}(i)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Chunks are in file order, so appending keeps each key's values in
+	// the order they were written.
+	m := maps[0]
+	for _, cm := range maps[1:] {
+		for k, values := range cm {
+			m[k] = append(m[k], values...)
+		}
+	}
+
+	return m, nil
+}
+
+// chunkBounds splits the data section into at most n runs of records of
+// about equal size, returning the positions where they start followed by
+// the end of the data section.  Each boundary is the first record position
+// in the hash tables at or after an even split.  A boundary that is not
+// really the start of a record cannot be reached exactly by the chunk
+// before it, which then fails as corrupt.
+func (c *Reader) chunkBounds(n int) ([]uint64, error) {
+	start, eod := c.sod, c.eod
+	if eod <= start || n == 1 {
+		return []uint64{start, eod}, nil
+	}
+
+	targets := make([]uint64, n-1)
+	best := make([]uint64, n-1)
+	for i := range targets {
+		targets[i] = start + (eod-start)*uint64(i+1)/uint64(n)
+		best[i] = eod
+	}
+	err := walkSlots(c.r, c.format, &c.tables, func(_ int, _, _, pos uint64) error {
+		if pos == 0 || pos >= eod {
+			return nil
+		}
+		// best is nondecreasing, so only the targets just below pos can
+		// improve.
+		i := sort.Search(len(targets), func(i int) bool { return targets[i] > pos })
+		for i--; i >= 0 && pos < best[i]; i-- {
+			best[i] = pos
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Several targets may share a boundary; drop the empty chunks.
+	bounds := []uint64{start}
+	for _, b := range append(best, eod) {
+		if b > bounds[len(bounds)-1] {
+			bounds = append(bounds, b)
+		}
+	}
+
+	return bounds, nil
+}
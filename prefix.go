@@ -0,0 +1,103 @@
+package cdbmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"iter"
+	"sort"
+)
+
+// A prefix index lists the distinct keys of a database in sorted order, in
+// a sidecar file conventionally named after the database with ".idx"
+// appended.  It is laid out as prefixIndexMagic followed by each key as a
+// uvarint length and the key bytes.
+const prefixIndexMagic = "cdbmapX1"
+
+var errNoPrefixIndex = errors.New("reader has no prefix index")
+
+// writePrefixIndex writes the keys in keys, sorted, to w.
+func writePrefixIndex(w io.Writer, keys map[string]struct{}) error {
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	wb := bufio.NewWriter(w)
+	wb.WriteString(prefixIndexMagic)
+	buf := make([]byte, binary.MaxVarintLen64)
+	for _, k := range sorted {
+		wb.Write(buf[:binary.PutUvarint(buf, uint64(len(k)))])
+		wb.WriteString(k)
+	}
+
+	return wb.Flush()
+}
+
+// readPrefixIndex reads the sorted keys of a prefix index from r.
+func readPrefixIndex(r io.Reader) ([][]byte, error) {
+	rb := bufio.NewReader(r)
+	magic := make([]byte, len(prefixIndexMagic))
+	if _, err := io.ReadFull(rb, magic); err != nil || string(magic) != prefixIndexMagic {
+		return nil, BadFormatError
+	}
+
+	var keys [][]byte
+	for {
+		n, err := binary.ReadUvarint(rb)
+		if err == io.EOF {
+			return keys, nil
+		}
+		if err != nil {
+			return nil, corrupt(ErrCorruptRecord, err)
+		}
+		key, err := readFull(rb, nil, n)
+		if err != nil {
+			return nil, corrupt(ErrCorruptRecord, err)
+		}
+		if len(keys) > 0 && bytes.Compare(keys[len(keys)-1], key) >= 0 {
+			return nil, corruptf(ErrCorruptRecord, "prefix index out of order at %q", key)
+		}
+		keys = append(keys, key)
+	}
+}
+
+// PrefixScan returns an iterator over the records whose keys begin with
+// prefix, in ascending key order and, for each key, in the order its
+// values were written.  It needs the prefix index loaded with
+// ReaderOptions.PrefixIndex; without one, it yields an error.  The prefix
+// is normalized by ReaderOptions.KeyTransform, if set.  If reading fails,
+// the iterator yields the error and stops.
+func (c *Reader) PrefixScan(prefix []byte) iter.Seq2[Record, error] {
+	return func(yield func(Record, error) bool) {
+		if c.index == nil {
+			yield(Record{}, errNoPrefixIndex)
+			return
+		}
+		if c.opts.KeyTransform != nil {
+			prefix = c.opts.KeyTransform(prefix)
+		}
+
+		i := sort.Search(len(c.index), func(i int) bool {
+			return bytes.Compare(c.index[i], prefix) >= 0
+		})
+		for ; i < len(c.index) && bytes.HasPrefix(c.index[i], prefix); i++ {
+			values, err := c.GetAll(c.index[i])
+			if err == ErrNotFound {
+				continue // every value has expired
+			}
+			if err != nil {
+				yield(Record{}, err)
+				return
+			}
+			for _, v := range values {
+				if !yield(Record{c.index[i], v}, nil) {
+					return
+				}
+			}
+		}
+	}
+}
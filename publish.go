@@ -0,0 +1,139 @@
+package cdbmap
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Publisher replaces several related databases together, such as a
+// forward index and its reverse.  Each database is written to a temporary
+// file from Create, and Commit renames them all into place only if every
+// one was written successfully.  If a rename fails part way, the files
+// already replaced are restored from hard-linked backups, so readers see
+// either all of the old databases or all of the new ones, apart from the
+// moment between renames.
+//
+// A Publisher is not safe for concurrent use.
+type Publisher struct {
+	files []publishFile
+	done  bool
+}
+
+type publishFile struct {
+	name   string
+	tmp    *os.File
+	backup string // hard link to the file being replaced, if any
+}
+
+var errPublished = errors.New("publisher already committed or aborted")
+
+// Create returns a temporary file in the same directory as filename, to
+// which a database should be written.  Commit renames it to filename.
+func (p *Publisher) Create(filename string) (*os.File, error) {
+	if p.done {
+		return nil, errPublished
+	}
+
+	dir, base := filepath.Split(filename)
+	if dir == "" {
+		dir = "."
+	}
+	tmp, err := ioutil.TempFile(dir, base+".tmp")
+	if err != nil {
+		return nil, err
+	}
+	if err = tmp.Chmod(0644); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return nil, err
+	}
+
+	p.files = append(p.files, publishFile{name: filename, tmp: tmp})
+	return tmp, nil
+}
+
+// Commit syncs and closes the temporary files and renames each over its
+// filename.  If anything fails, the temporary files are removed, any
+// databases already replaced are restored, and the error is returned.
+func (p *Publisher) Commit() (err error) {
+	if p.done {
+		return errPublished
+	}
+	p.done = true
+	defer p.cleanup()
+
+	for _, f := range p.files {
+		if err = f.tmp.Sync(); err != nil {
+			return
+		}
+		if err = f.tmp.Close(); err != nil {
+			return
+		}
+	}
+
+	// Link the current databases to backup names so that they can be put
+	// back if a later rename fails.
+	for i := range p.files {
+		f := &p.files[i]
+		if _, err = os.Stat(f.name); os.IsNotExist(err) {
+			err = nil
+			continue
+		} else if err != nil {
+			return
+		}
+		f.backup = f.tmp.Name() + ".old"
+		if err = os.Link(f.name, f.backup); err != nil {
+			f.backup = ""
+			return
+		}
+	}
+
+	for i, f := range p.files {
+		if err = replaceFile(f.tmp.Name(), f.name); err != nil {
+			p.rollback(i)
+			return
+		}
+	}
+
+	for _, f := range p.files {
+		if err = syncDir(filepath.Dir(f.name)); err != nil {
+			return
+		}
+	}
+
+	return nil
+}
+
+// Abort removes the temporary files without replacing anything.
+func (p *Publisher) Abort() error {
+	if p.done {
+		return errPublished
+	}
+	p.done = true
+	p.cleanup()
+	return nil
+}
+
+// rollback restores the first n files, which have been replaced.
+func (p *Publisher) rollback(n int) {
+	for _, f := range p.files[:n] {
+		if f.backup != "" {
+			replaceFile(f.backup, f.name)
+		} else {
+			os.Remove(f.name)
+		}
+	}
+}
+
+// cleanup removes whatever temporary files and backups are left.
+func (p *Publisher) cleanup() {
+	for _, f := range p.files {
+		f.tmp.Close()
+		os.Remove(f.tmp.Name())
+		if f.backup != "" {
+			os.Remove(f.backup)
+		}
+	}
+}
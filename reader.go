@@ -0,0 +1,774 @@
+package cdbmap
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Reader looks up keys in a cdb on demand, without loading the whole
+// database into memory.
+//
+// A Reader is safe for concurrent use by multiple goroutines, provided the
+// underlying io.ReaderAt is (as *os.File is).  Lookups share no mutable
+// state; each takes its scratch buffers from a pool.  Close must not be
+// called while lookups are in progress.
+type Reader struct {
+	r      io.ReaderAt
+	closer io.Closer
+	file   *os.File // the file opened by Open, for Advise
+	mapped []byte   // the mapping made by OpenMmap, for Advise
+	format Format
+	tables [256]table
+	opts   ReaderOptions
+
+	// sod and eod are the start and end of the data section.  eod is the
+	// start of the hash tables, or of any padding before them if
+	// opts.Lenient is set, unless the hash tables come first.
+	sod, eod uint64
+
+	// checksums is set if values end with a checksum, which is stripped
+	// and, unless opts.IgnoreChecksums is set, verified.
+	checksums bool
+
+	// index holds the sorted keys from opts.PrefixIndex, if given.
+	index [][]byte
+
+	// bloom is the filter from opts.BloomFilter, if given.
+	bloom *bloomFilter
+
+	// decomp decompresses values if opts.CompressedValues is set, and
+	// dictKey is the key of the record holding its dictionary.
+	decomp  *valueDecompressor
+	dictKey []byte
+
+	countOnce sync.Once
+	nrecs     uint64
+	countErr  error
+}
+
+// ReaderOptions configures a Reader.
+type ReaderOptions struct {
+	// IgnoreChecksums skips verifying the per-value checksums of a
+	// database written with WriterOptions.Checksum.  The checksums are
+	// still stripped from the values.
+	IgnoreChecksums bool
+
+	// Hash hashes keys for lookups.  It must match the WriterOptions.Hash
+	// the database was written with, and defaults to the standard cdb
+	// hash.
+	Hash func(key []byte) uint32
+
+	// MaxRecordSize, if not 0, is the largest key plus value size in bytes
+	// a Reader will read.  Larger records are rejected with
+	// ErrRecordTooLarge rather than read into memory.
+	MaxRecordSize uint64
+
+	// Metrics, if set, receives a report of every lookup.
+	Metrics Metrics
+
+	// Tracer, if set, receives a TraceOpen event when the Reader has been
+	// created and a TraceLookup event for every lookup Metrics would see.
+	Tracer Tracer
+
+	// Expiry must be set to read a database written with
+	// WriterOptions.Expiry.  Records whose expiry time has passed are then
+	// treated as not found, unless IncludeExpired is also set.
+	Expiry         bool
+	IncludeExpired bool
+
+	// Now returns the time expiry is checked against.  It defaults to
+	// time.Now.
+	Now func() time.Time
+
+	// KeyTransform, if set, normalizes every key looked up, and should
+	// match the WriterOptions.KeyTransform the database was written with.
+	// It must return keys it has already normalized unchanged.
+	KeyTransform func(key []byte) []byte
+
+	// PrefixIndex, if set, is read in full when the Reader is created, as
+	// the sorted key index written through WriterOptions.PrefixIndex.  It
+	// enables PrefixScan.
+	PrefixIndex io.Reader
+
+	// BloomFilter, if set, is read in full when the Reader is created, as
+	// the bloom filter written through WriterOptions.BloomFilter.  Lookups
+	// of keys the filter rules out then return without reading the hash
+	// tables.
+	BloomFilter io.Reader
+
+	// Lenient tolerates zero padding between the last record and the
+	// hash tables, which some writers add to align them.  Iterating then
+	// stops at the last record instead of misreading the padding as
+	// records or failing on it.  Lookups are unaffected either way.
+	Lenient bool
+
+	// VerifyHashes rehashes the keys of records as they are read, and
+	// reports a record whose key does not hash to the slot that points at
+	// it as corrupt, instead of skipping it as a hash collision.  Iterate
+	// also probes the hash tables for every record it reads, so it costs a
+	// lookup per record; it is meant for databases from untrusted sources.
+	VerifyHashes bool
+
+	// PackedValues must be set to read a database written with
+	// WriterOptions.PackValues.  Lookups and Iterate then unpack each
+	// record into the values it holds, so they see the same values as if
+	// each had been written as its own record.  Keys, Refs and the
+	// statistics still count records.
+	PackedValues bool
+
+	// CompressedValues must be set to read a database written with
+	// WriterOptions.CompressValues.  The dictionary is read when the
+	// Reader is created, and values are decompressed as they are read.
+	// Iterate skips the record holding the dictionary; Keys, Refs and the
+	// statistics still count it.  Without it, the dictionary is not
+	// looked for, and DictionaryKey is an ordinary key.
+	CompressedValues bool
+}
+
+// New returns a Reader for the cdb in r.  The format of the database is
+// detected from its header.
+func New(r io.ReaderAt) (*Reader, error) {
+	return NewWithOptions(r, ReaderOptions{})
+}
+
+// NewWithOptions returns a Reader for the cdb in r configured by opts.
+func NewWithOptions(r io.ReaderAt, opts ReaderOptions) (*Reader, error) {
+	start := time.Now()
+	f, t, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.Hash == nil {
+		opts.Hash = checksum
+	}
+	if opts.Now == nil {
+		opts.Now = time.Now
+	}
+	c := &Reader{r: r, format: f, tables: t, opts: opts, sod: f.headerSize(), eod: t[0].pos}
+	if f.indexFirst(&t) {
+		if c.sod, c.eod, err = dataSection(r, f, &t); err != nil {
+			return nil, err
+		}
+	} else {
+		if compressedIndex(r, f, &t) {
+			return nil, ErrCompressed
+		}
+		if opts.Lenient {
+			if c.eod, err = dataEnd(r, f, &t); err != nil {
+				return nil, err
+			}
+		}
+		c.checksums = readChecksumTrailer(r, f, &t) != nil
+	}
+	if opts.PrefixIndex != nil {
+		if c.index, err = readPrefixIndex(opts.PrefixIndex); err != nil {
+			return nil, err
+		}
+	}
+	if opts.BloomFilter != nil {
+		if c.bloom, err = readBloomFilter(opts.BloomFilter); err != nil {
+			return nil, err
+		}
+	}
+	if opts.CompressedValues {
+		if err = c.readDictionary(); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Tracer != nil {
+		opts.Tracer.Trace(TraceEvent{Op: TraceOpen, Duration: time.Since(start)})
+	}
+
+	return c, nil
+}
+
+// readDictionary reads the dictionary stored under DictionaryKey and sets
+// the Reader up to decompress values with it.  The dictionary is read as
+// it was written, neither packed nor compressed.
+func (c *Reader) readDictionary() error {
+	var dict []byte
+	found := false
+	err := c.lookup([]byte(DictionaryKey), nil, func(pos, dlen uint64) (bool, error) {
+		v, err := c.readValue(pos, dlen, nil)
+		dict, found = v, err == nil
+		return false, err
+	})
+	if err != nil {
+		return err
+	}
+	if !found {
+		return corruptf(ErrCorruptRecord, "no compression dictionary")
+	}
+
+	c.dictKey = []byte(DictionaryKey)
+	if c.opts.KeyTransform != nil {
+		c.dictKey = c.opts.KeyTransform(c.dictKey)
+	}
+	c.decomp, err = parseDictionary(dict, c.opts.MaxRecordSize)
+	return err
+}
+
+// Open opens the named cdb file for reading.  The Reader should be
+// closed with Close when no longer needed.
+func Open(filename string) (*Reader, error) {
+	f, err := openFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := New(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	c.closer = f
+	c.file = f
+
+	return c, nil
+}
+
+// Close closes the file opened by Open.  It does nothing for a Reader
+// returned by New.
+func (c *Reader) Close() error {
+	if c.closer == nil {
+		return nil
+	}
+	return c.closer.Close()
+}
+
+// Get returns all values stored under key, in the order they were written.
+// It returns ErrNotFound if the key does not exist.
+func (c *Reader) Get(key string) ([]string, error) {
+	values, err := c.GetAll([]byte(key))
+	if err != nil {
+		return nil, err
+	}
+
+	s := make([]string, len(values))
+	for i, v := range values {
+		s[i] = string(v)
+	}
+
+	return s, nil
+}
+
+// GetFirst returns the first value stored under key.  It returns ErrNotFound if
+// the key does not exist.
+func (c *Reader) GetFirst(key []byte) ([]byte, error) {
+	return c.GetAt(key, 0)
+}
+
+// GetAt returns the value stored under key after skipping the first skip
+// values, as the skip argument of djb's cdbget does.  It returns ErrNotFound if
+// the key has skip or fewer values.
+func (c *Reader) GetAt(key []byte, skip int) ([]byte, error) {
+	st, report := c.track(key)
+	defer report()
+
+	var value []byte
+	found := false
+	err := c.lookup(key, st, func(pos, dlen uint64) (bool, error) {
+		if c.opts.PackedValues {
+			values, err := c.readValues(nil, pos, dlen, st)
+			if err != nil || skip >= len(values) {
+				skip -= len(values)
+				return true, err
+			}
+			value, found = values[skip], true
+			return false, nil
+		}
+		if skip > 0 {
+			skip--
+			return true, nil
+		}
+		v, err := c.readValue(pos, dlen, st)
+		value, found = v, true
+		return false, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return value, nil
+}
+
+// GetAll returns all values stored under key, in the order they were
+// written.  It returns ErrNotFound if the key does not exist.
+func (c *Reader) GetAll(key []byte) ([][]byte, error) {
+	st, report := c.track(key)
+	defer report()
+
+	var values [][]byte
+	found := false
+	err := c.lookup(key, st, func(pos, dlen uint64) (bool, error) {
+		var err error
+		values, err = c.readValues(values, pos, dlen, st)
+		found = true
+		return true, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, ErrNotFound
+	}
+
+	return values, nil
+}
+
+// GetBatch looks up every key in keys and returns their values, as GetAll
+// does, in a map keyed by key.  Keys that do not exist are left out of the
+// map.  The hash tables are probed for all keys first, and the values are
+// then read in ascending file order, which makes many lookups far cheaper
+// than random reads when the database is not in memory.
+func (c *Reader) GetBatch(keys [][]byte) (map[string][][]byte, error) {
+	type ref struct {
+		key       string
+		i         int
+		pos, dlen uint64
+	}
+
+	var refs []ref
+	result := make(map[string][][]byte, len(keys))
+	for _, key := range keys {
+		k := string(key)
+		if _, ok := result[k]; ok {
+			continue
+		}
+
+		n := 0
+		st, report := c.track(key)
+		err := c.lookup(key, st, func(pos, dlen uint64) (bool, error) {
+			refs = append(refs, ref{k, n, pos, dlen})
+			n++
+			return true, nil
+		})
+		report()
+		if err != nil {
+			return nil, err
+		}
+		if n > 0 {
+			result[k] = make([][]byte, n)
+		}
+	}
+
+	sort.Slice(refs, func(i, j int) bool { return refs[i].pos < refs[j].pos })
+	for _, r := range refs {
+		v, err := c.readValue(r.pos, r.dlen, nil)
+		if err != nil {
+			return nil, err
+		}
+		result[r.key][r.i] = v
+	}
+
+	if c.opts.PackedValues {
+		for k, packed := range result {
+			var values [][]byte
+			for _, p := range packed {
+				var err error
+				if values, err = unpackValues(values, p); err != nil {
+					return nil, err
+				}
+			}
+			result[k] = values
+		}
+	}
+
+	return result, nil
+}
+
+// Exists reports whether key is present, without reading its values.
+func (c *Reader) Exists(key []byte) (bool, error) {
+	st, report := c.track(key)
+	defer report()
+
+	found := false
+	err := c.lookup(key, st, func(pos, dlen uint64) (bool, error) {
+		found = true
+		return false, nil
+	})
+
+	return found, err
+}
+
+// Count returns the number of values stored under key, without reading
+// them unless they are packed.
+func (c *Reader) Count(key []byte) (int, error) {
+	st, report := c.track(key)
+	defer report()
+
+	n := 0
+	err := c.lookup(key, st, func(pos, dlen uint64) (bool, error) {
+		if c.opts.PackedValues {
+			values, err := c.readValues(nil, pos, dlen, st)
+			n += len(values)
+			return true, err
+		}
+		n++
+		return true, nil
+	})
+
+	return n, err
+}
+
+// scratchPool holds the buffers lookup uses for slots, record headers and
+// keys, so concurrent lookups neither share nor allocate them.
+var scratchPool = sync.Pool{
+	New: func() interface{} { return new([]byte) },
+}
+
+// noReport is the report function track returns when there are no Metrics
+// or Tracer.
+func noReport() {}
+
+// track returns the LookupStats for a lookup of key to fill in and a
+// function that reports them to the Reader's Metrics and Tracer, or nil and
+// a no-op without either.
+func (c *Reader) track(key []byte) (*LookupStats, func()) {
+	m, t := c.opts.Metrics, c.opts.Tracer
+	if m == nil && t == nil {
+		return nil, noReport
+	}
+
+	st := &LookupStats{}
+	start := time.Now()
+	return st, func() {
+		st.Duration = time.Since(start)
+		if m != nil {
+			m.Lookup(*st)
+		}
+		if t != nil {
+			t.Trace(TraceEvent{
+				Op:       TraceLookup,
+				Key:      key,
+				Found:    st.Found,
+				Probes:   st.Probes,
+				Bytes:    uint64(st.BytesRead),
+				Duration: st.Duration,
+			})
+		}
+	}
+}
+
+// probeWindow is the number of hash table slots lookup reads at once.  The
+// tables are at most half full, so almost every probe chain fits in one
+// read, while a lookup in a large table still reads only a few hundred
+// bytes of it.
+const probeWindow = 32
+
+// lookup probes the hash table for key and calls fn with the position and
+// length of each matching value, in the order they were written, until fn
+// returns false or an error.  If st is not nil, the probes and bytes read
+// are added to it.
+func (c *Reader) lookup(key []byte, st *LookupStats, fn func(pos, dlen uint64) (bool, error)) error {
+	if c.opts.KeyTransform != nil {
+		key = c.opts.KeyTransform(key)
+	}
+	h := c.opts.Hash(key)
+	t := c.tables[h%256]
+	if t.nslots == 0 || c.bloom != nil && !c.bloom.mayContain(h) {
+		return nil
+	}
+
+	f, n := c.format, c.format.numSize()
+	slotSize := uint64(2 * n)
+	sp := scratchPool.Get().(*[]byte)
+	defer scratchPool.Put(sp)
+	wbytes := int(probeWindow * slotSize)
+	if need := wbytes + 2*n + len(key); cap(*sp) < need {
+		*sp = make([]byte, need)
+	}
+	window := (*sp)[:wbytes]
+	buf, kbuf := (*sp)[wbytes:wbytes+2*n], (*sp)[wbytes+2*n:wbytes+2*n+len(key)]
+	start := uint64(h/256) % t.nslots
+	var slots []byte // the slots read but not yet probed
+	for i := uint64(0); i < t.nslots; i++ {
+		if len(slots) == 0 {
+			// Read the next run of slots at once, stopping at the end of
+			// the table or of the slots left to probe.
+			j := (start + i) % t.nslots
+			count := uint64(probeWindow)
+			if left := t.nslots - j; left < count {
+				count = left
+			}
+			if left := t.nslots - i; left < count {
+				count = left
+			}
+			slots = window[:count*slotSize]
+			if _, err := c.r.ReadAt(slots, int64(t.pos+j*slotSize)); err != nil {
+				return corrupt(ErrCorruptHeader, err)
+			}
+			if st != nil {
+				st.BytesRead += len(slots)
+			}
+		}
+		if st != nil {
+			st.Probes++
+		}
+
+		sh, pos := f.getNum(slots), f.getNum(slots[n:])
+		slots = slots[slotSize:]
+		if pos == 0 { // empty slot, end of probe chain
+			break
+		}
+		if sh != uint64(h) {
+			continue
+		}
+
+		if _, err := c.r.ReadAt(buf, int64(pos)); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+		if st != nil {
+			st.BytesRead += len(buf)
+		}
+		if klen != uint64(len(key)) {
+			if c.opts.VerifyHashes {
+				if err := c.checkKeyHash(pos, klen, sh); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		pos += uint64(2 * n)
+		if _, err := c.r.ReadAt(kbuf, int64(pos)); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+		if st != nil {
+			st.BytesRead += len(kbuf)
+		}
+		if !bytes.Equal(kbuf, key) {
+			if c.opts.VerifyHashes {
+				if kh := c.opts.Hash(kbuf); uint64(kh) != sh {
+					return corruptf(ErrCorruptHeader, "record at %d: key %q hashes to %#x, slot has %#x", pos-uint64(2*n), kbuf, kh, sh)
+				}
+			}
+			continue
+		}
+		if c.opts.Expiry && !c.opts.IncludeExpired {
+			exp := buf[:expirySize]
+			if dlen < expirySize {
+				return corruptf(ErrCorruptRecord, "value at %d too short for its expiry time", pos+klen)
+			}
+			if _, err := c.r.ReadAt(exp, int64(pos+klen)); err != nil {
+				return corrupt(ErrCorruptRecord, err)
+			}
+			if st != nil {
+				st.BytesRead += len(exp)
+			}
+			if c.expired(exp) {
+				continue
+			}
+		}
+		if st != nil {
+			st.Found = true
+		}
+
+		more, err := fn(pos+klen, dlen)
+		if err != nil || !more {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// checkKeyHash reads the klen-byte key of the record at pos and checks
+// that it hashes to h, the hash of the slot pointing at it.
+func (c *Reader) checkKeyHash(pos, klen, h uint64) error {
+	if err := checkRecordSize(pos, klen, c.opts.MaxRecordSize); err != nil {
+		return err
+	}
+	key, err := readFullAt(c.r, nil, pos+uint64(2*c.format.numSize()), klen)
+	if err != nil {
+		return corrupt(ErrCorruptRecord, err)
+	}
+	if kh := c.opts.Hash(key); uint64(kh) != h {
+		return corruptf(ErrCorruptHeader, "record at %d: key %q hashes to %#x, slot has %#x", pos, key, kh, h)
+	}
+	return nil
+}
+
+// checkIndexed checks that the hash tables have a slot with the hash of
+// key pointing at the record at pos.
+func (c *Reader) checkIndexed(pos uint64, key []byte) error {
+	h := c.opts.Hash(key)
+	t := c.tables[h%256]
+	f, n := c.format, uint64(c.format.numSize())
+	buf := make([]byte, 2*n)
+	var start uint64
+	if t.nslots > 0 {
+		start = uint64(h/256) % t.nslots
+	}
+	for i := uint64(0); i < t.nslots; i++ {
+		if _, err := c.r.ReadAt(buf, int64(t.pos+2*n*((start+i)%t.nslots))); err != nil {
+			return corrupt(ErrCorruptHeader, err)
+		}
+		sh, spos := f.getNum(buf), f.getNum(buf[n:])
+		if spos == 0 {
+			break
+		}
+		if sh == uint64(h) && spos == pos {
+			return nil
+		}
+	}
+	return corruptf(ErrCorruptHeader, "record at %d: key %q is not in the hash tables under hash %#x", pos, key, h)
+}
+
+// readValue reads the dlen bytes of data at pos and returns the value they
+// hold, adding the bytes read to st if it is not nil.
+func (c *Reader) readValue(pos, dlen uint64, st *LookupStats) ([]byte, error) {
+	if err := checkRecordSize(pos, dlen, c.opts.MaxRecordSize); err != nil {
+		return nil, err
+	}
+	data, err := readFullAt(c.r, nil, pos, dlen)
+	if err != nil {
+		return nil, corrupt(ErrCorruptRecord, err)
+	}
+	if st != nil {
+		st.BytesRead += len(data)
+	}
+
+	return c.value(data)
+}
+
+// readValues reads the dlen bytes of data at pos and appends the values
+// they hold to values: one value, or the packed values if the Reader has
+// ReaderOptions.PackedValues set.
+func (c *Reader) readValues(values [][]byte, pos, dlen uint64, st *LookupStats) ([][]byte, error) {
+	v, err := c.readValue(pos, dlen, st)
+	if err != nil {
+		return values, err
+	}
+	if !c.opts.PackedValues {
+		return append(values, v), nil
+	}
+	return unpackValues(values, v)
+}
+
+// value returns the value held in a record's data, checking and removing
+// its checksum if the database has them, removing its expiry time if the
+// Reader expects one, and decompressing it if the values are compressed.
+func (c *Reader) value(data []byte) (value []byte, err error) {
+	switch {
+	case !c.checksums:
+		value = data
+	case c.opts.IgnoreChecksums:
+		if len(data) < checksumSize {
+			return nil, corruptf(ErrCorruptRecord, "value too short for its checksum")
+		}
+		value = data[:len(data)-checksumSize]
+	default:
+		if value, err = splitChecksum(data); err != nil {
+			return nil, err
+		}
+	}
+
+	if c.opts.Expiry {
+		if len(value) < expirySize {
+			return nil, corruptf(ErrCorruptRecord, "value too short for its expiry time")
+		}
+		value = value[expirySize:]
+	}
+	if c.decomp != nil {
+		return c.decomp.decompress(value)
+	}
+	return value, nil
+}
+
+// Len returns the number of records in the database.  It is computed from
+// the hash tables on first use, without reading any records, and cached.
+func (c *Reader) Len() (int, error) {
+	c.countOnce.Do(c.count)
+	return int(c.nrecs), c.countErr
+}
+
+// DataBytes returns the total size of all keys and values in the database.
+// Like Len, it is derived from the hash tables rather than by reading
+// records.
+func (c *Reader) DataBytes() (uint64, error) {
+	c.countOnce.Do(c.count)
+	if c.countErr != nil {
+		return 0, c.countErr
+	}
+
+	// The data section holds every record's lengths, key and value, and
+	// possibly a checksum.
+	hdrs := c.nrecs * 2 * uint64(c.format.numSize())
+	if c.checksums {
+		hdrs += c.nrecs * checksumSize
+	}
+	size := c.eod - c.sod
+	if size < hdrs {
+		return 0, corruptf(ErrCorruptHeader, "hash tables reference more records than fit in the data section")
+	}
+	return size - hdrs, nil
+}
+
+// count counts the used slots in the hash tables, one per record.
+func (c *Reader) count() {
+	c.countErr = walkSlots(c.r, c.format, &c.tables, func(i int, j, h, pos uint64) error {
+		if pos != 0 {
+			c.nrecs++
+		}
+		return nil
+	})
+}
+
+// Iterate calls fn for each record in the database, as the package-level
+// Iterate does.
+func (c *Reader) Iterate(fn func(key, value []byte) error) error {
+	return c.iterateRange(c.sod, c.eod, fn)
+}
+
+// iterateRange is like Iterate, but walks only the records from start up
+// to end.  start must be the start of the data section or of a record.
+func (c *Reader) iterateRange(start, end uint64, fn func(key, value []byte) error) error {
+	if !c.checksums && !c.opts.Expiry && !c.opts.VerifyHashes && !c.opts.PackedValues && c.decomp == nil {
+		return iterate(c.r, c.format, start, end, c.opts.MaxRecordSize, fn)
+	}
+
+	skipExpired := c.opts.Expiry && !c.opts.IncludeExpired
+	pos, n := start, uint64(2*c.format.numSize())
+	return iterate(c.r, c.format, start, end, c.opts.MaxRecordSize, func(key, data []byte) error {
+		if c.opts.VerifyHashes {
+			if err := c.checkIndexed(pos, key); err != nil {
+				return err
+			}
+			pos += n + uint64(len(key)) + uint64(len(data))
+		}
+		if skipExpired && len(data) >= expirySize && c.expired(data) {
+			return nil
+		}
+		if c.decomp != nil && bytes.Equal(key, c.dictKey) {
+			return nil
+		}
+		value, err := c.value(data)
+		if err != nil {
+			return err
+		}
+		if !c.opts.PackedValues {
+			return fn(key, value)
+		}
+
+		values, err := unpackValues(nil, value)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			if err = fn(key, v); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
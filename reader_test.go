@@ -0,0 +1,801 @@
+package cdbmap
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// makeBenchDB writes a database of n keys with one value each and opens it.
+func makeBenchDB(tb testing.TB, n int) (*Reader, [][]byte) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		tb.Fatalf("Failed to create temp file: %s", err)
+	}
+	tb.Cleanup(func() { os.Remove(tmp.Name()) })
+
+	w, err := NewWriter(tmp)
+	if err != nil {
+		tb.Fatalf("NewWriter failed: %s", err)
+	}
+	keys := make([][]byte, n)
+	for i := range keys {
+		keys[i] = []byte(fmt.Sprintf("key%d", i))
+		if err = w.Put(keys[i], []byte(fmt.Sprintf("value%d", i))); err != nil {
+			tb.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := Open(tmp.Name())
+	if err != nil {
+		tb.Fatalf("Open failed: %s", err)
+	}
+	tb.Cleanup(func() { c.Close() })
+
+	return c, keys
+}
+
+func TestReaderConcurrent(t *testing.T) {
+	c, keys := makeBenchDB(t, 1000)
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i, key := range keys {
+				v, err := c.GetFirst(key)
+				if err != nil {
+					t.Errorf("GetFirst(%s) failed: %s", key, err)
+					return
+				}
+				if string(v) != fmt.Sprintf("value%d", i) {
+					t.Errorf("GetFirst(%s) = %q", key, v)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func BenchmarkGetFirst(b *testing.B) {
+	c, keys := makeBenchDB(b, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetFirst(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// readAtCounter counts the ReadAt calls made on an io.ReaderAt.
+type readAtCounter struct {
+	r     io.ReaderAt
+	calls int64
+}
+
+func (rc *readAtCounter) ReadAt(p []byte, off int64) (int, error) {
+	atomic.AddInt64(&rc.calls, 1)
+	return rc.r.ReadAt(p, off)
+}
+
+func TestLookupReadAt(t *testing.T) {
+	c, keys := makeBenchDB(t, 10000)
+	rc := &readAtCounter{r: c.r}
+	c.r = rc
+
+	// A hit reads the probe run, the record header, the key and the value.
+	for _, key := range keys {
+		if _, err := c.GetFirst(key); err != nil {
+			t.Fatalf("GetFirst(%s) failed: %s", key, err)
+		}
+	}
+	if perLookup := float64(rc.calls) / float64(len(keys)); perLookup > 4.1 {
+		t.Errorf("expected about 4 ReadAt calls per hit, got %.2f", perLookup)
+	}
+
+	// A miss normally reads only the probe run.
+	rc.calls = 0
+	for i := range keys {
+		if _, err := c.GetFirst([]byte(fmt.Sprintf("missing%d", i))); err != ErrNotFound {
+			t.Fatalf("expected ErrNotFound, got %v", err)
+		}
+	}
+	if perLookup := float64(rc.calls) / float64(len(keys)); perLookup > 1.1 {
+		t.Errorf("expected about 1 ReadAt call per miss, got %.2f", perLookup)
+	}
+}
+
+func BenchmarkGetFirstReadAt(b *testing.B) {
+	c, keys := makeBenchDB(b, 10000)
+	rc := &readAtCounter{r: c.r}
+	c.r = rc
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := c.GetFirst(keys[i%len(keys)]); err != nil {
+			b.Fatal(err)
+		}
+	}
+	b.ReportMetric(float64(rc.calls)/float64(b.N), "ReadAt/op")
+}
+
+func BenchmarkGetFirstParallel(b *testing.B) {
+	c, keys := makeBenchDB(b, 10000)
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			if _, err := c.GetFirst(keys[i%len(keys)]); err != nil {
+				b.Error(err)
+				return
+			}
+			i++
+		}
+	})
+}
+
+func TestWatch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.cdb")
+	if err = ToFile(map[string][]string{"one": {"1"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	w, err := Watch(name, time.Hour)
+	if err != nil {
+		t.Fatalf("Watch failed: %s", err)
+	}
+	defer w.Close()
+
+	if v, err := w.GetFirst([]byte("one")); err != nil || string(v) != "1" {
+		t.Fatalf("GetFirst: expected 1, got %q (%v)", v, err)
+	}
+
+	if err = ToFile(map[string][]string{"one": {"11"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	if err = w.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	if v, err := w.GetFirst([]byte("one")); err != nil || string(v) != "11" {
+		t.Fatalf("after Reload expected 11, got %q (%v)", v, err)
+	}
+}
+
+func TestCachedReader(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	name := filepath.Join(dir, "test.cdb")
+	if err = ToFile(map[string][]string{"one": {"1"}, "two": {"2"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	// Room for only one entry at a time.
+	c, err := OpenCached(name, 0, 7)
+	if err != nil {
+		t.Fatalf("OpenCached failed: %s", err)
+	}
+	defer c.Close()
+
+	for _, k := range []string{"one", "two", "two", "missing"} {
+		if _, err = c.GetFirst([]byte(k)); err != nil && err != ErrNotFound {
+			t.Fatalf("GetFirst(%q) failed: %s", k, err)
+		}
+	}
+	if c.lru.Len() != 1 || c.size != len("missing") {
+		t.Fatalf("expected one cached entry, got %d using %d bytes", c.lru.Len(), c.size)
+	}
+	if _, ok := c.entries["missing"]; !ok {
+		t.Fatalf("expected the most recent lookup to be cached")
+	}
+
+	if v, err := c.GetFirst([]byte("one")); err != nil || string(v) != "1" {
+		t.Fatalf("GetFirst: expected 1, got %q (%v)", v, err)
+	}
+	if err = ToFile(map[string][]string{"one": {"11"}}, name); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+	if err = c.w.Reload(); err != nil {
+		t.Fatalf("Reload failed: %s", err)
+	}
+	if v, err := c.GetFirst([]byte("one")); err != nil || string(v) != "11" {
+		t.Fatalf("after Reload expected 11, got %q (%v)", v, err)
+	}
+}
+
+func TestPrefixScan(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	idx := bytes.NewBuffer(nil)
+	w, err := NewWriterWithOptions(tmp, WriterOptions{PrefixIndex: idx})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, kv := range [][2]string{{"user:2", "b"}, {"item:1", "x"}, {"user:1", "a"}, {"user:2", "bb"}, {"users", "-"}} {
+		if err = w.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{PrefixIndex: idx})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	var got []string
+	for rec, err := range c.PrefixScan([]byte("user:")) {
+		if err != nil {
+			t.Fatalf("PrefixScan failed: %s", err)
+		}
+		got = append(got, string(rec.Key)+"="+string(rec.Value))
+	}
+	expected := []string{"user:1=a", "user:2=b", "user:2=bb"}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	c, err = New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	for _, err = range c.PrefixScan([]byte("user:")) {
+	}
+	if err != errNoPrefixIndex {
+		t.Fatalf("expected errNoPrefixIndex, got %v", err)
+	}
+}
+
+// probeCounter is a Metrics that counts hash table probes.
+type probeCounter struct {
+	probes int
+}
+
+func (p *probeCounter) Lookup(st LookupStats) { p.probes += st.Probes }
+
+func TestBloomFilter(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	bf := bytes.NewBuffer(nil)
+	w, err := NewWriterWithOptions(tmp, WriterOptions{BloomFilter: bf, BloomFalsePositiveRate: 0.01})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	const n = 1000
+	for i := 0; i < n; i++ {
+		if err = w.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	pc := &probeCounter{}
+	c, err := NewWithOptions(tmp, ReaderOptions{BloomFilter: bf, Metrics: pc})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	for i := 0; i < n; i++ {
+		if ok, err := c.Exists([]byte(fmt.Sprintf("key%d", i))); !ok || err != nil {
+			t.Fatalf("Exists(key%d): expected true, got %v (%v)", i, ok, err)
+		}
+	}
+
+	// Nearly every miss should be answered by the filter alone.
+	pc.probes = 0
+	for i := 0; i < n; i++ {
+		if ok, err := c.Exists([]byte(fmt.Sprintf("missing%d", i))); ok || err != nil {
+			t.Fatalf("Exists(missing%d): expected false, got %v (%v)", i, ok, err)
+		}
+	}
+	if pc.probes > n/20 {
+		t.Fatalf("expected few probes for missing keys, got %d", pc.probes)
+	}
+}
+
+func TestMetrics(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	if err = WriteRecords([]Record{{[]byte("one"), []byte("1")}}, tmp); err != nil {
+		t.Fatalf("WriteRecords failed: %s", err)
+	}
+
+	m := NewExpvarMetrics("cdbmap_test")
+	c, err := NewWithOptions(tmp, ReaderOptions{Metrics: m})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if _, err = c.GetFirst([]byte("one")); err != nil {
+		t.Fatalf("GetFirst failed: %s", err)
+	}
+	if _, err = c.GetFirst([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("non-existent key should return ErrNotFound, got %v", err)
+	}
+
+	if m.lookups.Value() != 2 || m.hits.Value() != 1 || m.misses.Value() != 1 {
+		t.Fatalf("expected 2 lookups, 1 hit and 1 miss, got %d, %d and %d", m.lookups.Value(), m.hits.Value(), m.misses.Value())
+	}
+	// The hit reads a slot, the record header, the key and the value.
+	if m.probes.Value() < 1 || m.bytesRead.Value() < 8+8+3+1 {
+		t.Fatalf("expected at least 1 probe and 20 bytes read, got %d and %d", m.probes.Value(), m.bytesRead.Value())
+	}
+}
+
+func TestTracer(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	var events []TraceEvent
+	tracer := TracerFunc(func(e TraceEvent) {
+		e.Key = append([]byte(nil), e.Key...)
+		events = append(events, e)
+	})
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Tracer: tracer})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	if err = w.Put([]byte("one"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{Tracer: tracer})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if _, err = c.GetFirst([]byte("one")); err != nil {
+		t.Fatalf("GetFirst failed: %s", err)
+	}
+
+	var ops []string
+	for _, e := range events {
+		ops = append(ops, e.Op)
+	}
+	want := []string{TraceFlush, TraceTables, TraceClose, TraceOpen, TraceLookup}
+	if !reflect.DeepEqual(ops, want) {
+		t.Fatalf("expected events %q, got %q", want, ops)
+	}
+	if e := events[2]; e.Records != 1 || e.Bytes != w.Stats().Bytes {
+		t.Errorf("expected close with 1 record of %d bytes, got %+v", w.Stats().Bytes, e)
+	}
+	if e := events[4]; string(e.Key) != "one" || !e.Found || e.Probes < 1 {
+		t.Errorf("expected a successful lookup of one, got %+v", e)
+	}
+}
+
+func TestGetBatch(t *testing.T) {
+	c, keys := makeBenchDB(t, 100)
+
+	batch := [][]byte{keys[42], keys[7], []byte("missing"), keys[7]}
+	got, err := c.GetBatch(batch)
+	if err != nil {
+		t.Fatalf("GetBatch failed: %s", err)
+	}
+	if len(got) != 2 {
+		t.Fatalf("expected 2 keys, got %d", len(got))
+	}
+	for _, i := range []int{42, 7} {
+		v := got[string(keys[i])]
+		if len(v) != 1 || string(v[0]) != fmt.Sprintf("value%d", i) {
+			t.Fatalf("key%d: expected [value%d], got %q", i, i, v)
+		}
+	}
+}
+
+func TestHTTPReaderAt(t *testing.T) {
+	buf := bytes.NewBuffer(nil)
+	m := map[string][]string{"one": {"1"}, "two": {"2", "22"}}
+	if err := WriteStream(m, buf); err != nil {
+		t.Fatalf("WriteStream failed: %s", err)
+	}
+
+	var requests atomic.Int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		http.ServeContent(w, r, "test.cdb", time.Time{}, bytes.NewReader(buf.Bytes()))
+	}))
+	defer srv.Close()
+
+	h, err := NewHTTPReaderAt(srv.URL, nil, 512, 16, nil)
+	if err != nil {
+		t.Fatalf("NewHTTPReaderAt failed: %s", err)
+	}
+	if h.Size() != int64(buf.Len()) {
+		t.Fatalf("Size: expected %d, got %d", buf.Len(), h.Size())
+	}
+	c, err := New(h)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	got, err := c.Get("two")
+	if err != nil || !reflect.DeepEqual(got, m["two"]) {
+		t.Fatalf("Get: expected %v, got %v (%v)", m["two"], got, err)
+	}
+
+	// A second lookup is answered from the cached blocks.
+	n := requests.Load()
+	if _, err = c.Get("two"); err != nil {
+		t.Fatalf("Get failed: %s", err)
+	}
+	if requests.Load() != n {
+		t.Fatalf("expected no more requests, got %d", requests.Load()-n)
+	}
+}
+
+func TestReaderLenient(t *testing.T) {
+	// Build a database whose hash tables are aligned to 16 bytes, with
+	// zero padding after the last record.
+	f := Format32
+	data := bytes.NewBuffer(nil)
+	htables := make(map[uint32][]slot)
+	pos := f.headerSize()
+	for _, rec := range []Record{{[]byte("one"), []byte("1")}, {[]byte("two"), []byte("22")}} {
+		h := checksum(rec.Key)
+		htables[h%256] = append(htables[h%256], slot{h, pos})
+		buf := make([]byte, 8)
+		f.putNum(buf, uint64(len(rec.Key)))
+		f.putNum(buf[4:], uint64(len(rec.Value)))
+		data.Write(buf)
+		data.Write(rec.Key)
+		data.Write(rec.Value)
+		pos += uint64(len(buf) + len(rec.Key) + len(rec.Value))
+	}
+	for pos%16 != 0 {
+		data.WriteByte(0)
+		pos++
+	}
+
+	tables := bytes.NewBuffer(nil)
+	header, err := buildTables(tables, f, htables, pos)
+	if err != nil {
+		t.Fatalf("buildTables failed: %s", err)
+	}
+	db := bytes.NewReader(append(append(header, data.Bytes()...), tables.Bytes()...))
+
+	c, err := New(db)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = c.Iterate(func(key, value []byte) error { return nil }); err == nil {
+		t.Fatalf("expected the padding to be misread without Lenient")
+	}
+
+	c, err = NewWithOptions(db, ReaderOptions{Lenient: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	m := make(map[string][]string)
+	err = c.Iterate(func(key, value []byte) error {
+		m[string(key)] = append(m[string(key)], string(value))
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate failed: %s", err)
+	}
+	if want := map[string][]string{"one": {"1"}, "two": {"22"}}; !reflect.DeepEqual(m, want) {
+		t.Errorf("expected %v, got %v", want, m)
+	}
+	if n, err := c.Len(); err != nil || n != 2 {
+		t.Errorf("expected Len 2, got %d (%v)", n, err)
+	}
+	if v, err := c.GetFirst([]byte("two")); err != nil || string(v) != "22" {
+		t.Errorf("expected GetFirst two = 22, got %q (%v)", v, err)
+	}
+}
+
+func TestWalkTables(t *testing.T) {
+	c, keys := makeBenchDB(t, 100)
+
+	hashes := make(map[uint32]bool)
+	for _, key := range keys {
+		hashes[checksum(key)] = true
+	}
+
+	var used, empty int
+	err := c.WalkTables(func(table, slot int, hash uint32, pos uint64) error {
+		if pos == 0 {
+			empty++
+			return nil
+		}
+		used++
+		if int(hash%256) != table {
+			t.Errorf("slot %d of table %d holds hash %#x of table %d", slot, table, hash, hash%256)
+		}
+		if !hashes[hash] {
+			t.Errorf("slot %d of table %d holds unknown hash %#x", slot, table, hash)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("WalkTables failed: %s", err)
+	}
+	if used != len(keys) || empty != len(keys) {
+		t.Errorf("expected %d used and %d empty slots, got %d and %d", len(keys), len(keys), used, empty)
+	}
+}
+
+func TestNumericValues(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for _, err := range []error{
+		w.PutUint64([]byte("hits"), 1<<40),
+		w.PutInt64([]byte("delta"), -7),
+		w.PutFloat64([]byte("ratio"), 0.25),
+		w.PutBool([]byte("enabled"), true),
+		w.Put([]byte("text"), []byte("12")),
+	} {
+		if err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if v, err := c.GetUint64([]byte("hits")); err != nil || v != 1<<40 {
+		t.Errorf("GetUint64: expected %d, got %d (%v)", uint64(1<<40), v, err)
+	}
+	if v, err := c.GetInt64([]byte("delta")); err != nil || v != -7 {
+		t.Errorf("GetInt64: expected -7, got %d (%v)", v, err)
+	}
+	if v, err := c.GetFloat64([]byte("ratio")); err != nil || v != 0.25 {
+		t.Errorf("GetFloat64: expected 0.25, got %g (%v)", v, err)
+	}
+	if v, err := c.GetBool([]byte("enabled")); err != nil || !v {
+		t.Errorf("GetBool: expected true, got %t (%v)", v, err)
+	}
+	if _, err := c.GetUint64([]byte("text")); err != BadFormatError {
+		t.Errorf("GetUint64: expected BadFormatError for a text value, got %v", err)
+	}
+	if _, err := c.GetBool([]byte("missing")); err != ErrNotFound {
+		t.Errorf("GetBool: expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestReaderCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	names := make([]string, 3)
+	for i := range names {
+		names[i] = filepath.Join(dir, fmt.Sprintf("tenant%d.cdb", i))
+		if err = ToFile(map[string][]string{"id": {fmt.Sprint(i)}}, names[i]); err != nil {
+			t.Fatalf("ToFile failed: %s", err)
+		}
+	}
+
+	rc := NewReaderCache(2)
+	defer rc.Close()
+
+	// Hold the first file while the others evict it.
+	c, release, err := rc.Acquire(names[0])
+	if err != nil {
+		t.Fatalf("Acquire failed: %s", err)
+	}
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			n := 1 + i%2
+			if v, err := rc.GetFirst(names[n], []byte("id")); err != nil || string(v) != fmt.Sprint(n) {
+				t.Errorf("GetFirst(%s): expected %d, got %q (%v)", names[n], n, v, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := rc.Len(); n != 2 {
+		t.Errorf("expected 2 open files, got %d", n)
+	}
+	if v, err := c.GetFirst([]byte("id")); err != nil || string(v) != "0" {
+		t.Errorf("expected the evicted Reader to stay usable, got %q (%v)", v, err)
+	}
+	release()
+	release()
+
+	if _, err = c.GetFirst([]byte("id")); err == nil {
+		t.Errorf("expected the evicted Reader to be closed once released")
+	}
+	if _, err = rc.GetFirst(filepath.Join(dir, "missing.cdb"), []byte("id")); !os.IsNotExist(err) {
+		t.Errorf("expected a not-exist error, got %v", err)
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	c, keys := makeBenchDB(t, 100)
+	m, err := OpenMmap(c.file.Name())
+	if err != nil {
+		t.Fatalf("OpenMmap failed: %s", err)
+	}
+	defer m.Close()
+
+	for _, r := range []*Reader{c, m} {
+		for _, a := range []Advice{AdviceRandom, AdviceSequential, AdviceWillNeed, AdviceDontNeed, AdviceNormal} {
+			if err := r.Advise(a); err != nil {
+				t.Fatalf("Advise(%d) failed: %s", a, err)
+			}
+			if v, err := r.GetFirst(keys[7]); err != nil || string(v) != "value7" {
+				t.Errorf("expected value7 after Advise(%d), got %q (%v)", a, v, err)
+			}
+		}
+	}
+}
+
+func TestDuplicateOrder(t *testing.T) {
+	// Interleave versions of several keys, and hash every key alike so
+	// that they share one probe chain, which wraps around its table.
+	var records []Record
+	want := make(map[string][]string)
+	for v := 0; v < 5; v++ {
+		for _, k := range []string{"a", "b", "c"} {
+			value := fmt.Sprintf("%s@%d", k, v)
+			records = append(records, Record{[]byte(k), []byte(value)})
+			want[k] = append(want[k], value)
+		}
+	}
+	sameHash := func(key []byte) uint32 { return 25<<8 | 7 } // starts probing 5 slots from the end
+
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Hash: sameHash})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, rec := range records {
+		if err = w.Put(rec.Key, rec.Value); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{Hash: sameHash})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	for k, values := range want {
+		if got, err := c.Get(k); err != nil || !reflect.DeepEqual(got, values) {
+			t.Errorf("Get(%s): expected %q, got %q (%v)", k, values, got, err)
+		}
+		got, err := c.Lookup([]byte(k), FirstMatch)
+		if err != nil || len(got) != 1 || string(got[0]) != values[0] {
+			t.Errorf("Lookup(%s, FirstMatch): expected %q, got %q (%v)", k, values[0], got, err)
+		}
+		if got, err = c.Lookup([]byte(k), AllMatches); err != nil || len(got) != len(values) {
+			t.Errorf("Lookup(%s, AllMatches): expected %d values, got %d (%v)", k, len(values), len(got), err)
+		}
+	}
+
+	i := 0
+	err = c.Iterate(func(key, value []byte) error {
+		if string(value) != string(records[i].Value) {
+			t.Errorf("Iterate: expected record %d to be %q, got %q", i, records[i].Value, value)
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Iterate failed: %s", err)
+	}
+
+	if m, err := Read(tmp); err != nil || !reflect.DeepEqual(m, want) {
+		t.Errorf("Read: expected %q, got %q (%v)", want, m, err)
+	}
+	first, err := ReadMatches(tmp, FirstMatch)
+	if err != nil {
+		t.Fatalf("ReadMatches failed: %s", err)
+	}
+	if expected := map[string][]string{"a": {"a@0"}, "b": {"b@0"}, "c": {"c@0"}}; !reflect.DeepEqual(first, expected) {
+		t.Errorf("ReadMatches: expected %q, got %q", expected, first)
+	}
+}
+
+func TestSample(t *testing.T) {
+	c, keys := makeBenchDB(t, 1000)
+
+	for _, n := range []int{10, 900, 2000} {
+		recs, err := c.Sample(n, 42)
+		if err != nil {
+			t.Fatalf("Sample(%d) failed: %s", n, err)
+		}
+		if want := min(n, len(keys)); len(recs) != want {
+			t.Fatalf("Sample(%d): expected %d records, got %d", n, want, len(recs))
+		}
+
+		seen := make(map[string]bool)
+		for _, rec := range recs {
+			if seen[string(rec.Key)] {
+				t.Errorf("Sample(%d): key %s sampled twice", n, rec.Key)
+			}
+			seen[string(rec.Key)] = true
+			if want := "value" + strings.TrimPrefix(string(rec.Key), "key"); string(rec.Value) != want {
+				t.Errorf("Sample(%d): expected value %s for key %s, got %s", n, want, rec.Key, rec.Value)
+			}
+		}
+
+		again, err := c.Sample(n, 42)
+		if err != nil || !reflect.DeepEqual(again, recs) {
+			t.Errorf("Sample(%d): expected the same sample for the same seed (%v)", n, err)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package cdbmap
+
+import (
+	"container/list"
+	"sync"
+)
+
+// ReaderCache opens cdb files on demand and shares one Reader per file
+// among its callers, keeping at most a fixed number of files open.  Once
+// the limit is reached, opening another file closes the least recently
+// used one.  A Reader still in use when it is evicted stays open until it
+// is released, so the limit can be exceeded by the number of Readers in
+// use at once.
+//
+// A ReaderCache is safe for concurrent use.
+type ReaderCache struct {
+	maxOpen int
+
+	mu    sync.Mutex
+	lru   *list.List // of *cachedFile, most recently used first
+	files map[string]*list.Element
+}
+
+type cachedFile struct {
+	filename string
+	c        *Reader
+	refs     int
+	evicted  bool
+}
+
+// NewReaderCache returns a ReaderCache that keeps up to maxOpen files open.
+func NewReaderCache(maxOpen int) *ReaderCache {
+	return &ReaderCache{
+		maxOpen: maxOpen,
+		lru:     list.New(),
+		files:   make(map[string]*list.Element),
+	}
+}
+
+// Acquire returns the shared Reader for the named file, opening it with
+// Open if it is not already open, and a function that releases it.  The
+// Reader must not be used after release is called, and must not be closed
+// by the caller.
+func (rc *ReaderCache) Acquire(filename string) (c *Reader, release func(), err error) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if e, ok := rc.files[filename]; ok {
+		rc.lru.MoveToFront(e)
+		cf := e.Value.(*cachedFile)
+		cf.refs++
+		return cf.c, rc.releaser(cf), nil
+	}
+
+	if c, err = Open(filename); err != nil {
+		return nil, nil, err
+	}
+	cf := &cachedFile{filename: filename, c: c, refs: 1}
+	rc.files[filename] = rc.lru.PushFront(cf)
+	for rc.lru.Len() > rc.maxOpen {
+		rc.evict(rc.lru.Back())
+	}
+
+	return c, rc.releaser(cf), nil
+}
+
+// releaser returns the release function for one reference to cf.
+func (rc *ReaderCache) releaser(cf *cachedFile) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			rc.mu.Lock()
+			defer rc.mu.Unlock()
+
+			if cf.refs--; cf.refs == 0 && cf.evicted {
+				cf.c.Close()
+			}
+		})
+	}
+}
+
+// evict removes the file in e from the cache, closing it unless it is in
+// use.  rc.mu must be held.
+func (rc *ReaderCache) evict(e *list.Element) {
+	cf := rc.lru.Remove(e).(*cachedFile)
+	delete(rc.files, cf.filename)
+	cf.evicted = true
+	if cf.refs == 0 {
+		cf.c.Close()
+	}
+}
+
+// GetFirst returns the first value stored under key in the named file, as
+// Reader.GetFirst does.
+func (rc *ReaderCache) GetFirst(filename string, key []byte) ([]byte, error) {
+	c, release, err := rc.Acquire(filename)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	return c.GetFirst(key)
+}
+
+// Evict closes the named file if it is open, as when it has been replaced,
+// so that the next Acquire opens it again.
+func (rc *ReaderCache) Evict(filename string) {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	if e, ok := rc.files[filename]; ok {
+		rc.evict(e)
+	}
+}
+
+// Close closes every file the cache holds.  Readers still in use are
+// closed when they are released.
+func (rc *ReaderCache) Close() error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	for rc.lru.Len() > 0 {
+		rc.evict(rc.lru.Back())
+	}
+	return nil
+}
+
+// Len returns the number of files the cache holds open, not counting
+// evicted Readers that are still in use.
+func (rc *ReaderCache) Len() int {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	return rc.lru.Len()
+}
@@ -0,0 +1,56 @@
+package cdbmap
+
+import "io"
+
+// Recover salvages the records of a damaged cdb in r, such as one with a
+// corrupt header or a truncated tail, and writes them to a new database in
+// w.  It returns the number of records recovered.
+//
+// The hash tables are ignored; records are found by scanning the data
+// section from the end of the header, or from the end of the hash tables
+// in a database written with WriterOptions.IndexFirst.  If the header is
+// intact, the scan stops where the data section ends.  Otherwise, and in
+// any case
+// at the first record that does not fit in the file, the scan stops and
+// the records before it are kept.  Records are copied as they are, so the
+// values of a database written with checksums or expiry times keep them.
+// Errors reading r end the scan; only errors writing w are returned.
+func Recover(r io.ReaderAt, w io.WriteSeeker) (n int, err error) {
+	f, sod, eod := Format32, Format32.headerSize(), ^uint64(0)
+	buf := make([]byte, Format64.headerSize())
+	if m, _ := r.ReadAt(buf, 0); m >= int(HeaderSize) {
+		format, t := detectFormat(buf[:m])
+		switch {
+		case !format.contiguous(&t):
+		case format.indexFirst(&t):
+			// The end of the records is only known from the hash
+			// tables; if they are damaged, scan to the end of the file.
+			f, sod = format, tablesEnd(format, &t)
+			if _, end, err := dataSection(r, format, &t); err == nil {
+				eod = end
+			}
+		default:
+			f, sod, eod = format, format.headerSize(), t[0].pos
+		}
+	}
+
+	cw, err := NewWriterWithOptions(w, WriterOptions{Format: f})
+	if err != nil {
+		return 0, err
+	}
+	defer cw.Abort()
+
+	for pos := sod; pos < eod; {
+		key, data, next, err := ReadRecord(r, f, pos)
+		if err != nil || next > eod {
+			break
+		}
+		if err = cw.Put(key, data); err != nil {
+			return n, err
+		}
+		n++
+		pos = next
+	}
+
+	return n, cw.Close()
+}
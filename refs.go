@@ -0,0 +1,87 @@
+package cdbmap
+
+import (
+	"bufio"
+	"io"
+)
+
+// ValueRef refers to a value left on disk by ReadRefs.  Offset and Length
+// locate the record's stored data in the file, which includes any
+// checksum or expiry time stripped by Load.
+type ValueRef struct {
+	Offset, Length uint64
+
+	c *Reader
+}
+
+// Load reads the value from the database, as a lookup would.
+func (v ValueRef) Load() ([]byte, error) {
+	return v.c.readValue(v.Offset, v.Length, nil)
+}
+
+// ReadRefs is like Read, but keeps only the keys in memory: each value is
+// returned as a ValueRef to be loaded from r when needed, so r must remain
+// open while they are used.
+func ReadRefs(r io.ReaderAt) (map[string][]ValueRef, error) {
+	c, err := New(r)
+	if err != nil {
+		return nil, err
+	}
+
+	return c.Refs()
+}
+
+// Refs returns every key in the database with references to its values,
+// in the order they were written, as ReadRefs does.  Expired values are
+// not filtered out; Load returns them like any other.
+func (c *Reader) Refs() (map[string][]ValueRef, error) {
+	m := make(map[string][]ValueRef)
+	err := scanKeys(c.r, c.format, c.sod, c.eod, func(pos uint64, key []byte, dlen uint64) error {
+		k := string(key)
+		m[k] = append(m[k], ValueRef{pos, dlen, c})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// scanKeys is like scanRecords, but reads each key and passes it to fn
+// with the position and length of the record's data.  The key is only
+// valid until fn returns.
+func scanKeys(r io.ReaderAt, f Format, start, eod uint64, fn func(pos uint64, key []byte, dlen uint64) error) error {
+	if eod < start {
+		return corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", eod)
+	}
+
+	rb := bufio.NewReader(io.NewSectionReader(r, int64(start), int64(eod-start)))
+	n := uint64(f.numSize())
+	buf := make([]byte, 2*n)
+	var key []byte
+	for pos := start; pos < eod; {
+		if _, err := io.ReadFull(rb, buf); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+		if rem := eod - pos - 2*n; klen > rem || dlen > rem-klen {
+			return corruptf(ErrCorruptRecord, "record at %d runs past the data section", pos)
+		}
+
+		var err error
+		if key, err = readFull(rb, key, klen); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+		if _, err = rb.Discard(int(dlen)); err != nil {
+			return corrupt(ErrCorruptRecord, err)
+		}
+
+		if err = fn(pos+2*n+klen, key, dlen); err != nil {
+			return err
+		}
+		pos += 2*n + klen + dlen
+	}
+
+	return nil
+}
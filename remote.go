@@ -0,0 +1,185 @@
+package cdbmap
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// DefaultBlockSize is the size of the blocks an HTTPReaderAt fetches and
+// caches when none is given.
+const DefaultBlockSize = 64 << 10
+
+// HTTPReaderAt is an io.ReaderAt for a file served over HTTP, such as a
+// database in S3 or another object store, read with Range requests so
+// that a Reader can look keys up without downloading the whole file.
+// Reads are made in whole blocks, and the most recently used blocks are
+// cached; the hash table slots and records of hot keys are then read from
+// memory.  An HTTPReaderAt is safe for concurrent use.
+type HTTPReaderAt struct {
+	url     string
+	client  *http.Client
+	prepare func(req *http.Request) error
+	size    int64
+
+	blockSize int64
+	maxBlocks int
+
+	mu     sync.Mutex
+	lru    *list.List // of *httpBlock, most recently used first
+	blocks map[int64]*list.Element
+}
+
+type httpBlock struct {
+	n    int64 // block number
+	data []byte
+}
+
+// NewHTTPReaderAt returns an HTTPReaderAt for url that reads blocks of
+// blockSize bytes and caches up to cacheBlocks of them.  If client is nil,
+// http.DefaultClient is used; if blockSize is 0, DefaultBlockSize.
+//
+// prepare, if not nil, is called on every request before it is sent, for
+// example to add an Authorization header or to sign the request for an
+// S3-compatible store; presigned URLs need none.  The size of the file is
+// fetched with a one-byte Range request, which, unlike HEAD, a presigned
+// GET URL allows.
+func NewHTTPReaderAt(url string, client *http.Client, blockSize, cacheBlocks int, prepare func(req *http.Request) error) (*HTTPReaderAt, error) {
+	if client == nil {
+		client = http.DefaultClient
+	}
+	if blockSize <= 0 {
+		blockSize = DefaultBlockSize
+	}
+	h := &HTTPReaderAt{
+		url:       url,
+		client:    client,
+		prepare:   prepare,
+		blockSize: int64(blockSize),
+		maxBlocks: cacheBlocks,
+		lru:       list.New(),
+		blocks:    make(map[int64]*list.Element),
+	}
+
+	_, size, err := h.fetch(0, 1)
+	if err != nil {
+		return nil, err
+	}
+	h.size = size
+
+	return h, nil
+}
+
+// OpenHTTP returns a Reader for the cdb at url, read through an
+// HTTPReaderAt with the default block size and a cache of 256 blocks.
+func OpenHTTP(url string) (*Reader, error) {
+	h, err := NewHTTPReaderAt(url, nil, 0, 256, nil)
+	if err != nil {
+		return nil, err
+	}
+	return New(h)
+}
+
+// Size returns the size of the file.
+func (h *HTTPReaderAt) Size() int64 {
+	return h.size
+}
+
+// ReadAt reads len(p) bytes at off, fetching the blocks that are not
+// cached.
+func (h *HTTPReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off < 0 {
+		return 0, fmt.Errorf("negative offset %d", off)
+	}
+
+	var n int
+	for n < len(p) {
+		pos := off + int64(n)
+		if pos >= h.size {
+			return n, io.EOF
+		}
+		data, err := h.block(pos / h.blockSize)
+		if err != nil {
+			return n, err
+		}
+		n += copy(p[n:], data[pos%h.blockSize:])
+	}
+
+	return n, nil
+}
+
+// block returns block i from the cache or the server.
+func (h *HTTPReaderAt) block(i int64) ([]byte, error) {
+	h.mu.Lock()
+	if el, ok := h.blocks[i]; ok {
+		h.lru.MoveToFront(el)
+		h.mu.Unlock()
+		return el.Value.(*httpBlock).data, nil
+	}
+	h.mu.Unlock()
+
+	start := i * h.blockSize
+	end := start + h.blockSize
+	if end > h.size {
+		end = h.size
+	}
+	data, _, err := h.fetch(start, end-start)
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) != end-start {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.blocks[i]; !ok && h.maxBlocks > 0 {
+		h.blocks[i] = h.lru.PushFront(&httpBlock{i, data})
+		for h.lru.Len() > h.maxBlocks {
+			old := h.lru.Remove(h.lru.Back()).(*httpBlock)
+			delete(h.blocks, old.n)
+		}
+	}
+
+	return data, nil
+}
+
+// fetch requests n bytes at off and returns them with the total size of
+// the file from the Content-Range header.
+func (h *HTTPReaderAt) fetch(off, n int64) ([]byte, int64, error) {
+	req, err := http.NewRequest("GET", h.url, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-%d", off, off+n-1))
+	if h.prepare != nil {
+		if err = h.prepare(req); err != nil {
+			return nil, 0, err
+		}
+	}
+
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusPartialContent {
+		return nil, 0, fmt.Errorf("%s: range request returned %s", h.url, resp.Status)
+	}
+
+	// Content-Range: bytes first-last/size
+	cr := resp.Header.Get("Content-Range")
+	i := strings.LastIndexByte(cr, '/')
+	size, err := strconv.ParseInt(cr[i+1:], 10, 64)
+	if i < 0 || err != nil {
+		return nil, 0, fmt.Errorf("%s: bad Content-Range %q", h.url, cr)
+	}
+
+	data, err := io.ReadAll(io.LimitReader(resp.Body, n))
+	return data, size, err
+}
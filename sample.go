@@ -0,0 +1,135 @@
+package cdbmap
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// Sample returns a uniform random sample of n records, without
+// replacement, chosen with a random source seeded with seed, so the same
+// seed gives the same sample.  Records are returned in the order they
+// appear in the database, with values decoded as lookups decode them;
+// expired records are not filtered out.  If the database has n records or
+// fewer, all of them are returned.
+//
+// Every record has exactly one hash table slot, so Sample picks random
+// slots and reads only the records they point to, without scanning the
+// data section.  When n is close to the number of records, or the tables
+// are too sparse for that to converge, it falls back to reservoir sampling
+// over a full scan.
+func (c *Reader) Sample(n int, seed int64) ([]Record, error) {
+	if n <= 0 {
+		return nil, nil
+	}
+	rng := rand.New(rand.NewSource(seed))
+
+	// Writers make twice as many slots as records, so a quarter of the
+	// slots is at most half the records.
+	var nslots uint64
+	for _, t := range c.tables {
+		nslots += t.nslots
+	}
+	if uint64(n) <= nslots/4 {
+		recs, err := c.sampleSlots(rng, n, nslots)
+		if err != nil || recs != nil {
+			return recs, err
+		}
+	}
+
+	return c.sampleScan(rng, n)
+}
+
+// sampleSlots picks n used slots at random from the nslots in the hash
+// tables and reads their records.  It returns nil if too many of the slots
+// it tries are empty.
+func (c *Reader) sampleSlots(rng *rand.Rand, n int, nslots uint64) ([]Record, error) {
+	f, size := c.format, uint64(2*c.format.numSize())
+	buf := make([]byte, size)
+	picked := make(map[uint64]bool, n)
+	var positions []uint64
+	for tries := 0; len(positions) < n; tries++ {
+		if tries > 100*n {
+			return nil, nil
+		}
+
+		s := uint64(rng.Int63n(int64(nslots)))
+		if picked[s] {
+			continue
+		}
+		picked[s] = true
+
+		i, j := 0, s
+		for j >= c.tables[i].nslots {
+			j -= c.tables[i].nslots
+			i++
+		}
+		if _, err := c.r.ReadAt(buf, int64(c.tables[i].pos+j*size)); err != nil {
+			return nil, corrupt(ErrCorruptHeader, err)
+		}
+		if pos := f.getNum(buf[size/2:]); pos != 0 {
+			positions = append(positions, pos)
+		}
+	}
+
+	sort.Slice(positions, func(i, j int) bool { return positions[i] < positions[j] })
+	recs := make([]Record, len(positions))
+	for i, pos := range positions {
+		if _, err := c.r.ReadAt(buf, int64(pos)); err != nil {
+			return nil, corrupt(ErrCorruptRecord, err)
+		}
+		klen, dlen := f.getNum(buf), f.getNum(buf[size/2:])
+		if klen+dlen < klen {
+			return nil, corruptf(ErrCorruptRecord, "record at %d has impossible lengths", pos)
+		}
+		if err := checkRecordSize(pos, klen+dlen, c.opts.MaxRecordSize); err != nil {
+			return nil, err
+		}
+		rec, err := readFullAt(c.r, nil, pos+size, klen+dlen)
+		if err != nil {
+			return nil, corrupt(ErrCorruptRecord, err)
+		}
+		value, err := c.value(rec[klen:])
+		if err != nil {
+			return nil, err
+		}
+		recs[i] = Record{rec[:klen], value}
+	}
+
+	return recs, nil
+}
+
+// sampleScan picks n records by reservoir sampling over every record.
+func (c *Reader) sampleScan(rng *rand.Rand, n int) ([]Record, error) {
+	type sampled struct {
+		i   int
+		rec Record
+	}
+	var reservoir []sampled
+	i := 0
+	err := c.Iterate(func(key, value []byte) error {
+		j := i
+		if j >= n {
+			j = rng.Intn(i + 1)
+		}
+		if j < n {
+			rec := Record{append([]byte(nil), key...), append([]byte(nil), value...)}
+			if j == len(reservoir) {
+				reservoir = append(reservoir, sampled{i, rec})
+			} else {
+				reservoir[j] = sampled{i, rec}
+			}
+		}
+		i++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(reservoir, func(a, b int) bool { return reservoir[a].i < reservoir[b].i })
+	recs := make([]Record, len(reservoir))
+	for k, s := range reservoir {
+		recs[k] = s.rec
+	}
+	return recs, nil
+}
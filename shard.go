@@ -0,0 +1,192 @@
+package cdbmap
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Manifest describes a sharded database: the cdb files holding its shards,
+// in shard order.  Shard names are relative to the manifest's directory.
+// A key is stored in shard HashFNV1a(key) % len(Shards); a different hash
+// from the cdb one is used so that each shard's keys still spread over all
+// 256 of its hash tables.
+type Manifest struct {
+	Shards []string `json:"shards"`
+}
+
+// shardFor returns the shard holding key in a database of n shards.
+func shardFor(key []byte, n int) int {
+	return int(HashFNV1a(key) % uint32(n))
+}
+
+// ShardedWriter writes a database split across several standard cdb files,
+// so its total size is not limited to the 4 gigabytes of one file.
+type ShardedWriter struct {
+	manifest string
+	files    []*os.File
+	writers  []*Writer
+	names    []string
+}
+
+// CreateSharded starts writing a database of n shards described by the
+// manifest file named manifest.  Shard i is written to the file named
+// manifest plus ".i".  Each shard is built in a temporary file and renamed
+// into place by Close, which writes the manifest last.
+func CreateSharded(manifest string, n int) (sw *ShardedWriter, err error) {
+	if n < 1 {
+		return nil, fmt.Errorf("invalid shard count %d", n)
+	}
+
+	sw = &ShardedWriter{manifest: manifest}
+	defer func() {
+		if err != nil {
+			sw.abort()
+		}
+	}()
+
+	dir, base := filepath.Split(manifest)
+	if dir == "" {
+		dir = "."
+	}
+	for i := 0; i < n; i++ {
+		name := fmt.Sprintf("%s.%d", base, i)
+		f, err := ioutil.TempFile(dir, name+".tmp")
+		if err != nil {
+			return nil, err
+		}
+		sw.files = append(sw.files, f)
+
+		w, err := NewWriter(f)
+		if err != nil {
+			return nil, err
+		}
+		sw.writers = append(sw.writers, w)
+		sw.names = append(sw.names, name)
+	}
+
+	return sw, nil
+}
+
+// Put writes a record to the shard for key.
+func (sw *ShardedWriter) Put(key, value []byte) error {
+	return sw.writers[shardFor(key, len(sw.writers))].Put(key, value)
+}
+
+// Close finishes every shard, renames them into place and writes the
+// manifest.  If anything fails, the temporary shard files are removed.
+func (sw *ShardedWriter) Close() (err error) {
+	defer func() {
+		if err != nil {
+			sw.abort()
+		}
+	}()
+
+	dir := filepath.Dir(sw.manifest)
+	for i, w := range sw.writers {
+		f := sw.files[i]
+		if err = w.Close(); err != nil {
+			return
+		}
+		if err = f.Chmod(0644); err != nil {
+			return
+		}
+		if err = f.Sync(); err != nil {
+			return
+		}
+		if err = f.Close(); err != nil {
+			return
+		}
+		if err = replaceFile(f.Name(), filepath.Join(dir, sw.names[i])); err != nil {
+			return
+		}
+	}
+	sw.files = nil
+
+	return writeFile(sw.manifest, func(f *os.File) error {
+		return json.NewEncoder(f).Encode(Manifest{Shards: sw.names})
+	})
+}
+
+// abort removes the temporary shard files still left.
+func (sw *ShardedWriter) abort() {
+	for _, f := range sw.files {
+		f.Close()
+		os.Remove(f.Name())
+	}
+	sw.files = nil
+}
+
+// ShardedReader looks up keys in a database written by ShardedWriter.  Like
+// Reader, it is safe for concurrent use.
+type ShardedReader struct {
+	shards []*Reader
+}
+
+// OpenSharded opens the sharded database described by the named manifest.
+// The ShardedReader should be closed with Close when no longer needed.
+func OpenSharded(manifest string) (*ShardedReader, error) {
+	b, err := ioutil.ReadFile(manifest)
+	if err != nil {
+		return nil, err
+	}
+	var m Manifest
+	if err = json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	if len(m.Shards) == 0 {
+		return nil, fmt.Errorf("manifest %s lists no shards", manifest)
+	}
+
+	sr := &ShardedReader{}
+	dir := filepath.Dir(manifest)
+	for _, name := range m.Shards {
+		c, err := Open(filepath.Join(dir, name))
+		if err != nil {
+			sr.Close()
+			return nil, err
+		}
+		sr.shards = append(sr.shards, c)
+	}
+
+	return sr, nil
+}
+
+// Close closes every shard.
+func (sr *ShardedReader) Close() error {
+	var err error
+	for _, c := range sr.shards {
+		if e := c.Close(); e != nil && err == nil {
+			err = e
+		}
+	}
+	return err
+}
+
+// shard returns the Reader for the shard holding key.
+func (sr *ShardedReader) shard(key []byte) *Reader {
+	return sr.shards[shardFor(key, len(sr.shards))]
+}
+
+// Get returns all values stored under key, as Reader.Get does.
+func (sr *ShardedReader) Get(key string) ([]string, error) {
+	return sr.shard([]byte(key)).Get(key)
+}
+
+// GetFirst returns the first value stored under key, as Reader.GetFirst
+// does.
+func (sr *ShardedReader) GetFirst(key []byte) ([]byte, error) {
+	return sr.shard(key).GetFirst(key)
+}
+
+// GetAll returns all values stored under key, as Reader.GetAll does.
+func (sr *ShardedReader) GetAll(key []byte) ([][]byte, error) {
+	return sr.shard(key).GetAll(key)
+}
+
+// Exists reports whether key is present, as Reader.Exists does.
+func (sr *ShardedReader) Exists(key []byte) (bool, error) {
+	return sr.shard(key).Exists(key)
+}
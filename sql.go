@@ -0,0 +1,136 @@
+package cdbmap
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+)
+
+// ExportSQL copies every record of the cdb in r into table in db, creating
+// the table with k and v BLOB columns if it does not exist.  The rows are
+// inserted in a single transaction with "?" placeholders, as SQLite and
+// MySQL expect.  table must be a plain identifier of ASCII letters, digits
+// and underscores, not starting with a digit, since SQLite and MySQL quote
+// other names differently; it is used unquoted.  A MySQL BLOB holds at most
+// 65535 bytes, so larger records need the table created beforehand with
+// LONGBLOB columns.
+func ExportSQL(db *sql.DB, table string, r io.ReaderAt) (err error) {
+	if !plainIdentifier(table) {
+		return fmt.Errorf("table name %q is not a plain identifier", table)
+	}
+	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS " + table + " (k BLOB, v BLOB)"); err != nil {
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			tx.Rollback()
+		}
+	}()
+
+	stmt, err := tx.Prepare("INSERT INTO " + table + " (k, v) VALUES (?, ?)")
+	if err != nil {
+		return
+	}
+	defer stmt.Close()
+
+	err = Iterate(r, func(key, value []byte) error {
+		_, err := stmt.Exec(key, value)
+		return err
+	})
+	if err != nil {
+		return
+	}
+
+	return tx.Commit()
+}
+
+// plainIdentifier reports whether name can be used unquoted as an SQL
+// identifier.  Reserved words such as KEY are not detected.
+func plainIdentifier(name string) bool {
+	for i, c := range name {
+		switch {
+		case c == '_', 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case '0' <= c && c <= '9' && i > 0:
+		default:
+			return false
+		}
+	}
+	return name != ""
+}
+
+// ImportSQL runs query on db and writes each row of the result, which must
+// have exactly two columns, key then value, as a record of a cdb written
+// to w.  Rows are streamed into the database in the order returned.
+func ImportSQL(w io.WriteSeeker, db *sql.DB, query string) error {
+	rows, err := db.Query(query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if len(cols) != 2 {
+		return fmt.Errorf("query returns %d columns, want 2", len(cols))
+	}
+
+	return WriteFromRows(w, rows, 0, 1)
+}
+
+// WriteFromRows writes a record of a cdb to w for each row remaining in
+// rows, taking the key from column keyCol and the value from column valCol
+// (counting from 0) and ignoring any others.  Rows are streamed into the
+// database in the order returned, without being held in memory; NULL is
+// written as an empty key or value.  The caller still closes rows.  For
+// example, with the MySQL driver:
+//
+//	db, err := sql.Open("mysql", "user:password@/shop")
+//	...
+//	rows, err := db.Query("SELECT id, sku, name FROM products")
+//	...
+//	defer rows.Close()
+//	err = cdbmap.WriteFromRows(f, rows, 1, 2) // sku -> name
+func WriteFromRows(w io.WriteSeeker, rows *sql.Rows, keyCol, valCol int) error {
+	cols, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	if keyCol < 0 || keyCol >= len(cols) || valCol < 0 || valCol >= len(cols) {
+		return fmt.Errorf("columns %d and %d out of range for %d columns", keyCol, valCol, len(cols))
+	}
+
+	// RawBytes avoids copying each column; Put copies the key and value
+	// before the next row replaces them.
+	raw := make([]sql.RawBytes, len(cols))
+	dest := make([]interface{}, len(cols))
+	for i := range raw {
+		dest[i] = &raw[i]
+	}
+
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	for rows.Next() {
+		if err = rows.Scan(dest...); err != nil {
+			return err
+		}
+		if err = cw.Put(raw[keyCol], raw[valCol]); err != nil {
+			return err
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return err
+	}
+
+	return cw.Close()
+}
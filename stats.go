@@ -0,0 +1,135 @@
+package cdbmap
+
+import "io"
+
+// DBStats describes the contents and hash table layout of a database.
+type DBStats struct {
+	Format    Format
+	Records   uint64 // number of records
+	KeyBytes  uint64 // total size of all keys
+	DataBytes uint64 // total size of all values
+	Slots     uint64 // total number of hash table slots
+	Size      uint64 // size of the database file
+
+	// MaxProbe is the greatest number of extra slots any record's lookup
+	// has to probe past its initial slot.
+	MaxProbe uint64
+
+	// Probes counts records by the number of extra slots probed to find
+	// them, as cdbstats's d0 to d9 lines do.  The last element counts all
+	// records needing more than 9 extra probes.
+	Probes [11]uint64
+
+	// HashCollisions counts true hash collisions: for each 32-bit hash,
+	// the number of different keys with that hash beyond the first.  The
+	// values of one key share its hash without colliding.  Unlike
+	// displacement, which any two keys in the same table can cause,
+	// colliding keys cost every lookup of them a key comparison.
+	HashCollisions uint64
+
+	Tables [256]TableStats
+}
+
+// TableStats describes one of the 256 hash tables.
+type TableStats struct {
+	Records uint64 // number of records hashed to this table
+	Slots   uint64 // number of slots in this table
+	Pos     uint64 // position of the table in the file
+}
+
+// Load returns the fraction of the table's slots that are in use.
+func (t TableStats) Load() float64 {
+	if t.Slots == 0 {
+		return 0
+	}
+	return float64(t.Records) / float64(t.Slots)
+}
+
+// Load returns the fraction of all hash table slots that are in use.
+func (s *DBStats) Load() float64 {
+	if s.Slots == 0 {
+		return 0
+	}
+	return float64(s.Records) / float64(s.Slots)
+}
+
+// Stats returns statistics for the cdb in r.  It reads every hash table
+// and every record header, and the keys of records whose hashes are equal,
+// to count HashCollisions, but no values.
+func Stats(r io.ReaderAt) (*DBStats, error) {
+	f, t, err := readHeader(r)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := tableStats(r, f, &t)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.HashCollisions, err = countCollisions(r, f, &t); err != nil {
+		return nil, err
+	}
+
+	start, eod, err := dataSection(r, f, &t)
+	if err != nil {
+		return nil, err
+	}
+	s.Records = 0
+	err = scanRecords(r, f, start, eod, func(pos, klen, dlen uint64) error {
+		s.Records++
+		s.KeyBytes += klen
+		s.DataBytes += dlen
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// Stat returns the hash table layout of the database, as Stats does, but
+// reads only the hash tables, so it is cheap enough to check every
+// database a server opens.  Records is counted from the hash tables;
+// KeyBytes, DataBytes and HashCollisions are left 0.
+func (c *Reader) Stat() (*DBStats, error) {
+	return tableStats(c.r, c.format, &c.tables)
+}
+
+// tableStats fills in the statistics that come from the hash tables t.
+func tableStats(r io.ReaderAt, f Format, t *[256]table) (*DBStats, error) {
+	s := &DBStats{Format: f, Size: tablesEnd(f, t)}
+	if readChecksumTrailer(r, f, t) != nil {
+		s.Size += uint64(checksumTrailerSize)
+	}
+	for i, tab := range t {
+		s.Tables[i].Pos = tab.pos
+		s.Tables[i].Slots = tab.nslots
+		s.Slots += tab.nslots
+	}
+
+	err := walkSlots(r, f, t, func(i int, j, h, pos uint64) error {
+		if pos == 0 {
+			return nil
+		}
+		s.Tables[i].Records++
+		s.Records++
+
+		nslots := t[i].nslots
+		d := (j + nslots - (h/256)%nslots) % nslots
+		if d > s.MaxProbe {
+			s.MaxProbe = d
+		}
+		if d > 9 {
+			d = 10
+		}
+		s.Probes[d]++
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
@@ -0,0 +1,133 @@
+package cdbmap
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"math"
+)
+
+// WriteStream writes the map in m to w like Write, but w need not support
+// Seek, so the database can be written to a pipe, socket or HTTP request
+// body.  Because the header comes first in a cdb, WriteStream makes two
+// passes over m: the first computes every record's position and the hash
+// tables, the second writes the header, records and tables in order.  It
+// returns ErrTooLarge, before writing anything, if the database would
+// exceed 4 gigabytes.
+func WriteStream(m map[string][]string, w io.Writer) (err error) {
+	// Fix the iteration order for both passes.
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+
+	f := Format32
+	n := uint64(f.numSize())
+	pos := f.headerSize()
+	htables := make(map[uint32][]slot)
+	for _, k := range keys {
+		h := checksum([]byte(k))
+		for _, v := range m[k] {
+			size := 2*n + uint64(len(k)) + uint64(len(v))
+			if size > f.maxPos()-pos {
+				return ErrTooLarge
+			}
+			htables[h%256] = append(htables[h%256], slot{h, pos})
+			pos += size
+		}
+	}
+
+	header, err := buildTables(ioutil.Discard, f, htables, pos)
+	if err != nil {
+		return
+	}
+
+	wb := bufio.NewWriter(w)
+	if _, err = wb.Write(header); err != nil {
+		return
+	}
+
+	buf := make([]byte, 2*n)
+	for _, k := range keys {
+		for _, v := range m[k] {
+			f.putNum(buf, uint64(len(k)))
+			f.putNum(buf[n:], uint64(len(v)))
+			wb.Write(buf)
+			wb.WriteString(k)
+			if _, err = wb.WriteString(v); err != nil {
+				return
+			}
+		}
+	}
+
+	if _, err = buildTables(wb, f, htables, pos); err != nil {
+		return
+	}
+
+	return wb.Flush()
+}
+
+// ReadStream returns the map of all the keys/values like Read, but reads
+// the database sequentially from r, so it can be decoded from a pipe,
+// decompressor or HTTP response body without spooling it to disk.  Reading
+// stops at the end of the data section; the hash tables are not consumed,
+// unless the database was written with WriterOptions.IndexFirst, when they
+// are skipped and the records are read to the end of r.
+func ReadStream(r io.Reader) (map[string][]string, error) {
+	m := make(map[string][]string)
+	err := iterateStream(r, func(key, value []byte) error {
+		k := string(key)
+		m[k] = append(m[k], string(value))
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+// iterateStream is like Iterate, but reads the header and data section
+// sequentially from r.
+func iterateStream(r io.Reader, fn func(key, value []byte) error) error {
+	rb := bufio.NewReaderSize(r, int(Format64.headerSize()))
+
+	// Detect the format from as much of the header as is available.
+	header, err := rb.Peek(int(Format64.headerSize()))
+	if len(header) < int(HeaderSize) {
+		return corrupt(ErrCorruptHeader, err)
+	}
+	f, t := detectFormat(header)
+	if _, err = rb.Discard(int(f.headerSize())); err != nil {
+		return err
+	}
+
+	start, eod, err := skipToData(rb, f, &t)
+	if err != nil {
+		return err
+	}
+
+	return readRecords(rb, f, start, eod, 0, fn)
+}
+
+// skipToData positions rb, which has just been read past the header of a
+// database with hash tables t, at its first record, and returns the start
+// and end of the data section.  The hash tables of an index-first database
+// are skipped, and its data section ends at toEOF.
+func skipToData(rb *bufio.Reader, f Format, t *[256]table) (start, eod uint64, err error) {
+	if !f.indexFirst(t) {
+		if t[0].pos < f.headerSize() {
+			return 0, 0, corruptf(ErrCorruptHeader, "data section ends at %d, inside the header", t[0].pos)
+		}
+		return f.headerSize(), t[0].pos, nil
+	}
+
+	start = tablesEnd(f, t)
+	if start < f.headerSize() || start-f.headerSize() > math.MaxInt64 {
+		return 0, 0, corruptf(ErrCorruptHeader, "hash tables are impossibly large")
+	}
+	if _, err = io.CopyN(ioutil.Discard, rb, int64(start-f.headerSize())); err != nil {
+		return 0, 0, corrupt(ErrCorruptHeader, err)
+	}
+	return start, toEOF, nil
+}
@@ -0,0 +1,63 @@
+package cdbmap
+
+import (
+	"errors"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"unsafe"
+)
+
+// oTmpfile is O_TMPFILE, which the syscall package does not define.  Its
+// __O_TMPFILE bit is the same on every architecture Go supports; the
+// O_DIRECTORY bit is not.
+const oTmpfile = 0x400000 | syscall.O_DIRECTORY
+
+// The linkat flags, which the syscall package does not define either.
+const (
+	atFdcwd         = -100
+	atSymlinkFollow = 0x400
+)
+
+// openTmpfile opens an unnamed temporary file in dir with O_TMPFILE.  It
+// fails on kernels and file systems without O_TMPFILE, and the caller then
+// falls back to a named temporary file.
+func openTmpfile(dir string) (*os.File, error) {
+	fd, err := syscall.Open(dir, oTmpfile|syscall.O_RDWR|syscall.O_CLOEXEC, 0644)
+	if err != nil {
+		return nil, &os.PathError{Op: "open", Path: dir, Err: err}
+	}
+	return os.NewFile(uintptr(fd), filepath.Join(dir, "(tmpfile)")), nil
+}
+
+// linkTmpfile gives the file from openTmpfile a unique name in dir
+// starting with base, and returns that name.
+func linkTmpfile(f *os.File, dir, base string) (string, error) {
+	from, err := syscall.BytePtrFromString("/proc/self/fd/" + strconv.Itoa(int(f.Fd())))
+	if err != nil {
+		return "", err
+	}
+
+	for i := 0; i < 100; i++ {
+		name := filepath.Join(dir, base+".tmp"+strconv.Itoa(int(rand.Uint32())))
+		to, err := syscall.BytePtrFromString(name)
+		if err != nil {
+			return "", err
+		}
+
+		fromDir := atFdcwd
+		_, _, errno := syscall.Syscall6(syscall.SYS_LINKAT, uintptr(fromDir), uintptr(unsafe.Pointer(from)),
+			uintptr(fromDir), uintptr(unsafe.Pointer(to)), atSymlinkFollow, 0)
+		switch errno {
+		case 0:
+			return name, nil
+		case syscall.EEXIST:
+			continue
+		}
+		return "", &os.LinkError{Op: "linkat", Old: f.Name(), New: name, Err: errno}
+	}
+
+	return "", &os.PathError{Op: "linkat", Path: dir, Err: errors.New("no unused temporary name")}
+}
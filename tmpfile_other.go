@@ -0,0 +1,18 @@
+//go:build !linux
+
+package cdbmap
+
+import (
+	"errors"
+	"os"
+)
+
+// openTmpfile fails where there is no O_TMPFILE, so that the caller uses a
+// named temporary file.
+func openTmpfile(dir string) (*os.File, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func linkTmpfile(f *os.File, dir, base string) (string, error) {
+	return "", errors.ErrUnsupported
+}
@@ -0,0 +1,59 @@
+package cdbmap
+
+import (
+	"io"
+	"time"
+)
+
+// The operations reported to a Tracer.
+const (
+	TraceOpen   = "open"   // a Reader was created
+	TraceLookup = "lookup" // a Reader looked up a key
+	TraceFlush  = "flush"  // a Writer wrote buffered records out
+	TraceTables = "tables" // a Writer wrote the hash tables and header
+	TraceClose  = "close"  // a Writer finished the database
+)
+
+// TraceEvent describes one thing a Reader or Writer did.  Fields that do
+// not apply to Op are left zero.
+type TraceEvent struct {
+	Op       string        // one of the Trace constants
+	Key      []byte        // the key looked up
+	Found    bool          // whether the key was present
+	Probes   int           // hash table slots read by a lookup
+	Records  uint64        // records written by a Writer
+	Bytes    uint64        // bytes read by a lookup, or written
+	Duration time.Duration // time the operation took
+	Err      error         // the error a flush failed with
+}
+
+// Tracer receives TraceEvents from a Reader created with
+// ReaderOptions.Tracer or a Writer created with WriterOptions.Tracer.
+// Events are reported synchronously by the goroutine doing the work, after
+// the operation finishes, so Trace must be safe for concurrent use and
+// should be quick.  Key is only valid during the call.
+type Tracer interface {
+	Trace(e TraceEvent)
+}
+
+// TracerFunc adapts a function to a Tracer.
+type TracerFunc func(e TraceEvent)
+
+// Trace calls f(e).
+func (f TracerFunc) Trace(e TraceEvent) {
+	f(e)
+}
+
+// traceWriter reports each write the bufio.Writer of a Writer makes to the
+// underlying io.Writer as a TraceFlush event.
+type traceWriter struct {
+	w io.Writer
+	t Tracer
+}
+
+func (tw traceWriter) Write(p []byte) (int, error) {
+	start := time.Now()
+	n, err := tw.w.Write(p)
+	tw.t.Trace(TraceEvent{Op: TraceFlush, Bytes: uint64(n), Duration: time.Since(start), Err: err})
+	return n, err
+}
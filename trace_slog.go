@@ -0,0 +1,47 @@
+//go:build go1.21
+
+package cdbmap
+
+import (
+	"context"
+	"log/slog"
+)
+
+// SlogTracer is a Tracer that logs each event to a slog.Logger, at
+// LevelDebug or LevelError if a flush failed, with the message
+// "cdb " followed by the operation and the fields that apply as
+// attributes.
+type SlogTracer struct {
+	Logger *slog.Logger
+}
+
+// Trace logs e.
+func (t SlogTracer) Trace(e TraceEvent) {
+	level := slog.LevelDebug
+	if e.Err != nil {
+		level = slog.LevelError
+	}
+	ctx := context.Background()
+	if !t.Logger.Enabled(ctx, level) {
+		return
+	}
+
+	attrs := make([]slog.Attr, 0, 7)
+	switch e.Op {
+	case TraceLookup:
+		attrs = append(attrs,
+			slog.String("key", string(e.Key)),
+			slog.Bool("found", e.Found),
+			slog.Int("probes", e.Probes),
+			slog.Uint64("bytes", e.Bytes))
+	case TraceTables, TraceClose:
+		attrs = append(attrs, slog.Uint64("records", e.Records), slog.Uint64("bytes", e.Bytes))
+	case TraceFlush:
+		attrs = append(attrs, slog.Uint64("bytes", e.Bytes))
+	}
+	attrs = append(attrs, slog.Duration("duration", e.Duration))
+	if e.Err != nil {
+		attrs = append(attrs, slog.Any("error", e.Err))
+	}
+	t.Logger.LogAttrs(ctx, level, "cdb "+e.Op, attrs...)
+}
@@ -0,0 +1,171 @@
+package cdbmap
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"encoding/json"
+	"io"
+)
+
+// Codec converts values of type T to and from the bytes stored in a cdb.
+type Codec[T any] interface {
+	Encode(v T) ([]byte, error)
+	Decode(b []byte) (T, error)
+}
+
+// CodecFuncs adapts a pair of functions to a Codec.
+type CodecFuncs[T any] struct {
+	EncodeFunc func(v T) ([]byte, error)
+	DecodeFunc func(b []byte) (T, error)
+}
+
+func (c CodecFuncs[T]) Encode(v T) ([]byte, error) { return c.EncodeFunc(v) }
+func (c CodecFuncs[T]) Decode(b []byte) (T, error) { return c.DecodeFunc(b) }
+
+// StringCodec stores strings as their bytes.
+type StringCodec struct{}
+
+func (StringCodec) Encode(v string) ([]byte, error) { return []byte(v), nil }
+func (StringCodec) Decode(b []byte) (string, error) { return string(b), nil }
+
+// Uint64Codec stores integers as 8 big-endian bytes.
+type Uint64Codec struct{}
+
+func (Uint64Codec) Encode(v uint64) ([]byte, error) {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, v)
+	return b, nil
+}
+
+func (Uint64Codec) Decode(b []byte) (uint64, error) {
+	if len(b) != 8 {
+		return 0, BadFormatError
+	}
+	return binary.BigEndian.Uint64(b), nil
+}
+
+// JSONCodec stores values as JSON.
+type JSONCodec[T any] struct{}
+
+func (JSONCodec[T]) Encode(v T) ([]byte, error) { return json.Marshal(v) }
+
+func (JSONCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := json.Unmarshal(b, &v)
+	return v, err
+}
+
+// GobCodec stores values with encoding/gob.  Each value is encoded on its
+// own, type information included, so it can be decoded without the rest of
+// the database; this makes gob values larger than JSON ones for small
+// structs.
+type GobCodec[T any] struct{}
+
+func (GobCodec[T]) Encode(v T) ([]byte, error) {
+	var b bytes.Buffer
+	err := gob.NewEncoder(&b).Encode(v)
+	return b.Bytes(), err
+}
+
+func (GobCodec[T]) Decode(b []byte) (T, error) {
+	var v T
+	err := gob.NewDecoder(bytes.NewReader(b)).Decode(&v)
+	return v, err
+}
+
+// Map reads and writes databases of typed keys and values, converting
+// them with Keys and Values.  For example, a map[uint64][]Item can be
+// stored with
+//
+//	m := cdbmap.Map[uint64, Item]{cdbmap.Uint64Codec{}, cdbmap.JSONCodec[Item]{}}
+//	err := m.Write(items, w)
+type Map[K comparable, V any] struct {
+	Keys   Codec[K]
+	Values Codec[V]
+}
+
+// Read returns all the keys/values in r, decoded.
+func (m Map[K, V]) Read(r io.ReaderAt) (map[K][]V, error) {
+	out := make(map[K][]V)
+	err := Iterate(r, func(key, value []byte) error {
+		k, err := m.Keys.Decode(key)
+		if err != nil {
+			return err
+		}
+		v, err := m.Values.Decode(value)
+		if err != nil {
+			return err
+		}
+		out[k] = append(out[k], v)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return out, nil
+}
+
+// Write encodes the map in data and writes it to w.
+func (m Map[K, V]) Write(data map[K][]V, w io.WriteSeeker) error {
+	cw, err := NewWriter(w)
+	if err != nil {
+		return err
+	}
+	defer cw.Abort()
+
+	for k, values := range data {
+		key, err := m.Keys.Encode(k)
+		if err != nil {
+			return err
+		}
+		for _, v := range values {
+			value, err := m.Values.Encode(v)
+			if err != nil {
+				return err
+			}
+			if err = cw.Put(key, value); err != nil {
+				return err
+			}
+		}
+	}
+
+	return cw.Close()
+}
+
+// Get looks key up in c and returns its decoded values.  It returns ErrNotFound
+// if the key does not exist.
+func (m Map[K, V]) Get(c *Reader, key K) ([]V, error) {
+	k, err := m.Keys.Encode(key)
+	if err != nil {
+		return nil, err
+	}
+
+	values, err := c.GetAll(k)
+	if err != nil {
+		return nil, err
+	}
+
+	out := make([]V, len(values))
+	for i, value := range values {
+		if out[i], err = m.Values.Decode(value); err != nil {
+			return nil, err
+		}
+	}
+
+	return out, nil
+}
+
+// WriteStructs writes a map of string keys to structured values, encoded
+// with GobCodec.  Use a Map with JSONCodec instead to honour json struct
+// tags or to share the database with programs not written in Go.
+func WriteStructs[T any](data map[string][]T, w io.WriteSeeker) error {
+	return Map[string, T]{StringCodec{}, GobCodec[T]{}}.Write(data, w)
+}
+
+// ReadStructs returns all the keys/values in a database written by
+// WriteStructs, decoded.
+func ReadStructs[T any](r io.ReaderAt) (map[string][]T, error) {
+	return Map[string, T]{StringCodec{}, GobCodec[T]{}}.Read(r)
+}
@@ -0,0 +1,41 @@
+package cdbmap
+
+// Values maps keys to their values, like url.Values and http.Header, with
+// methods for the common single-value cases.  It has the same underlying
+// type as the map Read returns and Write takes, so one converts to the
+// other for free:
+//
+//	m, err := cdbmap.Read(r)
+//	v := cdbmap.Values(m)
+//	lang := v.Get("lang")
+//	err = cdbmap.Write(v, w)
+type Values map[string][]string
+
+// Get returns the first value stored under key, or "" if there is none.
+// Use the map directly to get all of them.
+func (v Values) Get(key string) string {
+	if vs := v[key]; len(vs) > 0 {
+		return vs[0]
+	}
+	return ""
+}
+
+// Set replaces the values stored under key with value.
+func (v Values) Set(key, value string) {
+	v[key] = []string{value}
+}
+
+// Add appends value to the values stored under key.
+func (v Values) Add(key, value string) {
+	v[key] = append(v[key], value)
+}
+
+// Del removes key and all its values.
+func (v Values) Del(key string) {
+	delete(v, key)
+}
+
+// Has reports whether key has any values.
+func (v Values) Has(key string) bool {
+	return len(v[key]) > 0
+}
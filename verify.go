@@ -0,0 +1,180 @@
+package cdbmap
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Verify checks the integrity of the cdb in r.  It walks the header, all
+// 256 hash tables and the data section, and checks that every used slot
+// points at a record in the data section whose key hashes to the slot's
+// hash and that a lookup of the key reaches, that no pointer or length
+// runs outside the database, and that the hash tables reference as many
+// records as the data section holds.  Where keys share a hash, each
+// key's values must be probed in the order they were written.
+// Keys are checked against the standard cdb hash.  Problems are reported
+// as errors wrapping ErrCorruptHeader or ErrCorruptRecord.
+func Verify(r io.ReaderAt) error {
+	f, t, err := readHeader(r)
+	if err != nil {
+		return err
+	}
+
+	sod, eod, err := dataSection(r, f, &t)
+	if err != nil {
+		return err
+	}
+
+	// Walk the data section, which also checks that every record lies
+	// within it.
+	var nrecs uint64
+	err = scanRecords(r, f, sod, eod, func(pos, klen, dlen uint64) error {
+		nrecs++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	size := readerSize(r)
+	n := f.numSize()
+	slotSize := 2 * uint64(n)
+	buf := make([]byte, slotSize)
+	var kbuf []byte
+	var nslots uint64
+	var chain []probeSlot
+	for i, tab := range t {
+		if tab.pos < eod && !f.indexFirst(&t) {
+			return corruptf(ErrCorruptHeader, "table %d at %d overlaps the data section", i, tab.pos)
+		}
+		if tab.nslots == 0 {
+			continue
+		}
+		// Make sure the whole table is present before allocating for it.
+		if !f.tableFits(tab, size) {
+			return corruptf(ErrCorruptHeader, "table %d at %d with %d slots runs past the end of the file", i, tab.pos, tab.nslots)
+		}
+		if _, err := r.ReadAt(buf[:1], int64(tab.pos+tab.nslots*slotSize-1)); err != nil {
+			return fmt.Errorf("table %d: %w", i, corrupt(ErrCorruptHeader, err))
+		}
+
+		used := make([]bool, tab.nslots)
+		chain = chain[:0]
+		for j := uint64(0); j < tab.nslots; j++ {
+			if _, err := r.ReadAt(buf, int64(tab.pos+j*slotSize)); err != nil {
+				return fmt.Errorf("table %d slot %d: %w", i, j, corrupt(ErrCorruptHeader, err))
+			}
+			h, pos := f.getNum(buf), f.getNum(buf[n:])
+			if pos == 0 {
+				continue
+			}
+			nslots++
+			used[j] = true
+			chain = append(chain, probeSlot{uint32(h), (j + tab.nslots - (h/256)%tab.nslots) % tab.nslots, pos})
+
+			if h%256 != uint64(i) {
+				return corruptf(ErrCorruptHeader, "table %d slot %d: hash %#x belongs in table %d", i, j, h, h%256)
+			}
+			if pos < sod || pos+slotSize > eod {
+				return corruptf(ErrCorruptHeader, "table %d slot %d: record pointer %d outside data section", i, j, pos)
+			}
+
+			if _, err := r.ReadAt(buf, int64(pos)); err != nil {
+				return fmt.Errorf("record at %d: %w", pos, corrupt(ErrCorruptRecord, err))
+			}
+			klen, dlen := f.getNum(buf), f.getNum(buf[n:])
+			if rem := eod - pos - slotSize; klen > rem || dlen > rem-klen {
+				return corruptf(ErrCorruptRecord, "record at %d runs past the data section", pos)
+			}
+
+			if kbuf, err = readFullAt(r, kbuf, pos+slotSize, klen); err != nil {
+				return fmt.Errorf("record at %d: %w", pos, corrupt(ErrCorruptRecord, err))
+			}
+			if kh := checksum(kbuf); uint64(kh) != h {
+				return corruptf(ErrCorruptHeader, "record at %d: key %q hashes to %#x, slot has %#x", pos, kbuf, kh, h)
+			}
+		}
+
+		if err := checkChains(r, f, i, used, chain); err != nil {
+			return err
+		}
+	}
+
+	if nslots != nrecs {
+		return corruptf(ErrCorruptHeader, "%d records in data section, but %d referenced by hash tables", nrecs, nslots)
+	}
+
+	return nil
+}
+
+// checkChains checks that lookups in table i, whose slots are marked in
+// used, find every record the table holds: that no empty slot lies between
+// a record's slot and the slot its hash starts probing from, and that the
+// values of each key, which share a hash, are probed in the order they
+// were written.  chain holds the used slots.
+func checkChains(r io.ReaderAt, f Format, i int, used []bool, chain []probeSlot) error {
+	// Count the used slots running up to each slot, starting after an
+	// empty one.  A full table has no empty slot to stop a probe.
+	nslots := uint64(len(used))
+	empty := -1
+	for j, u := range used {
+		if !u {
+			empty = j
+			break
+		}
+	}
+	if empty >= 0 {
+		run := make([]uint64, nslots)
+		for k := uint64(1); k <= nslots; k++ {
+			j := (uint64(empty) + k) % nslots
+			if used[j] {
+				run[j] = run[(j+nslots-1)%nslots] + 1
+			}
+		}
+		for _, s := range chain {
+			j := (uint64(s.h/256)%nslots + s.dist) % nslots
+			if s.dist >= run[j] {
+				return corruptf(ErrCorruptHeader, "table %d slot %d: record at %d is past an empty slot, so lookups cannot reach it", i, j, s.pos)
+			}
+		}
+	}
+
+	return collisionChains(r, f, chain, func(keys [][]byte, pos []uint64) error {
+		last := make(map[string]uint64, len(keys))
+		for k, key := range keys {
+			if prev, ok := last[string(key)]; ok && prev > pos[k] {
+				return corruptf(ErrCorruptHeader, "table %d: values of key %q at %d and %d are probed out of order", i, key, prev, pos[k])
+			}
+			last[string(key)] = pos[k]
+		}
+		return nil
+	})
+}
+
+// VerifyFile is a convenience function that runs Verify on the named file.
+func VerifyFile(filename string) error {
+	f, err := openFile(filename)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return Verify(f)
+}
+
+// readerSize returns the size of r if it can tell, as bytes.Reader,
+// io.SectionReader and os.File can, or else math.MaxInt64, the largest
+// offset ReadAt accepts.
+func readerSize(r io.ReaderAt) uint64 {
+	switch r := r.(type) {
+	case interface{ Size() int64 }:
+		return uint64(r.Size())
+	case *os.File:
+		if fi, err := r.Stat(); err == nil && fi.Mode().IsRegular() {
+			return uint64(fi.Size())
+		}
+	}
+	return math.MaxInt64
+}
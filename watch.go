@@ -0,0 +1,146 @@
+package cdbmap
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// WatchingReader serves lookups from a cdb file and reopens it when the
+// file is replaced, as ToFile and Update do by renaming a new database over
+// the old one.  The file is checked with os.Stat every interval, so
+// long-running servers pick up rebuilt databases without restarting.
+//
+// Lookups in progress finish against the database they started on; the old
+// file is closed once they have.  A WatchingReader is safe for concurrent
+// use.
+type WatchingReader struct {
+	filename string
+
+	mu  sync.RWMutex
+	c   *Reader
+	fi  os.FileInfo
+	gen uint64 // counts reopens, so caches can tell the database changed
+
+	errMu sync.Mutex
+	err   error
+
+	done chan struct{}
+	wg   sync.WaitGroup
+}
+
+// Watch opens the named cdb file and checks it for replacement every
+// interval.  If interval is 0 the file is only checked when Reload is
+// called, for example on a signal.  The WatchingReader should be closed
+// with Close when no longer needed.
+func Watch(filename string, interval time.Duration) (*WatchingReader, error) {
+	w := &WatchingReader{filename: filename, done: make(chan struct{})}
+	if err := w.Reload(); err != nil {
+		return nil, err
+	}
+
+	if interval > 0 {
+		w.wg.Add(1)
+		go w.watch(interval)
+	}
+
+	return w, nil
+}
+
+func (w *WatchingReader) watch(interval time.Duration) {
+	defer w.wg.Done()
+
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	for {
+		select {
+		case <-w.done:
+			return
+		case <-t.C:
+			err := w.Reload()
+			w.errMu.Lock()
+			w.err = err
+			w.errMu.Unlock()
+		}
+	}
+}
+
+// Reload reopens the file now if it has been replaced or modified since it
+// was last opened.  If reopening fails, the current database stays in use
+// and the error is returned.
+func (w *WatchingReader) Reload() error {
+	fi, err := os.Stat(w.filename)
+	if err != nil {
+		return err
+	}
+
+	w.mu.RLock()
+	same := w.fi != nil && os.SameFile(fi, w.fi) && fi.ModTime().Equal(w.fi.ModTime()) && fi.Size() == w.fi.Size()
+	w.mu.RUnlock()
+	if same {
+		return nil
+	}
+
+	c, err := Open(w.filename)
+	if err != nil {
+		return err
+	}
+
+	w.mu.Lock()
+	old := w.c
+	w.c, w.fi = c, fi
+	w.gen++
+	w.mu.Unlock()
+
+	if old != nil {
+		return old.Close()
+	}
+	return nil
+}
+
+// Err returns the error from the last background check, or nil if it
+// succeeded.
+func (w *WatchingReader) Err() error {
+	w.errMu.Lock()
+	defer w.errMu.Unlock()
+	return w.err
+}
+
+// Close stops watching and closes the file.
+func (w *WatchingReader) Close() error {
+	close(w.done)
+	w.wg.Wait()
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.c.Close()
+}
+
+// Get returns all values stored under key, as Reader.Get does.
+func (w *WatchingReader) Get(key string) ([]string, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.c.Get(key)
+}
+
+// GetFirst returns the first value stored under key, as Reader.GetFirst
+// does.
+func (w *WatchingReader) GetFirst(key []byte) ([]byte, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.c.GetFirst(key)
+}
+
+// GetAll returns all values stored under key, as Reader.GetAll does.
+func (w *WatchingReader) GetAll(key []byte) ([][]byte, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.c.GetAll(key)
+}
+
+// Exists reports whether key is present, as Reader.Exists does.
+func (w *WatchingReader) Exists(key []byte) (bool, error) {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.c.Exists(key)
+}
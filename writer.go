@@ -0,0 +1,682 @@
+package cdbmap
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"os"
+	"slices"
+	"time"
+	"unicode/utf8"
+)
+
+// Writer streams records to a cdb.  Only the hash table slots are kept in
+// memory, so databases can be built without holding every record at once.
+// Close must be called to write the hash tables and header; the database
+// is incomplete until it returns.
+type Writer struct {
+	w       io.WriteSeeker
+	wb      *bufio.Writer
+	format  Format
+	htables map[uint32][]slot
+	pos     uint64
+	rec     []byte      // scratch space for serializing a record
+	sum     hash.Hash32 // CRC-32 of everything after the header, if checksumming
+	hashKey func(key []byte) uint32
+	expiry  bool
+	keyFunc func(key []byte) []byte
+
+	packed bool   // values are packed, see WriterOptions.PackValues
+	pack   []byte // scratch space for packing values
+
+	// With WriterOptions.CompressValues, records are held until
+	// enough values have been sampled to train comp's dictionary, which
+	// is stored under dictKey.
+	compress bool
+	comp     *valueCompressor
+	dictKey  []byte
+	held     []heldRecord
+	sampled  int
+	cbuf     []byte // scratch space for compressing values
+
+	index io.Writer           // prefix index, if writing one
+	keys  map[string]struct{} // distinct keys, for the prefix index
+
+	bloom     io.Writer // bloom filter, if writing one
+	bloomRate float64
+
+	maxKeyLen   int
+	maxValueLen int
+	utf8Keys    bool
+
+	par *parallelWriter // set if WriterOptions.Parallelism is above 1
+
+	// spool holds the records until Close, with WriterOptions.IndexFirst.
+	spool *os.File
+
+	onProgress func(records, bytes uint64)
+	tracer     Tracer
+	nrecs      uint64
+	start      time.Time
+	stats      BuildStats
+}
+
+// WriterOptions configures a Writer.
+type WriterOptions struct {
+	// Format selects the on-disk layout.  The zero value is the
+	// standard Format32.
+	Format Format
+
+	// Checksum appends a CRC-32 of each value to the value, and a trailer
+	// holding checksums of the whole file after the hash tables.  Readers
+	// detect the trailer, strip the per-value checksums and verify them.
+	// Other cdb tools can still read the file, but see the checksums as
+	// the last 4 bytes of every value.
+	Checksum bool
+
+	// Hash hashes keys.  It defaults to the standard cdb hash; anything
+	// else produces a database that only a Reader given the same
+	// ReaderOptions.Hash can look keys up in.
+	Hash func(key []byte) uint32
+
+	// Expiry prefixes each value with an 8-byte expiry time, set by
+	// PutExpiring; values written by Put never expire.  The database must
+	// be read with ReaderOptions.Expiry set, or the prefix is returned as
+	// part of each value.
+	Expiry bool
+
+	// KeyTransform, if set, normalizes every key before it is written,
+	// for example with bytes.ToLower.  Readers should be given the same
+	// ReaderOptions.KeyTransform so that lookups are normalized too.
+	KeyTransform func(key []byte) []byte
+
+	// OnProgress, if set, is called with the number of records and bytes
+	// written so far after every ProgressInterval records, and once more
+	// when Close has written the whole database.
+	OnProgress func(recordsWritten, bytesWritten uint64)
+
+	// PrefixIndex, if set, receives a sorted index of the distinct keys
+	// when Close is called, to be stored alongside the database (for
+	// example as file.cdb.idx) and loaded with ReaderOptions.PrefixIndex
+	// for Reader.PrefixScan.  The keys are kept in memory until then.
+	PrefixIndex io.Writer
+
+	// BloomFilter, if set, receives a bloom filter of the keys when Close
+	// is called, to be stored alongside the database (for example as
+	// file.cdb.bf) and loaded with ReaderOptions.BloomFilter.  Its size is
+	// chosen for BloomFalsePositiveRate, which defaults to
+	// DefaultBloomFalsePositiveRate; 1% takes about 10 bits per record.
+	BloomFilter            io.Writer
+	BloomFalsePositiveRate float64
+
+	// MaxKeyLen and MaxValueLen, if not 0, are the longest key and value
+	// in bytes that Put accepts; longer ones are rejected with
+	// ErrKeyTooLong or ErrValueTooLong, naming the key.  ValidateUTF8Keys
+	// rejects keys that are not valid UTF-8 with ErrInvalidKey.  Keys are
+	// checked after KeyTransform.
+	MaxKeyLen        int
+	MaxValueLen      int
+	ValidateUTF8Keys bool
+
+	// Parallelism, if above 1, is the number of goroutines that hash and
+	// serialize records.  Put then copies each record into a batch and
+	// returns; batches are written in order by another goroutine, so an
+	// error writing a record is returned by a later Put or by Close, and
+	// Close must always be called.  Hash must be safe for concurrent use,
+	// and OnProgress is called from the writing goroutine.
+	Parallelism int
+
+	// PackValues stores the values given to each PutValues call as one
+	// record holding every value prefixed with its length as a uvarint,
+	// instead of one record per value.  This saves 8 bytes and a hash
+	// table slot per value for keys with many small values.  Put and
+	// PutExpiring write a packed record of one value.  The database must
+	// be read with ReaderOptions.PackedValues set.
+	PackValues bool
+
+	// CompressValues compresses each value with DEFLATE and a dictionary
+	// shared by the whole database, which is stored under DictionaryKey.
+	// Unless Dictionary is given, it is trained on the first 32KB of
+	// values, whose records are held in memory until then.  Values that
+	// do not shrink are stored as they are, behind a 1-byte marker.  This
+	// suits many small similar values, such as JSON documents of one
+	// shape, that compress poorly one at a time.  The database must be
+	// read with ReaderOptions.CompressedValues set.  Putting DictionaryKey
+	// is an error.
+	//
+	// Unlike WriteCompressed, which compresses whole blocks of values
+	// and needs its own CompressedReader, this keeps each value in its
+	// own record, so the file remains an ordinary cdb that any Reader can
+	// look values up in, at some cost in compression.
+	CompressValues bool
+	Dictionary     []byte
+
+	// Tracer, if set, receives a TraceFlush event each time buffered
+	// records are written to the io.WriteSeeker, and TraceTables and
+	// TraceClose events from Close.  With Parallelism above 1, flushes
+	// are reported from the writing goroutine.
+	Tracer Tracer
+
+	// IndexFirst writes the header and hash tables at the start of the
+	// database and the records after them, instead of the standard cdb
+	// layout with the hash tables last.  A reader can then fetch the
+	// whole index with one read before fetching records, or stream the
+	// database from the start.  The records are spooled to a temporary
+	// file until Close copies them into place, so a Writer that is not
+	// closed must be aborted with Abort.  Readers in this package
+	// detect the layout from the header; other cdb tools can look keys up
+	// but find no records to dump.  It cannot be combined with Checksum.
+	IndexFirst bool
+}
+
+// errIndexFirstChecksum is returned for WriterOptions with both IndexFirst
+// and Checksum set.
+var errIndexFirstChecksum = errors.New("checksums are not supported in the index-first layout")
+
+// errDictionaryKey is returned by Put for DictionaryKey when values are
+// compressed, as the key holds the dictionary.
+var errDictionaryKey = errors.New("key is reserved for the compression dictionary")
+
+// heldRecord is a record held by a Writer until its compression
+// dictionary is trained.
+type heldRecord struct {
+	key, value []byte
+	exp        int64
+}
+
+// ProgressInterval is the number of records between calls to
+// WriterOptions.OnProgress.
+const ProgressInterval = 10000
+
+// BuildStats describes a database built by a Writer.
+type BuildStats struct {
+	Records uint64          // number of records
+	Bytes   uint64          // size of the database
+	Tables  [256]TableStats // records and slots in each hash table
+	Elapsed time.Duration   // time from creating the Writer to Close
+}
+
+// NewWriter returns a Writer that writes a standard cdb to w.
+func NewWriter(w io.WriteSeeker) (*Writer, error) {
+	return NewWriterWithOptions(w, WriterOptions{})
+}
+
+// NewWriterWithOptions returns a Writer that writes a cdb to w as
+// configured by opts.
+func NewWriterWithOptions(w io.WriteSeeker, opts WriterOptions) (*Writer, error) {
+	f := opts.Format
+	if opts.IndexFirst && opts.Checksum {
+		return nil, errIndexFirstChecksum
+	}
+	if _, err := w.Seek(int64(f.headerSize()), 0); err != nil {
+		return nil, err
+	}
+
+	cw := &Writer{
+		w:        w,
+		format:   f,
+		htables:  make(map[uint32][]slot),
+		pos:      f.headerSize(),
+		hashKey:  opts.Hash,
+		expiry:   opts.Expiry,
+		keyFunc:  opts.KeyTransform,
+		packed:   opts.PackValues,
+		compress: opts.CompressValues,
+		index:    opts.PrefixIndex,
+
+		bloom:     opts.BloomFilter,
+		bloomRate: opts.BloomFalsePositiveRate,
+
+		maxKeyLen:   opts.MaxKeyLen,
+		maxValueLen: opts.MaxValueLen,
+		utf8Keys:    opts.ValidateUTF8Keys,
+
+		onProgress: opts.OnProgress,
+		tracer:     opts.Tracer,
+		start:      time.Now(),
+	}
+	if cw.hashKey == nil {
+		cw.hashKey = checksum
+	}
+	if cw.index != nil {
+		cw.keys = make(map[string]struct{})
+	}
+	if cw.compress {
+		cw.dictKey = []byte(DictionaryKey)
+		if cw.keyFunc != nil {
+			cw.dictKey = cw.keyFunc(cw.dictKey)
+		}
+	}
+	var out io.Writer = w
+	if opts.IndexFirst {
+		spool, err := ioutil.TempFile("", "cdbmap")
+		if err != nil {
+			return nil, err
+		}
+		cw.spool, out = spool, spool
+	}
+	if opts.Tracer != nil {
+		out = traceWriter{out, opts.Tracer}
+	}
+	if opts.Checksum {
+		cw.sum = crc32.NewIEEE()
+		out = io.MultiWriter(out, cw.sum)
+	}
+	cw.wb = bufio.NewWriter(out)
+	if opts.Parallelism > 1 {
+		cw.par = newParallelWriter(cw, opts.Parallelism)
+	}
+	if opts.CompressValues && opts.Dictionary != nil {
+		if err := cw.setDictionary(opts.Dictionary); err != nil {
+			cw.removeSpool()
+			return nil, err
+		}
+	}
+
+	return cw, nil
+}
+
+// Put writes a record.  Putting the same key more than once stores
+// multiple values for it.  It returns ErrTooLarge if the record would take
+// the database past the size limit of its format.
+func (cw *Writer) Put(key, value []byte) error {
+	if cw.packed {
+		return cw.PutValues(key, [][]byte{value})
+	}
+	return cw.putRecord(key, value, 0)
+}
+
+// PutValues writes values under key, as one packed record if the Writer
+// was created with WriterOptions.PackValues, or one record per value if
+// not.
+func (cw *Writer) PutValues(key []byte, values [][]byte) error {
+	if !cw.packed {
+		for _, v := range values {
+			if err := cw.putRecord(key, v, 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	cw.pack = packValues(cw.pack[:0], values)
+	return cw.putRecord(key, cw.pack, 0)
+}
+
+// PutExpiring writes a record that readers stop returning at expires.  The
+// Writer must have been created with WriterOptions.Expiry.
+func (cw *Writer) PutExpiring(key, value []byte, expires time.Time) error {
+	if !cw.expiry {
+		return errNoExpiry
+	}
+	exp := expires.Unix()
+	if exp <= 0 {
+		exp = 1 // already expired; 0 would mean never
+	}
+	if cw.packed {
+		cw.pack = packValues(cw.pack[:0], [][]byte{value})
+		value = cw.pack
+	}
+	return cw.putRecord(key, value, exp)
+}
+
+// putRecord normalizes and validates key, and then writes the record or
+// queues it for the parallel workers.
+func (cw *Writer) putRecord(key, value []byte, exp int64) error {
+	if cw.keyFunc != nil {
+		key = cw.keyFunc(key)
+	}
+	if err := cw.validate(key, value); err != nil {
+		return err
+	}
+	if cw.compress {
+		if cw.comp == nil {
+			return cw.sample(key, value, exp)
+		}
+		cw.cbuf = cw.comp.compress(cw.cbuf[:0], value)
+		value = cw.cbuf
+	}
+	return cw.write(key, value, exp)
+}
+
+// write writes a normalized and validated record, or queues it for the
+// parallel workers.
+func (cw *Writer) write(key, value []byte, exp int64) error {
+	if cw.par != nil {
+		return cw.par.put(key, value, exp)
+	}
+	return cw.put(key, cw.hashKey(key), exp, value)
+}
+
+// sample holds a record until the compression dictionary is trained, which
+// it does once enough values have been sampled.
+func (cw *Writer) sample(key, value []byte, exp int64) error {
+	cw.held = append(cw.held, heldRecord{
+		key:   append([]byte(nil), key...),
+		value: append([]byte(nil), value...),
+		exp:   exp,
+	})
+	cw.sampled += len(value)
+	if cw.sampled < dictSize {
+		return nil
+	}
+	return cw.train()
+}
+
+// train builds the compression dictionary from the held records and then
+// writes them.
+func (cw *Writer) train() error {
+	samples := make([][]byte, len(cw.held))
+	for i, rec := range cw.held {
+		samples[i] = rec.value
+	}
+	if err := cw.setDictionary(trainDictionary(samples)); err != nil {
+		return err
+	}
+
+	held := cw.held
+	cw.held = nil
+	for _, rec := range held {
+		cw.cbuf = cw.comp.compress(cw.cbuf[:0], rec.value)
+		if err := cw.write(rec.key, cw.cbuf, rec.exp); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setDictionary starts compressing values with dict, and writes it under
+// DictionaryKey.
+func (cw *Writer) setDictionary(dict []byte) error {
+	cw.comp = newValueCompressor(dict)
+	return cw.write(cw.dictKey, append([]byte{valueStored}, dict...), 0)
+}
+
+// validate checks a record against the limits in the WriterOptions.
+func (cw *Writer) validate(key, value []byte) error {
+	switch {
+	case cw.maxKeyLen > 0 && len(key) > cw.maxKeyLen:
+		return fmt.Errorf("%w: key %s is %d bytes, limit is %d", ErrKeyTooLong, quoteKey(key), len(key), cw.maxKeyLen)
+	case cw.maxValueLen > 0 && len(value) > cw.maxValueLen:
+		return fmt.Errorf("%w: value of key %s is %d bytes, limit is %d", ErrValueTooLong, quoteKey(key), len(value), cw.maxValueLen)
+	case cw.utf8Keys && !utf8.Valid(key):
+		return fmt.Errorf("%w: key %s", ErrInvalidKey, quoteKey(key))
+	case cw.dictKey != nil && bytes.Equal(key, cw.dictKey):
+		return errDictionaryKey
+	}
+	return nil
+}
+
+// quoteKey quotes key for an error message, shortening long keys.
+func quoteKey(key []byte) string {
+	const max = 64
+	if len(key) > max {
+		return fmt.Sprintf("%q...", key[:max])
+	}
+	return fmt.Sprintf("%q", key)
+}
+
+// put writes a record whose key hashes to h, so callers writing several
+// values for one key need only hash it once.  exp is the record's expiry
+// time in Unix seconds, or 0 if it never expires, and is written only if
+// the Writer has expiry times.
+func (cw *Writer) put(key []byte, h uint32, exp int64, value []byte) (err error) {
+	size, err := cw.recordSize(len(key), len(value))
+	if err != nil {
+		return
+	}
+	if size > cw.format.maxPos()-cw.pos {
+		return ErrTooLarge
+	}
+
+	cw.rec = cw.appendRecord(cw.rec[:0], key, value, exp)
+	if _, err = cw.wb.Write(cw.rec); err != nil {
+		return
+	}
+	cw.added(key, h, size)
+
+	return nil
+}
+
+// recordSize returns the size of a record with a key of klen bytes and a
+// value of vlen bytes, or ErrTooLarge if its lengths do not fit the format.
+func (cw *Writer) recordSize(klen, vlen int) (uint64, error) {
+	dlen := cw.dataLen(vlen)
+	if uint64(klen) > cw.format.maxPos() || dlen > cw.format.maxPos() {
+		return 0, ErrTooLarge
+	}
+
+	return uint64(2*cw.format.numSize()) + uint64(klen) + dlen, nil
+}
+
+// dataLen returns the stored length of a value of vlen bytes, including
+// its expiry time and checksum.
+func (cw *Writer) dataLen(vlen int) uint64 {
+	dlen := uint64(vlen)
+	if cw.sum != nil {
+		dlen += checksumSize
+	}
+	if cw.expiry {
+		dlen += expirySize
+	}
+	return dlen
+}
+
+// appendRecord appends the record for key and value to dst: the lengths,
+// the key, the expiry time if the Writer has them, the value, and its
+// checksum if the Writer is checksumming.  It only reads the Writer's
+// configuration, so parallel workers may call it concurrently.
+func (cw *Writer) appendRecord(dst, key, value []byte, exp int64) []byte {
+	n := cw.format.numSize()
+	dlen := cw.dataLen(len(value))
+
+	start := len(dst)
+	dst = slices.Grow(dst, 2*n+len(key)+int(dlen))[:start+2*n]
+	cw.format.putNum(dst[start:], uint64(len(key)))
+	cw.format.putNum(dst[start+n:], dlen)
+	dst = append(dst, key...)
+
+	var crc uint32 // of the expiry time and value, if checksumming
+	if cw.expiry {
+		dst = binary.LittleEndian.AppendUint64(dst, uint64(exp))
+		crc = crc32.Update(crc, crc32.IEEETable, dst[len(dst)-expirySize:])
+	}
+	dst = append(dst, value...)
+	if cw.sum != nil {
+		dst = binary.LittleEndian.AppendUint32(dst, crc32.Update(crc, crc32.IEEETable, value))
+	}
+
+	return dst
+}
+
+// added records a record of size bytes, whose key hashes to h, written at
+// the current position.
+func (cw *Writer) added(key []byte, h uint32, size uint64) {
+	if cw.keys != nil {
+		if _, ok := cw.keys[string(key)]; !ok {
+			cw.keys[string(key)] = struct{}{}
+		}
+	}
+
+	tableNum := h % 256
+	cw.htables[tableNum] = append(cw.htables[tableNum], slot{h, cw.pos})
+	cw.pos += size
+
+	cw.nrecs++
+	if cw.onProgress != nil && cw.nrecs%ProgressInterval == 0 {
+		cw.onProgress(cw.nrecs, cw.pos)
+	}
+}
+
+// Close writes the hash tables and header.  It returns ErrTooLarge if the
+// hash tables would end past the size limit of the format.  It does not
+// close the underlying io.WriteSeeker.
+func (cw *Writer) Close() error {
+	defer cw.removeSpool()
+	if cw.compress && cw.comp == nil {
+		if err := cw.train(); err != nil {
+			return err
+		}
+	}
+	if cw.par != nil {
+		err := cw.par.close()
+		cw.par = nil
+		if err != nil {
+			return err
+		}
+	}
+
+	start := time.Now()
+	var header []byte
+	var size uint64
+	var err error
+	indexFirst := cw.spool != nil
+	if indexFirst {
+		header, size, err = cw.writeIndexFirst()
+	} else {
+		header, err = writeTables(cw.w, cw.wb, cw.format, cw.htables, cw.pos)
+	}
+	if err != nil {
+		return err
+	}
+	t := cw.format.tables(header)
+	if !indexFirst {
+		size = tablesEnd(cw.format, &t)
+	}
+	if cw.tracer != nil {
+		cw.tracer.Trace(TraceEvent{Op: TraceTables, Records: cw.nrecs, Bytes: size, Duration: time.Since(start)})
+	}
+
+	if cw.sum != nil {
+		if _, err = cw.w.Seek(0, 2); err != nil {
+			return err
+		}
+		if _, err = cw.w.Write(checksumTrailer(crc32.ChecksumIEEE(header), cw.sum.Sum32())); err != nil {
+			return err
+		}
+		size += uint64(checksumTrailerSize)
+	}
+	if cw.index != nil {
+		if err = writePrefixIndex(cw.index, cw.keys); err != nil {
+			return err
+		}
+	}
+	if cw.bloom != nil {
+		if err = cw.writeBloomFilter(); err != nil {
+			return err
+		}
+	}
+
+	cw.stats = BuildStats{Records: cw.nrecs, Bytes: size, Elapsed: time.Since(cw.start)}
+	for i := range t {
+		cw.stats.Tables[i] = TableStats{uint64(len(cw.htables[uint32(i)])), t[i].nslots, t[i].pos}
+	}
+	if cw.onProgress != nil {
+		cw.onProgress(cw.nrecs, size)
+	}
+	if cw.tracer != nil {
+		cw.tracer.Trace(TraceEvent{Op: TraceClose, Records: cw.nrecs, Bytes: size, Duration: cw.stats.Elapsed})
+	}
+
+	return nil
+}
+
+// writeIndexFirst writes the header and hash tables followed by the
+// spooled records, and removes the spool.  It returns the header and the
+// size of the database.
+func (cw *Writer) writeIndexFirst() (header []byte, size uint64, err error) {
+	defer cw.removeSpool()
+	if err = cw.wb.Flush(); err != nil {
+		return nil, 0, err
+	}
+
+	// The records move up by the size of the hash tables, which hold two
+	// slots per record.
+	f := cw.format
+	shift := 2 * 2 * uint64(f.numSize()) * cw.nrecs
+	if cw.pos > f.maxPos()-shift {
+		return nil, 0, ErrTooLarge
+	}
+	for _, slots := range cw.htables {
+		for i := range slots {
+			slots[i].pos += shift
+		}
+	}
+
+	var out io.Writer = cw.w
+	if cw.tracer != nil {
+		out = traceWriter{out, cw.tracer}
+	}
+	wb := bufio.NewWriter(out)
+	if header, err = buildTables(wb, f, cw.htables, f.headerSize()); err != nil {
+		return nil, 0, err
+	}
+	if _, err = cw.spool.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+	if _, err = io.Copy(wb, cw.spool); err != nil {
+		return nil, 0, err
+	}
+	if err = wb.Flush(); err != nil {
+		return nil, 0, err
+	}
+	if _, err = cw.w.Seek(0, 0); err != nil {
+		return nil, 0, err
+	}
+	if _, err = cw.w.Write(header); err != nil {
+		return nil, 0, err
+	}
+
+	return header, cw.pos + shift, nil
+}
+
+// Abort gives up on the database without writing the hash tables and
+// header, leaving what has been written to the underlying io.WriteSeeker
+// incomplete.  It stops the goroutines of a Writer with Parallelism and
+// removes the temporary file of one with IndexFirst.  Abort after Close
+// does nothing, so it can be deferred to clean up after a failed Put.
+func (cw *Writer) Abort() {
+	if cw.par != nil {
+		cw.par.close()
+		cw.par = nil
+	}
+	cw.removeSpool()
+}
+
+// removeSpool closes and removes the spool, if there is one.
+func (cw *Writer) removeSpool() {
+	if cw.spool != nil {
+		cw.spool.Close()
+		os.Remove(cw.spool.Name())
+		cw.spool = nil
+	}
+}
+
+// writeBloomFilter writes a bloom filter of the hashes of every record.
+func (cw *Writer) writeBloomFilter() error {
+	rate := cw.bloomRate
+	if rate <= 0 || rate >= 1 {
+		rate = DefaultBloomFalsePositiveRate
+	}
+
+	b := newBloomFilter(cw.nrecs, rate)
+	for _, slots := range cw.htables {
+		for _, s := range slots {
+			b.add(s.h)
+		}
+	}
+
+	return b.writeTo(cw.bloom)
+}
+
+// Stats returns statistics for the database once Close has returned
+// without error.
+func (cw *Writer) Stats() BuildStats {
+	return cw.stats
+}
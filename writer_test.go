@@ -0,0 +1,1369 @@
+package cdbmap
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWriter(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	for _, rec := range records {
+		for _, value := range rec.values {
+			if err = w.Put([]byte(rec.key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+
+	for _, rec := range records {
+		v, err := c.Get(rec.key)
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("value mismatch: expected %v, got %v", rec.values, v)
+		}
+	}
+}
+
+func TestWriterFormat64(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Format: Format64})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, rec := range records {
+		for _, value := range rec.values {
+			if err = w.Put([]byte(rec.key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if c.format != Format64 {
+		t.Fatalf("expected Format64 to be detected")
+	}
+
+	for _, rec := range records {
+		v, err := c.Get(rec.key)
+		if err != nil {
+			t.Fatalf("Record read failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("value mismatch: expected %v, got %v", rec.values, v)
+		}
+	}
+
+	if _, err = tmp.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	if err = Dump(buf, tmp); err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("Dump of Format64 database differs")
+	}
+}
+
+func TestWriteRecords(t *testing.T) {
+	var recs []Record
+	for _, rec := range records {
+		for _, value := range rec.values {
+			recs = append(recs, Record{[]byte(rec.key), []byte(value)})
+		}
+	}
+
+	var outputs [][]byte
+	for i := 0; i < 2; i++ {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+
+		defer os.Remove(tmp.Name())
+
+		if err = WriteRecords(recs, tmp); err != nil {
+			t.Fatalf("WriteRecords failed: %s", err)
+		}
+
+		b, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		outputs = append(outputs, b)
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatal("WriteRecords output is not reproducible")
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := Dump(buf, bytes.NewReader(outputs[0])); err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("records not written in the order given")
+	}
+}
+
+func TestWriteFromChannel(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	ch := make(chan Record)
+	go func() {
+		for _, rec := range records {
+			for _, value := range rec.values {
+				ch <- Record{[]byte(rec.key), []byte(value)}
+			}
+		}
+		close(ch)
+	}()
+	if err = WriteFromChannel(tmp, ch); err != nil {
+		t.Fatalf("WriteFromChannel failed: %s", err)
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if _, err = tmp.Seek(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err = Dump(buf, tmp); err != nil {
+		t.Fatalf("Dump failed: %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatal("records not written in the order received")
+	}
+}
+
+func TestMerge(t *testing.T) {
+	a := map[string][]string{"one": {"1"}, "two": {"2", "22"}}
+	b := map[string][]string{"two": {"b2"}, "three": {"b3"}}
+
+	var dbs []io.ReaderAt
+	for _, m := range []map[string][]string{a, b} {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+
+		defer os.Remove(tmp.Name())
+
+		if err = Write(m, tmp); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+		dbs = append(dbs, tmp)
+	}
+
+	tests := []struct {
+		policy   MergePolicy
+		expected map[string][]string
+	}{
+		{MergeAppend, map[string][]string{"one": {"1"}, "two": {"2", "22", "b2"}, "three": {"b3"}}},
+		{MergeFirstWins, map[string][]string{"one": {"1"}, "two": {"2", "22"}, "three": {"b3"}}},
+		{MergeLastWins, map[string][]string{"one": {"1"}, "two": {"b2"}, "three": {"b3"}}},
+	}
+	for _, tt := range tests {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+
+		defer os.Remove(tmp.Name())
+
+		if err = Merge(tmp, tt.policy, dbs...); err != nil {
+			t.Fatalf("Merge failed: %s", err)
+		}
+		m, err := Read(tmp)
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+		if !reflect.DeepEqual(m, tt.expected) {
+			t.Fatalf("policy %d: expected %v, got %v", tt.policy, tt.expected, m)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	tests := []struct {
+		policy   DuplicatePolicy
+		expected map[string][]string
+	}{
+		{DuplicatesAppend, map[string][]string{"one": {"1"}, "two": {"2", "22"}}},
+		{DuplicatesReplace, map[string][]string{"one": {"1"}, "two": {"22"}}},
+	}
+	for _, tt := range tests {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+
+		defer os.Remove(tmp.Name())
+
+		b, err := NewBuilder(tmp, tt.policy)
+		if err != nil {
+			t.Fatalf("NewBuilder failed: %s", err)
+		}
+		for _, kv := range [][2]string{{"two", "2"}, {"one", "1"}, {"two", "22"}} {
+			if err = b.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+		if err = b.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		m, err := Read(tmp)
+		if err != nil {
+			t.Fatalf("Read failed: %s", err)
+		}
+		if !reflect.DeepEqual(m, tt.expected) {
+			t.Fatalf("policy %d: expected %v, got %v", tt.policy, tt.expected, m)
+		}
+	}
+
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	b, err := NewBuilder(tmp, DuplicatesError)
+	if err != nil {
+		t.Fatalf("NewBuilder failed: %s", err)
+	}
+	if err = b.Put([]byte("one"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = b.Put([]byte("one"), []byte("11")); !errors.Is(err, ErrDuplicateKey) {
+		t.Fatalf("expected ErrDuplicateKey, got %v", err)
+	}
+}
+
+func TestSharded(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	manifest := filepath.Join(dir, "db")
+	sw, err := CreateSharded(manifest, 4)
+	if err != nil {
+		t.Fatalf("CreateSharded failed: %s", err)
+	}
+	for _, rec := range records {
+		for _, value := range rec.values {
+			if err = sw.Put([]byte(rec.key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+	}
+	if err = sw.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	sr, err := OpenSharded(manifest)
+	if err != nil {
+		t.Fatalf("OpenSharded failed: %s", err)
+	}
+	defer sr.Close()
+
+	for _, rec := range records {
+		v, err := sr.Get(rec.key)
+		if err != nil {
+			t.Fatalf("Get failed: %s", err)
+		}
+		if !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("value mismatch: expected %v, got %v", rec.values, v)
+		}
+	}
+	if _, err = sr.GetFirst([]byte("missing")); err != ErrNotFound {
+		t.Fatalf("non-existent key should return ErrNotFound, got %v", err)
+	}
+}
+
+func TestCompact(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	base, journal := filepath.Join(dir, "db"), filepath.Join(dir, "db.journal")
+	if err = ToFile(map[string][]string{"one": {"1"}, "two": {"2"}}, base); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	j, err := OpenJournal(journal)
+	if err != nil {
+		t.Fatalf("OpenJournal failed: %s", err)
+	}
+	for _, kv := range [][2]string{{"two", "22"}, {"three", "3"}} {
+		if err = j.Put([]byte(kv[0]), []byte(kv[1])); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = j.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	// Simulate a crash in the middle of appending a record.
+	f, err := os.OpenFile(journal, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("+4,1:fou")
+	f.Close()
+
+	if err = Compact(base, journal); err != nil {
+		t.Fatalf("Compact failed: %s", err)
+	}
+	expected := map[string][]string{"one": {"1"}, "two": {"2", "22"}, "three": {"3"}}
+	if m, err := FromFile(base); err != nil || !reflect.DeepEqual(m, expected) {
+		t.Fatalf("expected %v, got %v (%v)", expected, m, err)
+	}
+	if fi, err := os.Stat(journal); err != nil || fi.Size() != 0 {
+		t.Fatalf("journal not emptied (%v)", err)
+	}
+}
+
+func TestMap(t *testing.T) {
+	type item struct {
+		Name  string
+		Count int
+	}
+
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	tm := Map[uint64, item]{Uint64Codec{}, JSONCodec[item]{}}
+	expected := map[uint64][]item{
+		1: {{"one", 1}},
+		2: {{"two", 2}, {"twenty-two", 22}},
+	}
+	if err = tm.Write(expected, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+
+	got, err := tm.Read(tmp)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+
+	c, err := New(tmp)
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	v, err := tm.Get(c, 2)
+	if err != nil || !reflect.DeepEqual(v, expected[2]) {
+		t.Fatalf("Get: expected %v, got %v (%v)", expected[2], v, err)
+	}
+}
+
+func TestStructs(t *testing.T) {
+	type item struct {
+		Name string
+		Tags []string
+	}
+
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	expected := map[string][]item{
+		"one": {{"one", []string{"a"}}},
+		"two": {{"two", nil}, {"twenty-two", []string{"b", "c"}}},
+	}
+	if err = WriteStructs(expected, tmp); err != nil {
+		t.Fatalf("WriteStructs failed: %s", err)
+	}
+
+	got, err := ReadStructs[item](tmp)
+	if err != nil {
+		t.Fatalf("ReadStructs failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+}
+
+func TestGenerateGo(t *testing.T) {
+	var buf bytes.Buffer
+	m := map[string][]string{"b": {"2", "two"}, "a\n": {"1"}}
+	if err := GenerateGo(&buf, m, "data", "Table"); err != nil {
+		t.Fatalf("GenerateGo failed: %s", err)
+	}
+
+	expected := `// Code generated by cdbmap.GenerateGo; DO NOT EDIT.
+
+package data
+
+var Table = map[string][]string{
+	"a\n": {"1"},
+	"b":   {"2", "two"},
+}
+`
+	if buf.String() != expected {
+		t.Fatalf("expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+
+	if err := GenerateGo(&buf, m, "data", "not valid"); err == nil {
+		t.Fatalf("expected an error for an invalid variable name")
+	}
+}
+
+func TestWriteStream(t *testing.T) {
+	m := make(map[string][]string)
+	for _, rec := range records {
+		m[rec.key] = rec.values
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := WriteStream(m, buf); err != nil {
+		t.Fatalf("WriteStream failed: %s", err)
+	}
+
+	got, err := ReadStream(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadStream failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("ReadStream: expected %v, got %v", m, got)
+	}
+
+	r := bytes.NewReader(buf.Bytes())
+	if err := Verify(r); err != nil {
+		t.Fatalf("Verify failed: %s", err)
+	}
+	got, err = Read(r)
+	if err != nil {
+		t.Fatalf("Read failed: %s", err)
+	}
+	if !reflect.DeepEqual(got, m) {
+		t.Fatalf("expected %v, got %v", m, got)
+	}
+}
+
+func TestDatabase(t *testing.T) {
+	var db Database
+	db.Set("one", "1")
+	db.Set("two", "2", "22")
+	db.Set("three", "3")
+	db.Delete("three")
+	if db.Len() != 2 {
+		t.Fatalf("expected 2 keys, got %d", db.Len())
+	}
+
+	buf := bytes.NewBuffer(nil)
+	n, err := db.WriteTo(buf)
+	if err != nil {
+		t.Fatalf("WriteTo failed: %s", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+
+	var got Database
+	if n, err = got.ReadFrom(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("ReadFrom failed: %s", err)
+	}
+	if n != int64(buf.Len()) {
+		t.Fatalf("ReadFrom returned %d, read %d bytes", n, buf.Len())
+	}
+	if !reflect.DeepEqual(got.Map(), db.Map()) {
+		t.Fatalf("expected %v, got %v", db.Map(), got.Map())
+	}
+	if v := got.Get("two"); !reflect.DeepEqual(v, []string{"2", "22"}) {
+		t.Fatalf("Get: expected [2 22], got %v", v)
+	}
+}
+
+func TestCompressed(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	m := map[string][]string{
+		"one": {"1"},
+		"two": {"2", "22"},
+		// Large enough to span several blocks.
+		"big": {strings.Repeat("x", compressedBlockSize), strings.Repeat("y", 3*compressedBlockSize/2)},
+	}
+	if err = WriteCompressed(m, tmp); err != nil {
+		t.Fatalf("WriteCompressed failed: %s", err)
+	}
+
+	c, err := OpenCompressed(tmp.Name())
+	if err != nil {
+		t.Fatalf("OpenCompressed failed: %s", err)
+	}
+	defer c.Close()
+
+	for k, values := range m {
+		v, err := c.Get(k)
+		if err != nil {
+			t.Fatalf("Get(%s) failed: %s", k, err)
+		}
+		if !reflect.DeepEqual(v, values) {
+			t.Fatalf("Get(%s) returned the wrong values", k)
+		}
+	}
+	if _, err = c.Get("missing"); err != ErrNotFound {
+		t.Fatalf("non-existent key should return ErrNotFound, got %v", err)
+	}
+
+	if _, err = NewCompressed(tmp, int64(HeaderSize)); err != BadFormatError {
+		t.Fatalf("expected BadFormatError without a trailer, got %v", err)
+	}
+
+	// The values are block references, so reading the file as a plain
+	// database is refused.
+	if _, err = Open(tmp.Name()); err != ErrCompressed {
+		t.Fatalf("Open of a compressed database should return ErrCompressed, got %v", err)
+	}
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		t.Fatalf("Stat failed: %s", err)
+	}
+	c, err = NewCompressedWithOptions(tmp, fi.Size(), ReaderOptions{MaxRecordSize: compressedBlockSize})
+	if err != nil {
+		t.Fatalf("NewCompressedWithOptions failed: %s", err)
+	}
+	if _, err = c.Get("one"); err != nil {
+		t.Fatalf("Get(one) failed: %s", err)
+	}
+	if _, err = c.Get("big"); !errors.Is(err, ErrRecordTooLarge) {
+		t.Fatalf("Get of a value over MaxRecordSize should return ErrRecordTooLarge, got %v", err)
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Checksum: true})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	if err = w.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	full, err := ioutil.ReadFile(tmp.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := New(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = c.VerifyChecksums(); err != nil {
+		t.Fatalf("VerifyChecksums failed: %s", err)
+	}
+	if v, err := c.GetFirst([]byte("key")); err != nil || string(v) != "value" {
+		t.Fatalf("GetFirst: expected %q, got %q (%v)", "value", v, err)
+	}
+	if m, err := Read(bytes.NewReader(full)); err != nil || m["key"][0] != "value" {
+		t.Fatalf("Read: expected checksums stripped, got %v (%v)", m, err)
+	}
+
+	// Flip a bit in the value.
+	full[HeaderSize+8+3] ^= 1
+	c, err = New(bytes.NewReader(full))
+	if err != nil {
+		t.Fatalf("New failed: %s", err)
+	}
+	if err = c.VerifyChecksums(); err != ChecksumError {
+		t.Fatalf("VerifyChecksums: expected ChecksumError, got %v", err)
+	}
+	if _, err = c.GetFirst([]byte("key")); err != ChecksumError {
+		t.Fatalf("GetFirst: expected ChecksumError, got %v", err)
+	}
+
+	c, err = NewWithOptions(bytes.NewReader(full), ReaderOptions{IgnoreChecksums: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if _, err = c.GetFirst([]byte("key")); err != nil {
+		t.Fatalf("GetFirst with IgnoreChecksums failed: %s", err)
+	}
+}
+
+// benchMap returns a map of n keys with values of varying multiplicity.
+func benchMap(n int) map[string][]string {
+	m := make(map[string][]string, n)
+	for i := 0; len(m) < n; i++ {
+		key := fmt.Sprintf("key%d", i)
+		for j := 0; j <= i%3; j++ {
+			m[key] = append(m[key], fmt.Sprintf("value%d-%d", i, j))
+		}
+	}
+	return m
+}
+
+func benchmarkWrite(b *testing.B, n int) {
+	m := benchMap(n)
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		b.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if err = Write(m, tmp); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkWrite10K(b *testing.B) { benchmarkWrite(b, 10000) }
+func BenchmarkWrite1M(b *testing.B)  { benchmarkWrite(b, 1000000) }
+
+func TestWriterHash(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Hash: HashFNV1a})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, rec := range records {
+		for _, value := range rec.values {
+			if err = w.Put([]byte(rec.key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{Hash: HashFNV1a})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	for _, rec := range records {
+		v, err := c.Get(rec.key)
+		if err != nil || !reflect.DeepEqual(v, rec.values) {
+			t.Fatalf("Get(%s): expected %v, got %v (%v)", rec.key, rec.values, v, err)
+		}
+	}
+}
+
+func TestKeyTransform(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{KeyTransform: bytes.ToLower})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	if err = w.Put([]byte("Example.COM"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{KeyTransform: bytes.ToLower})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	for _, key := range []string{"example.com", "EXAMPLE.com"} {
+		if v, err := c.GetFirst([]byte(key)); err != nil || string(v) != "1" {
+			t.Fatalf("GetFirst(%s): expected 1, got %q (%v)", key, v, err)
+		}
+	}
+}
+
+func TestExpiry(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	now := time.Unix(1000000, 0)
+	w, err := NewWriterWithOptions(tmp, WriterOptions{Expiry: true, Checksum: true})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	puts := []struct {
+		key, value string
+		expires    time.Time
+	}{
+		{"one", "1", time.Time{}},
+		{"two", "2", now.Add(-time.Second)},
+		{"two", "22", now.Add(time.Hour)},
+		{"three", "3", now},
+	}
+	for _, p := range puts {
+		if p.expires.IsZero() {
+			err = w.Put([]byte(p.key), []byte(p.value))
+		} else {
+			err = w.PutExpiring([]byte(p.key), []byte(p.value), p.expires)
+		}
+		if err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{Expiry: true, Now: func() time.Time { return now }})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	expected := map[string][]string{"one": {"1"}, "two": {"22"}}
+	got := make(map[string][]string)
+	for _, key := range []string{"one", "two", "three"} {
+		if v, err := c.Get(key); err == nil {
+			got[key] = v
+		} else if err != ErrNotFound {
+			t.Fatalf("Get failed: %s", err)
+		}
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Fatalf("expected %v, got %v", expected, got)
+	}
+	if v, err := c.GetFirst([]byte("two")); err != nil || string(v) != "22" {
+		t.Fatalf("GetFirst: expected 22, got %q (%v)", v, err)
+	}
+
+	got = make(map[string][]string)
+	err = c.Iterate(func(key, value []byte) error {
+		got[string(key)] = append(got[string(key)], string(value))
+		return nil
+	})
+	if err != nil || !reflect.DeepEqual(got, expected) {
+		t.Fatalf("Iterate: expected %v, got %v (%v)", expected, got, err)
+	}
+
+	c, err = NewWithOptions(tmp, ReaderOptions{Expiry: true, IncludeExpired: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if v, err := c.Get("two"); err != nil || !reflect.DeepEqual(v, []string{"2", "22"}) {
+		t.Fatalf("IncludeExpired: expected [2 22], got %v (%v)", v, err)
+	}
+}
+
+func TestWriterProgress(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	var calls, lastRecords, lastBytes uint64
+	w, err := NewWriterWithOptions(tmp, WriterOptions{
+		OnProgress: func(records, bytes uint64) {
+			calls++
+			lastRecords, lastBytes = records, bytes
+		},
+	})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	const n = 2*ProgressInterval + 1
+	for i := 0; i < n; i++ {
+		if err = w.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	fi, err := tmp.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || lastRecords != n || lastBytes != uint64(fi.Size()) {
+		t.Fatalf("expected 3 calls ending at %d records and %d bytes, got %d ending at %d and %d", n, fi.Size(), calls, lastRecords, lastBytes)
+	}
+
+	s := w.Stats()
+	var records uint64
+	for _, ts := range s.Tables {
+		records += ts.Records
+	}
+	if s.Records != n || records != n || s.Bytes != uint64(fi.Size()) {
+		t.Fatalf("Stats: expected %d records and %d bytes, got %+v", n, fi.Size(), s)
+	}
+}
+
+func TestWriterParallel(t *testing.T) {
+	// Enough records for several batches, written both ways.
+	var outputs [2][]byte
+	for i, parallelism := range []int{0, 4} {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+
+		w, err := NewWriterWithOptions(tmp, WriterOptions{Checksum: true, Expiry: true, Parallelism: parallelism})
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		for j := 0; j < 100000; j++ {
+			key := []byte(fmt.Sprintf("key%d", j%60000))
+			if err = w.PutExpiring(key, []byte("value value value"), time.Unix(int64(j), 0)); err != nil {
+				t.Fatalf("PutExpiring failed: %s", err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+		if w.Stats().Records != 100000 {
+			t.Fatalf("expected 100000 records, got %d", w.Stats().Records)
+		}
+
+		if outputs[i], err = ioutil.ReadFile(tmp.Name()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatalf("parallel output differs from sequential output")
+	}
+}
+
+func TestWriterTooLarge(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	// Pretend the data section already nearly fills a Format32 database.
+	w, err := NewWriter(tmp)
+	if err != nil {
+		t.Fatalf("NewWriter failed: %s", err)
+	}
+	w.pos = math.MaxUint32 - 20
+	if err = w.Put([]byte("key"), make([]byte, 20)); err != ErrTooLarge {
+		t.Fatalf("Put: expected ErrTooLarge, got %v", err)
+	}
+	if err = w.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != ErrTooLarge {
+		t.Fatalf("Close: expected ErrTooLarge, got %v", err)
+	}
+}
+
+func TestWriterLimits(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{MaxKeyLen: 8, MaxValueLen: 4, ValidateUTF8Keys: true})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	for _, tc := range []struct {
+		key, value string
+		expected   error
+	}{
+		{"key", "1234", nil},
+		{"much too long", "1", ErrKeyTooLong},
+		{"key", "12345", ErrValueTooLong},
+		{"bad\xff", "1", ErrInvalidKey},
+	} {
+		err := w.Put([]byte(tc.key), []byte(tc.value))
+		if !errors.Is(err, tc.expected) {
+			t.Fatalf("Put(%q, %q): expected %v, got %v", tc.key, tc.value, tc.expected, err)
+		}
+		if err != nil && !strings.Contains(err.Error(), fmt.Sprintf("%q", tc.key)) {
+			t.Fatalf("error %q does not name the key", err)
+		}
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	forward, reverse := filepath.Join(dir, "forward.cdb"), filepath.Join(dir, "reverse.cdb")
+	if err = ToFile(map[string][]string{"one": {"1"}}, forward); err != nil {
+		t.Fatalf("ToFile failed: %s", err)
+	}
+
+	// An aborted publish leaves the old database in place.
+	var p Publisher
+	f, err := p.Create(forward)
+	if err != nil {
+		t.Fatalf("Create failed: %s", err)
+	}
+	if err = Write(map[string][]string{"two": {"2"}}, f); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if err = p.Abort(); err != nil {
+		t.Fatalf("Abort failed: %s", err)
+	}
+	if m, err := FromFile(forward); err != nil || m["one"] == nil {
+		t.Fatalf("expected the old database after Abort, got %v (%v)", m, err)
+	}
+
+	p = Publisher{}
+	for name, m := range map[string]map[string][]string{forward: {"two": {"2"}}, reverse: {"2": {"two"}}} {
+		f, err := p.Create(name)
+		if err != nil {
+			t.Fatalf("Create failed: %s", err)
+		}
+		if err = Write(m, f); err != nil {
+			t.Fatalf("Write failed: %s", err)
+		}
+	}
+	if err = p.Commit(); err != nil {
+		t.Fatalf("Commit failed: %s", err)
+	}
+	if m, err := FromFile(forward); err != nil || m["two"] == nil {
+		t.Fatalf("expected the new forward database, got %v (%v)", m, err)
+	}
+	if m, err := FromFile(reverse); err != nil || m["2"] == nil {
+		t.Fatalf("expected the new reverse database, got %v (%v)", m, err)
+	}
+
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil || len(entries) != 2 {
+		t.Fatalf("expected only the two databases to remain, got %d files (%v)", len(entries), err)
+	}
+}
+
+func TestPackValues(t *testing.T) {
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+
+	defer os.Remove(tmp.Name())
+
+	w, err := NewWriterWithOptions(tmp, WriterOptions{PackValues: true})
+	if err != nil {
+		t.Fatalf("NewWriterWithOptions failed: %s", err)
+	}
+	if err = w.PutValues([]byte("tags"), [][]byte{[]byte("a"), []byte(""), []byte("ccc")}); err != nil {
+		t.Fatalf("PutValues failed: %s", err)
+	}
+	if err = w.Put([]byte("tags"), []byte("d")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Put([]byte("one"), []byte("1")); err != nil {
+		t.Fatalf("Put failed: %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close failed: %s", err)
+	}
+
+	c, err := NewWithOptions(tmp, ReaderOptions{PackedValues: true})
+	if err != nil {
+		t.Fatalf("NewWithOptions failed: %s", err)
+	}
+	if n, err := c.Len(); err != nil || n != 3 {
+		t.Errorf("expected 3 records, got %d (%v)", n, err)
+	}
+
+	want := []string{"a", "", "ccc", "d"}
+	if got, err := c.Get("tags"); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("Get: expected %q, got %q (%v)", want, got, err)
+	}
+	if v, err := c.GetAt([]byte("tags"), 3); err != nil || string(v) != "d" {
+		t.Errorf("GetAt: expected d, got %q (%v)", v, err)
+	}
+	if _, err := c.GetAt([]byte("tags"), 4); err != ErrNotFound {
+		t.Errorf("GetAt: expected ErrNotFound past the last value, got %v", err)
+	}
+	if n, err := c.Count([]byte("tags")); err != nil || n != 4 {
+		t.Errorf("Count: expected 4, got %d (%v)", n, err)
+	}
+	if m, err := c.GetBatch([][]byte{[]byte("tags"), []byte("one")}); err != nil || len(m["tags"]) != 4 || string(m["one"][0]) != "1" {
+		t.Errorf("GetBatch: got %q (%v)", m, err)
+	}
+
+	m, err := ReadWithOptions(tmp, ReaderOptions{PackedValues: true})
+	if err != nil {
+		t.Fatalf("ReadWithOptions failed: %s", err)
+	}
+	if expected := map[string][]string{"tags": want, "one": {"1"}}; !reflect.DeepEqual(m, expected) {
+		t.Errorf("ReadWithOptions: expected %q, got %q", expected, m)
+	}
+}
+
+func TestAppendFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp dir: %s", err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, opts := range []WriterOptions{{}, {Format: Format64}, {Checksum: true}} {
+		filename := filepath.Join(dir, "test.cdb")
+		err := writeFile(filename, func(f *os.File) error {
+			w, err := NewWriterWithOptions(f, opts)
+			if err != nil {
+				return err
+			}
+			for _, rec := range records {
+				for _, v := range rec.values {
+					if err = w.Put([]byte(rec.key), []byte(v)); err != nil {
+						return err
+					}
+				}
+			}
+			return w.Close()
+		})
+		if err != nil {
+			t.Fatalf("Failed to write database: %s", err)
+		}
+
+		extra := map[string][]string{"one": {"uno"}, "new": {"a", "b"}}
+		if err = AppendFile(filename, extra); err != nil {
+			t.Fatalf("AppendFile failed: %s", err)
+		}
+
+		want := make(map[string][]string)
+		for _, rec := range records {
+			want[rec.key] = append(want[rec.key], rec.values...)
+		}
+		for k, vs := range extra {
+			want[k] = append(want[k], vs...)
+		}
+
+		got, err := FromFile(filename)
+		if err != nil {
+			t.Fatalf("FromFile failed: %s", err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%+v: expected %v, got %v", opts, want, got)
+		}
+		if err = VerifyFile(filename); err != nil {
+			t.Errorf("%+v: Verify failed: %s", opts, err)
+		}
+
+		c, err := Open(filename)
+		if err != nil {
+			t.Fatalf("Open failed: %s", err)
+		}
+		if values, err := c.Get("one"); err != nil || !reflect.DeepEqual(values, want["one"]) {
+			t.Errorf("%+v: Get: expected %v, got %v (%v)", opts, want["one"], values, err)
+		}
+		if opts.Checksum {
+			if err = c.VerifyChecksums(); err != nil {
+				t.Errorf("VerifyChecksums failed: %s", err)
+			}
+		}
+		c.Close()
+	}
+}
+
+func TestCompressValues(t *testing.T) {
+	build := func(opts WriterOptions) (*bytes.Reader, map[string][]string) {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+		defer tmp.Close()
+
+		w, err := NewWriterWithOptions(tmp, opts)
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		m := make(map[string][]string)
+		for i := 0; i < 5000; i++ {
+			key := fmt.Sprintf("user:%d", i)
+			value := fmt.Sprintf(`{"id":%d,"name":"user %d","email":"user%d@example.com","active":%t}`, i, i, i, i%3 == 0)
+			if err = w.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("Put failed: %s", err)
+			}
+			m[key] = append(m[key], value)
+		}
+		if err = w.Put([]byte("short"), []byte("x")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		m["short"] = []string{"x"}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+		b, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatalf("ReadFile failed: %s", err)
+		}
+		return bytes.NewReader(b), m
+	}
+
+	plain, _ := build(WriterOptions{})
+	for _, opts := range []WriterOptions{{CompressValues: true}, {CompressValues: true, Checksum: true, Parallelism: 4}} {
+		r, want := build(opts)
+		if r.Size() >= plain.Size()*2/3 {
+			t.Errorf("expected compression to shrink %d bytes by a third, got %d", plain.Size(), r.Size())
+		}
+
+		c, err := NewWithOptions(r, ReaderOptions{CompressedValues: true})
+		if err != nil {
+			t.Fatalf("NewWithOptions failed: %s", err)
+		}
+		for _, key := range []string{"user:0", "user:4999", "short"} {
+			if got, err := c.Get(key); err != nil || !reflect.DeepEqual(got, want[key]) {
+				t.Errorf("Get(%q): expected %q, got %q (%v)", key, want[key], got, err)
+			}
+		}
+
+		m, err := ReadWithOptions(r, ReaderOptions{CompressedValues: true})
+		if err != nil {
+			t.Fatalf("ReadWithOptions failed: %s", err)
+		}
+		if !reflect.DeepEqual(m, want) {
+			t.Errorf("ReadWithOptions returned %d keys, expected %d", len(m), len(want))
+		}
+
+		// Without the option the dictionary is an ordinary record.
+		if m, err = Read(r); err != nil || len(m[DictionaryKey]) != 1 {
+			t.Errorf("Read: expected the dictionary record, got %d values (%v)", len(m[DictionaryKey]), err)
+		}
+	}
+
+	if _, err := NewWithOptions(plain, ReaderOptions{CompressedValues: true}); !errors.Is(err, ErrCorruptRecord) {
+		t.Errorf("expected ErrCorruptRecord without a dictionary, got %v", err)
+	}
+
+	// DictionaryKey is reserved only when values are compressed.
+	tmp, err := ioutil.TempFile("", "")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %s", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	for _, opts := range []WriterOptions{{CompressValues: true}, {CompressValues: true, KeyTransform: bytes.ToLower}} {
+		w, err := NewWriterWithOptions(tmp, opts)
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		if err = w.Put([]byte(DictionaryKey), []byte("x")); err != errDictionaryKey {
+			t.Errorf("Put of DictionaryKey: expected errDictionaryKey, got %v", err)
+		}
+		w.Abort()
+	}
+	tmp.Seek(0, 0)
+	if err = Write(map[string][]string{DictionaryKey: {"x"}}, tmp); err != nil {
+		t.Fatalf("Write failed: %s", err)
+	}
+	if m, err := Read(tmp); err != nil || !reflect.DeepEqual(m, map[string][]string{DictionaryKey: {"x"}}) {
+		t.Errorf("Read of an uncompressed DictionaryKey: got %q (%v)", m, err)
+	}
+}
+
+func TestIndexFirst(t *testing.T) {
+	want := map[string][]string{"one": {"1"}, "two": {"2", "22"}, "three": {"3", "33", "333"}}
+	for _, f := range []Format{Format32, Format64} {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+
+		w, err := NewWriterWithOptions(tmp, WriterOptions{Format: f, IndexFirst: true})
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		for _, key := range []string{"one", "two", "three"} {
+			for _, v := range want[key] {
+				if err = w.Put([]byte(key), []byte(v)); err != nil {
+					t.Fatalf("Put failed: %s", err)
+				}
+			}
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close failed: %s", err)
+		}
+
+		h, err := ReadHeader(tmp)
+		if err != nil {
+			t.Fatalf("ReadHeader failed: %s", err)
+		}
+		if !h.IndexFirst() || h.Tables[0].Pos != h.Size() {
+			t.Fatalf("format %d: expected the hash tables after the header, got %+v", f, h.Tables[0])
+		}
+		if st, err := tmp.Stat(); err != nil || uint64(st.Size()) != w.Stats().Bytes {
+			t.Errorf("format %d: expected %d bytes, got %v (%v)", f, w.Stats().Bytes, st.Size(), err)
+		}
+		if h.DataEnd() != math.MaxUint64 {
+			t.Errorf("format %d: DataEnd: expected math.MaxUint64, got %d", f, h.DataEnd())
+		}
+		last := h.Tables[255]
+		if start, end, err := h.DataSection(tmp); err != nil || start != last.Pos+2*uint64(f.numSize())*last.NSlots || end != w.Stats().Bytes {
+			t.Errorf("format %d: DataSection: got %d to %d (%v)", f, start, end, err)
+		}
+
+		// Recover scans the records after the hash tables, and stops
+		// at a truncated one.
+		full, err := ioutil.ReadFile(tmp.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, cut := range []int{0, 1} {
+			out, err := ioutil.TempFile("", "")
+			if err != nil {
+				t.Fatalf("Failed to create temp file: %s", err)
+			}
+			defer os.Remove(out.Name())
+
+			n, err := Recover(bytes.NewReader(full[:len(full)-cut]), out)
+			if err != nil || n != 6-cut {
+				t.Errorf("format %d: Recover of %d bytes: expected %d records, got %d (%v)", f, len(full)-cut, 6-cut, n, err)
+			}
+			if m, err := Read(out); err != nil || cut == 0 && !reflect.DeepEqual(m, want) {
+				t.Errorf("format %d: Read of recovered database: expected %q, got %q (%v)", f, want, m, err)
+			}
+		}
+
+		c, err := New(tmp)
+		if err != nil {
+			t.Fatalf("New failed: %s", err)
+		}
+		if got, err := c.Get("two"); err != nil || !reflect.DeepEqual(got, want["two"]) {
+			t.Errorf("format %d: Get: expected %q, got %q (%v)", f, want["two"], got, err)
+		}
+		if m, err := Read(tmp); err != nil || !reflect.DeepEqual(m, want) {
+			t.Errorf("format %d: Read: expected %q, got %q (%v)", f, want, m, err)
+		}
+		if err = Verify(tmp); err != nil {
+			t.Errorf("format %d: Verify failed: %s", f, err)
+		}
+		if s, err := Stats(tmp); err != nil || s.Records != 6 {
+			t.Errorf("format %d: Stats: expected 6 records, got %+v (%v)", f, s, err)
+		}
+
+		tmp.Seek(0, 0)
+		if m, err := ReadStream(tmp); err != nil || !reflect.DeepEqual(m, want) {
+			t.Errorf("format %d: ReadStream: expected %q, got %q (%v)", f, want, m, err)
+		}
+		tmp.Seek(0, 0)
+		var dump bytes.Buffer
+		if err = Dump(&dump, tmp); err != nil || strings.Count(dump.String(), "\n") != 7 {
+			t.Errorf("format %d: Dump: got %q (%v)", f, dump.String(), err)
+		}
+	}
+
+	// Abort removes the spool of a Writer that is given up on.
+	for _, opts := range []WriterOptions{{IndexFirst: true}, {IndexFirst: true, Parallelism: 4}} {
+		tmp, err := ioutil.TempFile("", "")
+		if err != nil {
+			t.Fatalf("Failed to create temp file: %s", err)
+		}
+		defer os.Remove(tmp.Name())
+
+		w, err := NewWriterWithOptions(tmp, opts)
+		if err != nil {
+			t.Fatalf("NewWriterWithOptions failed: %s", err)
+		}
+		if err = w.Put([]byte("one"), []byte("1")); err != nil {
+			t.Fatalf("Put failed: %s", err)
+		}
+		spool := w.spool.Name()
+		w.Abort()
+		if _, err = os.Stat(spool); !os.IsNotExist(err) {
+			t.Errorf("Abort left the spool %s: %v", spool, err)
+		}
+		w.Abort()
+	}
+
+	if _, err := NewWriterWithOptions(nil, WriterOptions{IndexFirst: true, Checksum: true}); err != errIndexFirstChecksum {
+		t.Errorf("expected errIndexFirstChecksum, got %v", err)
+	}
+}